@@ -0,0 +1,406 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+// Package cli implements the zorm command line tool for SQLite databases.
+// The plan subcommand needs the application's models, so embed the tool in a binary
+// that registers them:
+//
+//	func main() {
+//		cli.RegisterModels(&models.User{}, &models.App{})
+//		os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+//	}
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/IceWhaleTech/zorm"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const usage = `usage: zorm <command> [arguments]
+
+commands:
+  inspect [-json] <db>                           print the schema of a database
+  diff <dbA> <dbB>                               print the DDL turning dbA into dbB,
+                                                 either may be a schema.json from inspect -json
+  migrate [-dir dir] [-table name] <db> up [version]
+  migrate [-dir dir] [-table name] <db> down [steps]
+  migrate [-dir dir] [-table name] <db> status   apply, roll back or list SQL migrations
+  plan <db>                                      print the DDL for the registered models
+  docs [-mermaid] [-title title] <db>            print Markdown docs or a Mermaid ER diagram
+`
+
+var models []interface{}
+
+// RegisterModels registers the models used by the plan subcommand,
+// and whose comment: tags the docs subcommand documents
+func RegisterModels(m ...interface{}) {
+	models = append(models, m...)
+}
+
+// errUsage makes Run print the usage and exit with status 2
+var errUsage = errors.New("invalid arguments")
+
+// Run runs the tool with args, excluding the program name, and returns the exit status
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	ctx := context.Background()
+	var err error
+	switch args[0] {
+	case "inspect":
+		err = inspect(ctx, args[1:], stdout)
+	case "diff":
+		err = diff(ctx, args[1:], stdout)
+	case "migrate":
+		err = migrate(ctx, args[1:], stdout)
+	case "plan":
+		err = plan(ctx, args[1:], stdout)
+	case "docs":
+		err = docs(ctx, args[1:], stdout)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		err = errUsage
+	}
+
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "zorm:", err)
+		return 1
+	}
+	return 0
+}
+
+// openDB opens an existing SQLite database, read-only unless readOnly is false;
+// a mistyped path is an error rather than a new empty database
+func openDB(path string, readOnly bool) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	dsn := "file:" + path + "?mode=rw"
+	if readOnly {
+		dsn = "file:" + path + "?mode=ro"
+	}
+	return sql.Open("sqlite3", dsn)
+}
+
+// readSchema reads the schema of a database, or of a JSON file written by inspect -json
+func readSchema(ctx context.Context, path string) (*zorm.SchemaInfo, error) {
+	if strings.HasSuffix(path, ".json") {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return zorm.ReadSchemaJSON(f)
+	}
+
+	db, err := openDB(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return zorm.NewDDLManager(db, nil).GetCurrentSchema(ctx)
+}
+
+func inspect(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "print the schema as JSON")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	args = fs.Args()
+	if len(args) != 1 {
+		return errUsage
+	}
+	schema, err := readSchema(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return schema.WriteJSON(stdout)
+	}
+
+	names := make([]string, 0, len(schema.Tables))
+	for name := range schema.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		printTable(w, schema.Tables[name])
+	}
+	return w.Flush()
+}
+
+func printTable(w io.Writer, table *zorm.TableInfo) {
+	fmt.Fprintf(w, "table %s\n", table.Name)
+
+	columns := table.ColumnOrder
+	if len(columns) != len(table.Columns) {
+		columns = columns[:0:0]
+		for name := range table.Columns {
+			columns = append(columns, name)
+		}
+		sort.Strings(columns)
+	}
+	primaryKey := make(map[string]bool, len(table.PrimaryKey))
+	for _, key := range table.PrimaryKey {
+		primaryKey[key] = true
+	}
+	for _, name := range columns {
+		col := table.Columns[name]
+		var attrs []string
+		if col.Nullable {
+			attrs = append(attrs, "NULL")
+		} else {
+			attrs = append(attrs, "NOT NULL")
+		}
+		if primaryKey[name] || col.AutoIncrement {
+			attrs = append(attrs, "PRIMARY KEY")
+		}
+		if col.AutoIncrement {
+			attrs = append(attrs, "AUTOINCREMENT")
+		}
+		if col.DefaultValue != "" {
+			attrs = append(attrs, "DEFAULT "+col.DefaultValue)
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", name, col.Type, strings.Join(attrs, " "))
+	}
+
+	indexes := make([]string, 0, len(table.Indexes))
+	for name := range table.Indexes {
+		indexes = append(indexes, name)
+	}
+	sort.Strings(indexes)
+	for _, name := range indexes {
+		idx := table.Indexes[name]
+		kind := "index"
+		if idx.Unique {
+			kind = "unique index"
+		}
+		fmt.Fprintf(w, "  %s %s (%s)\n", kind, name, strings.Join(idx.Columns, ", "))
+	}
+
+	for _, fk := range table.ForeignKeys {
+		fmt.Fprintf(w, "  %s\n", fk.SQL())
+	}
+}
+
+func diff(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	from, err := readSchema(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	to, err := readSchema(ctx, args[1])
+	if err != nil {
+		return err
+	}
+
+	// A JSON source has no database to render table rebuilds against,
+	// the manager then only needs the SQLite dialect
+	render := !strings.HasSuffix(args[0], ".json")
+	var db *sql.DB
+	if render {
+		db, err = openDB(args[0], true)
+	} else {
+		db, err = sql.Open("sqlite3", ":memory:")
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	dm := zorm.NewDDLManager(db, nil)
+	p, err := dm.DiffSchemas(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	if !render {
+		dm = nil
+	}
+	return printPlan(ctx, stdout, dm, p)
+}
+
+// printPlan prints the statements the plan runs on the database of dm, or the plan's own
+// script when dm is nil
+func printPlan(ctx context.Context, stdout io.Writer, dm *zorm.DDLManager, p *zorm.SchemaPlan) error {
+	if len(p.Commands) == 0 {
+		_, err := fmt.Fprintln(stdout, "-- no changes")
+		return err
+	}
+	script := p.Script()
+	if dm != nil {
+		var err error
+		if script, err = dm.Script(ctx, p); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(stdout, script)
+	return err
+}
+
+func migrate(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dir := fs.String("dir", "migrations", "directory of <version>_<name>.up.sql and .down.sql files")
+	table := fs.String("table", zorm.DefaultMigrationTable, "table tracking applied migrations")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	args = fs.Args()
+	if len(args) < 2 || len(args) > 3 {
+		return errUsage
+	}
+	var n int64
+	if len(args) == 3 {
+		var err error
+		if n, err = strconv.ParseInt(args[2], 10, 64); err != nil || n < 0 {
+			return errUsage
+		}
+	}
+
+	db, err := openDB(args[0], false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m := zorm.NewMigrator(db).WithTable(*table)
+	if err := m.RegisterFS(os.DirFS(*dir), "."); err != nil {
+		return err
+	}
+
+	var done []*zorm.Migration
+	switch args[1] {
+	case "up":
+		done, err = m.UpTo(ctx, n)
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		done, err = m.Down(ctx, int(n))
+	case "status":
+		if len(args) == 3 {
+			return errUsage
+		}
+		return printStatus(ctx, m, stdout)
+	default:
+		return errUsage
+	}
+
+	for _, mg := range done {
+		fmt.Fprintf(stdout, "%s %s\n", args[1], mg)
+	}
+	if err == nil && len(done) == 0 {
+		fmt.Fprintln(stdout, "nothing to migrate")
+	}
+	return err
+}
+
+func printStatus(ctx context.Context, m *zorm.Migrator, stdout io.Writer) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, st := range status {
+		state, appliedAt := "pending", ""
+		if st.Applied {
+			state, appliedAt = "applied", st.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		switch {
+		case st.Missing:
+			state = "missing"
+		case st.Changed:
+			state = "changed"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", st.Version, st.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
+
+func plan(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if len(models) == 0 {
+		return errors.New("no models registered, build the tool with cli.RegisterModels")
+	}
+	db, err := openDB(args[0], true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	dm := zorm.NewDDLManager(db, nil)
+	p, err := dm.GenerateSchemaPlan(ctx, models)
+	if err != nil {
+		return err
+	}
+	return printPlan(ctx, stdout, dm, p)
+}
+
+func docs(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("docs", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	mermaid := fs.Bool("mermaid", false, "print only the Mermaid ER diagram")
+	title := fs.String("title", "", "top heading of the Markdown docs")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	args = fs.Args()
+	if len(args) != 1 {
+		return errUsage
+	}
+	schema, err := readSchema(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	opts := zorm.DocOptions{Title: *title, Models: models}
+	var out []byte
+	if *mermaid {
+		out, err = zorm.GenerateERDiagram(schema, opts)
+	} else {
+		out, err = zorm.GenerateDocs(schema, opts)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(out)
+	return err
+}
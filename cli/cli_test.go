@@ -0,0 +1,132 @@
+package cli_test
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/IceWhaleTech/zorm/cli"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// ========== Command Line Tool Tests ==========
+func TestCLI(t *testing.T) {
+	Convey("zorm command line tool", t, func() {
+		dir := t.TempDir()
+		exec := func(path, script string) {
+			d, err := sql.Open("sqlite3", path)
+			So(err, ShouldBeNil)
+			defer d.Close()
+			_, err = d.Exec(script)
+			So(err, ShouldBeNil)
+		}
+		run := func(args ...string) (int, string, string) {
+			var stdout, stderr bytes.Buffer
+			code := cli.Run(args, &stdout, &stderr)
+			return code, stdout.String(), stderr.String()
+		}
+
+		a, b := dir+"/a.db", dir+"/b.db"
+		exec(a, `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL DEFAULT '', age INTEGER NOT NULL DEFAULT 0);
+			CREATE INDEX idx_users_name ON users(name);
+			CREATE TABLE old (x TEXT);`)
+		exec(b, `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL DEFAULT '', age INTEGER NOT NULL DEFAULT 0, email TEXT);`)
+
+		Convey("inspect", func() {
+			code, out, _ := run("inspect", a)
+			So(code, ShouldEqual, 0)
+			So(out, ShouldContainSubstring, "table users\n")
+			So(out, ShouldContainSubstring, "DEFAULT ''")
+			So(out, ShouldContainSubstring, "index idx_users_name (name)")
+
+			code, _, errOut := run("inspect", dir+"/missing.db")
+			So(code, ShouldEqual, 1)
+			So(errOut, ShouldContainSubstring, "missing.db")
+		})
+
+		Convey("diff", func() {
+			code, out, _ := run("diff", a, b)
+			So(code, ShouldEqual, 0)
+			So(out, ShouldContainSubstring, "ALTER TABLE `users` ADD COLUMN `email` TEXT;")
+			So(out, ShouldContainSubstring, "-- DROP TABLE old (destructive)\nDROP TABLE `old`;")
+
+			_, out, _ = run("diff", b, b)
+			So(out, ShouldEqual, "-- no changes\n")
+
+			// SQLite rebuilds the table, the script shows the statements that run on a.db
+			c := dir + "/c.db"
+			exec(c, `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL DEFAULT '', age TEXT);
+				CREATE INDEX idx_users_name ON users(name);
+				CREATE TABLE old (x TEXT);`)
+			_, out, _ = run("diff", a, c)
+			So(out, ShouldNotContainSubstring, "MODIFY COLUMN `age`")
+			So(out, ShouldContainSubstring, "ALTER TABLE `_zorm_new_users` RENAME TO `users`;\nCREATE INDEX idx_users_name ON users(name);\n")
+		})
+
+		Convey("inspect -json and diff with JSON schemas", func() {
+			code, schema, _ := run("inspect", "-json", b)
+			So(code, ShouldEqual, 0)
+			So(schema, ShouldStartWith, "{\n  \"tables\": {")
+			path := dir + "/schema.json"
+			So(os.WriteFile(path, []byte(schema), 0o644), ShouldBeNil)
+
+			_, out, _ := run("diff", path, path)
+			So(out, ShouldEqual, "-- no changes\n")
+			_, out, _ = run("diff", a, path)
+			So(out, ShouldContainSubstring, "ALTER TABLE `users` ADD COLUMN `email` TEXT;")
+		})
+
+		Convey("migrate", func() {
+			migrations := dir + "/migrations"
+			So(os.Mkdir(migrations, 0o755), ShouldBeNil)
+			So(os.WriteFile(migrations+"/0001_t1.up.sql", []byte("CREATE TABLE t1 (id INTEGER)"), 0o644), ShouldBeNil)
+			So(os.WriteFile(migrations+"/0001_t1.down.sql", []byte("DROP TABLE t1"), 0o644), ShouldBeNil)
+			So(os.WriteFile(migrations+"/0002_t2.up.sql", []byte("CREATE TABLE t2 (id INTEGER)"), 0o644), ShouldBeNil)
+			m := dir + "/m.db"
+
+			// A mistyped path doesn't create a new database
+			code, _, errOut := run("migrate", "-dir", migrations, m, "status")
+			So(code, ShouldEqual, 1)
+			So(errOut, ShouldContainSubstring, "m.db")
+			_, err := os.Stat(m)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			exec(m, "")
+			code, out, _ := run("migrate", "-dir", migrations, m, "up", "1")
+			So(code, ShouldEqual, 0)
+			So(out, ShouldEqual, "up 1_t1\n")
+			_, out, _ = run("migrate", "-dir", migrations, m, "status")
+			So(out, ShouldContainSubstring, "1        t1    applied")
+			So(out, ShouldContainSubstring, "2        t2    pending")
+			_, out, _ = run("migrate", "-dir", migrations, m, "down")
+			So(out, ShouldEqual, "down 1_t1\n")
+			_, out, _ = run("migrate", "-dir", migrations, m, "down")
+			So(out, ShouldEqual, "nothing to migrate\n")
+
+			code, _, _ = run("migrate", "-dir", migrations, m, "sideways")
+			So(code, ShouldEqual, 2)
+		})
+
+		Convey("plan", func() {
+			type User struct {
+				ID    int64  `zorm:"id,auto_incr"`
+				Name  string `zorm:"name,default:''"`
+				Age   int    `zorm:"age"`
+				Email *string
+			}
+			cli.RegisterModels(&User{})
+			code, out, _ := run("plan", a)
+			So(code, ShouldEqual, 0)
+			So(out, ShouldEqual, "-- ALTER TABLE users ADD COLUMN (safe)\nALTER TABLE `users` ADD COLUMN `email` TEXT;\n")
+		})
+
+		Convey("usage", func() {
+			code, _, errOut := run("bogus")
+			So(code, ShouldEqual, 2)
+			So(errOut, ShouldStartWith, "usage: zorm")
+		})
+	})
+}
@@ -0,0 +1,73 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+// Command zorm-gen generates Go structs with zorm tags from the tables of a SQLite database.
+//
+//	zorm-gen -db app.db [-pkg models] [-tables users,apps] [-o models/tables.go]
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IceWhaleTech/zorm"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	dbPath := flag.String("db", "", "path of the SQLite database file")
+	pkg := flag.String("pkg", "models", "package name of the generated file")
+	tables := flag.String("tables", "", "comma separated tables to generate, all when empty")
+	output := flag.String("o", "", "output file, stdout when empty")
+	flag.Parse()
+
+	if *dbPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*dbPath, *pkg, *tables, *output); err != nil {
+		fmt.Fprintln(os.Stderr, "zorm-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dbPath, pkg, tables, output string) error {
+	if _, err := os.Stat(dbPath); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	schema, err := zorm.NewDDLManager(db, nil).GetCurrentSchema(context.Background())
+	if err != nil {
+		return err
+	}
+
+	opts := zorm.GenerateOptions{Package: pkg}
+	if tables != "" {
+		opts.Tables = strings.Split(tables, ",")
+	}
+	src, err := zorm.GenerateStructs(schema, opts)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(output, src, 0o644)
+}
@@ -0,0 +1,21 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+// Command zorm inspects, diffs and migrates SQLite databases, see package cli.
+package main
+
+import (
+	"os"
+
+	"github.com/IceWhaleTech/zorm/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
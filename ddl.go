@@ -13,9 +13,14 @@ package zorm
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/modern-go/reflect2"
@@ -28,6 +33,54 @@ type DDLCommand interface {
 	Description() string
 }
 
+// ChangeKind classifies the effect of a DDL command on existing data
+type ChangeKind int
+
+const (
+	// ChangeSafe commands don't touch existing rows, e.g. creating tables, adding or renaming columns
+	ChangeSafe ChangeKind = iota
+	// ChangeDataMoving commands rewrite existing rows, e.g. changing a column type or rebuilding a table
+	ChangeDataMoving
+	// ChangeDestructive commands lose data, e.g. dropping a table or a column
+	ChangeDestructive
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeSafe:
+		return "safe"
+	case ChangeDataMoving:
+		return "data-moving"
+	case ChangeDestructive:
+		return "destructive"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// ErrDestructiveChange is returned when executing a plan with destructive commands without AllowDestructive
+var ErrDestructiveChange = errors.New("schema plan contains destructive changes")
+
+// ClassifyCommand returns the change kind of a command.
+// Custom commands can implement Kind() ChangeKind, others are treated as data-moving.
+func ClassifyCommand(cmd DDLCommand) ChangeKind {
+	switch c := cmd.(type) {
+	case interface{ Kind() ChangeKind }:
+		return c.Kind()
+	case *CreateTableCommand, *CreateIndexCommand, *DropIndexCommand:
+		return ChangeSafe
+	case *DropTableCommand:
+		return ChangeDestructive
+	case *AlterTableCommand:
+		switch c.Operation {
+		case "ADD COLUMN", "RENAME COLUMN":
+			return ChangeSafe
+		case "DROP COLUMN":
+			return ChangeDestructive
+		}
+	}
+	return ChangeDataMoving
+}
+
 // AlterTableCommand represents an ALTER TABLE command
 type AlterTableCommand struct {
 	TableName string
@@ -38,16 +91,79 @@ type AlterTableCommand struct {
 }
 
 func (c *AlterTableCommand) Execute(ctx context.Context, db ZormDBIFace) error {
-	// SQLite doesn't support MODIFY COLUMN, skip this operation
-	if c.Operation == "MODIFY COLUMN" {
-		// For SQLite, MODIFY COLUMN is not supported
-		// Return nil to skip the operation (or implement table reconstruction)
-		return nil
+	// SQLite doesn't support MODIFY COLUMN, and DROP COLUMN fails on indexed columns,
+	// so both rebuild the table instead
+	if c.rebuilds(ctx, db) {
+		cmd, err := c.rebuildCommand(ctx, db)
+		if err != nil {
+			return err
+		}
+		return cmd.Execute(ctx, db)
 	}
 	_, err := db.ExecContext(ctx, c.SQL())
 	return err
 }
 
+// Statements returns the statements Execute runs on db, the table rebuild on SQLite
+func (c *AlterTableCommand) Statements(ctx context.Context, db ZormDBIFace) ([]string, error) {
+	if c.rebuilds(ctx, db) {
+		cmd, err := c.rebuildCommand(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		return cmd.Statements(ctx, db)
+	}
+	return []string{c.SQL()}, nil
+}
+
+func (c *AlterTableCommand) rebuilds(ctx context.Context, db ZormDBIFace) bool {
+	return (c.Operation == "MODIFY COLUMN" || c.Operation == "DROP COLUMN") && isSQLite(ctx, db)
+}
+
+// rebuildCommand applies the column change to the current definition of the table
+func (c *AlterTableCommand) rebuildCommand(ctx context.Context, db ZormDBIFace) (*RebuildTableCommand, error) {
+	columns, primaryKey, err := sqliteTableColumns(ctx, db, c.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	target := make([]*ColumnDef, 0, len(columns))
+	for _, col := range columns {
+		if col.Name != c.Column.Name {
+			target = append(target, col)
+			continue
+		}
+		found = true
+		if c.Operation == "MODIFY COLUMN" {
+			target = append(target, c.Column)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("column %s not found in table %s", c.Column.Name, c.TableName)
+	}
+
+	keys := primaryKey[:0:0]
+	for _, key := range primaryKey {
+		if key != c.Column.Name || c.Operation == "MODIFY COLUMN" {
+			keys = append(keys, key)
+		}
+	}
+
+	foreignKeys, err := sqliteForeignKeys(ctx, db, c.TableName)
+	if err != nil {
+		return nil, err
+	}
+	fks := foreignKeys[:0:0]
+	for _, fk := range foreignKeys {
+		if c.Operation == "MODIFY COLUMN" || !slices.Contains(fk.Columns, c.Column.Name) {
+			fks = append(fks, fk)
+		}
+	}
+
+	return &RebuildTableCommand{TableName: c.TableName, Columns: target, PrimaryKey: keys, ForeignKeys: fks}, nil
+}
+
 func (c *AlterTableCommand) SQL() string {
 	sb := strings.Builder{}
 	sb.WriteString("ALTER TABLE `")
@@ -74,10 +190,7 @@ func (c *AlterTableCommand) SQL() string {
 		sb.WriteString(c.Column.Name)
 		sb.WriteString("`")
 	case "MODIFY COLUMN":
-		// SQLite doesn't support MODIFY COLUMN directly
-		// For SQLite compatibility, we'll skip this operation
-		// In a real implementation, this would require table reconstruction
-		// For now, we'll generate a no-op or skip it
+		// MySQL syntax; on SQLite Execute rebuilds the table instead, see Statements
 		sb.WriteString("`")
 		sb.WriteString(c.Column.Name)
 		sb.WriteString("` ")
@@ -89,8 +202,6 @@ func (c *AlterTableCommand) SQL() string {
 			sb.WriteString(" DEFAULT ")
 			sb.WriteString(c.Column.DefaultValue)
 		}
-		// Note: SQLite doesn't support MODIFY COLUMN, this will fail at execution
-		// Consider implementing table reconstruction for SQLite
 	case "RENAME COLUMN":
 		sb.WriteString("`")
 		sb.WriteString(c.OldName)
@@ -108,10 +219,11 @@ func (c *AlterTableCommand) Description() string {
 
 // CreateIndexCommand represents a CREATE INDEX command
 type CreateIndexCommand struct {
-	IndexName string
-	TableName string
-	Columns   []string
-	Unique    bool
+	IndexName   string
+	TableName   string
+	Columns     []string
+	Unique      bool
+	IfNotExists bool
 }
 
 func (c *CreateIndexCommand) Execute(ctx context.Context, db ZormDBIFace) error {
@@ -125,7 +237,11 @@ func (c *CreateIndexCommand) SQL() string {
 	if c.Unique {
 		sb.WriteString("UNIQUE ")
 	}
-	sb.WriteString("INDEX `")
+	sb.WriteString("INDEX ")
+	if c.IfNotExists {
+		sb.WriteString("IF NOT EXISTS ")
+	}
+	sb.WriteString("`")
 	sb.WriteString(c.IndexName)
 	sb.WriteString("` ON `")
 	sb.WriteString(c.TableName)
@@ -169,9 +285,11 @@ func (c *DropIndexCommand) Description() string {
 
 // CreateTableCommand represents a CREATE TABLE command
 type CreateTableCommand struct {
-	TableName  string
-	Columns    []*ColumnDef
-	PrimaryKey []string
+	TableName   string
+	Columns     []*ColumnDef
+	PrimaryKey  []string
+	UniqueKeys  [][]string
+	ForeignKeys []*ForeignKeyDef
 }
 
 func (c *CreateTableCommand) Execute(ctx context.Context, db ZormDBIFace) error {
@@ -224,6 +342,24 @@ func (c *CreateTableCommand) SQL() string {
 		sb.WriteString(")")
 	}
 
+	for _, key := range c.UniqueKeys {
+		sb.WriteString(",\n  UNIQUE (")
+		for i, col := range key {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("`")
+			sb.WriteString(col)
+			sb.WriteString("`")
+		}
+		sb.WriteString(")")
+	}
+
+	for _, fk := range c.ForeignKeys {
+		sb.WriteString(",\n  ")
+		sb.WriteString(fk.SQL())
+	}
+
 	sb.WriteString("\n)")
 
 	return sb.String()
@@ -266,230 +402,836 @@ func (c *DropTableCommand) Description() string {
 	return fmt.Sprintf("DROP TABLE %s", c.TableName)
 }
 
-// ColumnDef represents a column definition
-type ColumnDef struct {
-	Name          string
-	Type          string
-	Nullable      bool
-	DefaultValue  string
-	AutoIncrement bool
-	Comment       string
-}
-
-// SchemaInfo represents current database schema information
-type SchemaInfo struct {
-	Tables map[string]*TableInfo
+// RebuildTableCommand rebuilds a SQLite table with a new column list, following the
+// 12-step procedure from https://www.sqlite.org/lang_altertable.html: create the new table,
+// copy the data, drop the old table, rename, recreate indexes and triggers and check
+// foreign keys, all in a single transaction.
+// Columns present in both tables are copied by name, others are dropped or filled with defaults.
+type RebuildTableCommand struct {
+	TableName   string
+	Columns     []*ColumnDef
+	PrimaryKey  []string // ignored when a column is AutoIncrement
+	ForeignKeys []*ForeignKeyDef
 }
 
-// TableInfo represents table schema information
-type TableInfo struct {
-	Name    string
-	Columns map[string]*ColumnDef
-	Indexes map[string]*IndexInfo
+func (c *RebuildTableCommand) Execute(ctx context.Context, db ZormDBIFace) error {
+	return runDDLTx(ctx, db, c.rebuild)
 }
 
-// IndexInfo represents index information
-type IndexInfo struct {
-	Name    string
-	Columns []string
-	Unique  bool
-	Primary bool
-}
+// runDDLTx runs fn in a single transaction, nested via savepoint inside one, or directly
+// when db has no transaction support.
+// On SQLite foreign_keys can only be switched off outside a transaction, so a connection is
+// pinned and enforcement suspended, otherwise dropping a referenced table while rebuilding it
+// would cascade deletes into the referencing tables.
+func runDDLTx(ctx context.Context, db ZormDBIFace, fn func(ctx context.Context, db ZormDBIFace) error) error {
+	if d, ok := db.(interface {
+		Conn(ctx context.Context) (*sql.Conn, error)
+	}); ok && isSQLite(ctx, db) {
+		conn, err := d.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
 
-// SchemaPlan represents a plan for database schema changes
-type SchemaPlan struct {
-	Commands []DDLCommand
-	Summary  string
-}
+		var fk int
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fk); err != nil {
+			return err
+		}
+		if fk == 1 {
+			if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+				return err
+			}
+			defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys=ON")
+		}
+		return Tx(ctx, conn, func(tx ZormTxIFace) error {
+			return fn(ctx, tx)
+		})
+	}
 
-// DDLManager manages DDL operations with audit logging
-type DDLManager struct {
-	db     ZormDBIFace
-	logger DDLLogger
+	_, isTx := db.(*ZormTx)
+	if _, ok := db.(interface {
+		BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+	}); ok || isTx {
+		return Tx(ctx, db, func(tx ZormTxIFace) error {
+			return fn(ctx, tx)
+		})
+	}
+	return fn(ctx, db)
 }
 
-// DDLLogger interface for logging DDL operations
-type DDLLogger interface {
-	LogCommand(ctx context.Context, cmd DDLCommand, err error)
-	LogSchemaChange(ctx context.Context, plan *SchemaPlan, err error)
+func (c *RebuildTableCommand) tempName() string {
+	return "_zorm_new_" + c.TableName
 }
 
-// DefaultDDLLogger is a simple console logger
-type DefaultDDLLogger struct{}
+func (c *RebuildTableCommand) rebuild(ctx context.Context, db ZormDBIFace) error {
+	// foreign_keys is still on when db already is a transaction. Deferring the checks doesn't
+	// stop DROP TABLE from firing ON DELETE actions, so refuse to rebuild a referenced table.
+	var fk int
+	if err := db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fk); err != nil {
+		return err
+	}
+	if fk == 1 {
+		referencing, err := sqliteReferencingTables(ctx, db, c.TableName)
+		if err != nil {
+			return err
+		}
+		if len(referencing) > 0 {
+			return fmt.Errorf("rebuild table %s: referenced by %s and foreign_keys can't be switched off inside a transaction, run it outside one",
+				c.TableName, strings.Join(referencing, ", "))
+		}
+		if _, err := db.ExecContext(ctx, "PRAGMA defer_foreign_keys=ON"); err != nil {
+			return err
+		}
+	}
 
-func (l *DefaultDDLLogger) LogCommand(ctx context.Context, cmd DDLCommand, err error) {
-	status := "SUCCESS"
+	stmts, err := c.Statements(ctx, db)
 	if err != nil {
-		status = "FAILED"
+		return err
 	}
-	fmt.Printf("[DDL] %s - %s: %s\n", status, cmd.Description(), cmd.SQL())
-	if err != nil {
-		fmt.Printf("[DDL] Error: %v\n", err)
+	for _, stmt := range stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("rebuild table %s: %w (%s)", c.TableName, err, stmt)
+		}
 	}
-}
 
-func (l *DefaultDDLLogger) LogSchemaChange(ctx context.Context, plan *SchemaPlan, err error) {
-	status := "SUCCESS"
+	// Check the whole database, the rebuilt table may be the parent of the violating rows
+	rows, err := db.QueryContext(ctx, "PRAGMA foreign_key_check")
 	if err != nil {
-		status = "FAILED"
+		return err
 	}
-	fmt.Printf("[SCHEMA] %s - %s\n", status, plan.Summary)
-	fmt.Printf("[SCHEMA] Commands: %d\n", len(plan.Commands))
+	defer rows.Close()
+	if rows.Next() {
+		var table string
+		var rowid sql.NullInt64
+		var parent string
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		return fmt.Errorf("rebuild table %s: foreign key constraint violated in table %s", c.TableName, table)
+	}
+	return rows.Err()
 }
 
-// NewDDLManager creates a new DDL manager
-func NewDDLManager(db ZormDBIFace, logger DDLLogger) *DDLManager {
-	if logger == nil {
-		logger = &DefaultDDLLogger{}
+// Statements returns the statements Execute runs on db, from creating the new table to
+// recreating its indexes and triggers
+func (c *RebuildTableCommand) Statements(ctx context.Context, db ZormDBIFace) ([]string, error) {
+	current, _, err := sqliteTableColumns(ctx, db, c.TableName)
+	if err != nil {
+		return nil, err
 	}
-	return &DDLManager{
-		db:     db,
-		logger: logger,
+	if len(current) == 0 {
+		return nil, fmt.Errorf("table %s not found", c.TableName)
 	}
-}
-
-// GetCurrentSchema retrieves current database schema
-func (dm *DDLManager) GetCurrentSchema(ctx context.Context) (*SchemaInfo, error) {
-	schema := &SchemaInfo{
-		Tables: make(map[string]*TableInfo),
+	currentCols := make(map[string]bool, len(current))
+	for _, col := range current {
+		currentCols[col.Name] = true
+	}
+	targetCols := make(map[string]bool, len(c.Columns))
+	for _, col := range c.Columns {
+		targetCols[col.Name] = true
 	}
 
-	// Get all tables
-	tables, err := dm.getTables(ctx)
+	// Indexes and triggers go away with the old table, recreate them after the rename
+	schema, err := sqliteSchemaObjects(ctx, db, c.TableName, targetCols)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, tableName := range tables {
-		tableInfo, err := dm.getTableInfo(ctx, tableName)
-		if err != nil {
-			return nil, err
-		}
-		schema.Tables[tableName] = tableInfo
+	uniqueKeys, err := sqliteUniqueConstraints(ctx, db, c.TableName)
+	if err != nil {
+		return nil, err
 	}
-
-	return schema, nil
-}
-
-// getTables retrieves all table names
-func (dm *DDLManager) getTables(ctx context.Context) ([]string, error) {
-	var tables []string
-
-	// Try SQLite first
-	rows, err := dm.db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var name string
-			if err := rows.Scan(&name); err != nil {
-				return nil, err
-			}
-			tables = append(tables, name)
+	create := c.createCommand()
+	for _, key := range uniqueKeys {
+		keep := true
+		for _, col := range key {
+			keep = keep && targetCols[col]
+		}
+		if keep {
+			create.UniqueKeys = append(create.UniqueKeys, key)
 		}
-		return tables, nil
 	}
 
-	// Fallback to MySQL/PostgreSQL
-	rows, err = dm.db.QueryContext(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()")
-	if err != nil {
-		// Try PostgreSQL
-		rows, err = dm.db.QueryContext(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public'")
+	stmts := []string{
+		"DROP TABLE IF EXISTS `" + c.tempName() + "`",
+		create.SQL(),
+		c.copySQL(currentCols),
+		"DROP TABLE `" + c.TableName + "`",
+		"ALTER TABLE `" + c.tempName() + "` RENAME TO `" + c.TableName + "`",
 	}
+	stmts = append(stmts, schema...)
+	return stmts, nil
+}
+
+// sqliteReferencingTables returns the tables with a foreign key to tableName, including itself
+func sqliteReferencingTables(ctx context.Context, db ZormDBIFace, tableName string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
+	var tables []string
 	for rows.Next() {
 		var name string
 		if err := rows.Scan(&name); err != nil {
+			rows.Close()
 			return nil, err
 		}
 		tables = append(tables, name)
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return tables, nil
+	var referencing []string
+	for _, name := range tables {
+		fks, err := sqliteForeignKeys(ctx, db, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, fk := range fks {
+			if strings.EqualFold(fk.RefTable, tableName) {
+				referencing = append(referencing, name)
+				break
+			}
+		}
+	}
+	return referencing, nil
 }
 
-// getTableInfo retrieves table schema information
-func (dm *DDLManager) getTableInfo(ctx context.Context, tableName string) (*TableInfo, error) {
-	tableInfo := &TableInfo{
-		Name:    tableName,
-		Columns: make(map[string]*ColumnDef),
-		Indexes: make(map[string]*IndexInfo),
+// copySQL copies the columns kept from the old table, NULLs are replaced by the
+// default of columns that became NOT NULL
+func (c *RebuildTableCommand) copySQL(currentCols map[string]bool) string {
+	var cols, exprs []string
+	for _, col := range c.Columns {
+		if !currentCols[col.Name] {
+			continue
+		}
+		cols = append(cols, "`"+col.Name+"`")
+		if !col.Nullable && !col.AutoIncrement && col.DefaultValue != "" {
+			exprs = append(exprs, "COALESCE(`"+col.Name+"`, "+col.DefaultValue+")")
+		} else {
+			exprs = append(exprs, "`"+col.Name+"`")
+		}
 	}
+	return "INSERT INTO `" + c.tempName() + "` (" + strings.Join(cols, ", ") + ") SELECT " +
+		strings.Join(exprs, ", ") + " FROM `" + c.TableName + "`"
+}
 
-	// Get columns
-	columns, err := dm.getColumns(ctx, tableName)
-	if err != nil {
-		return nil, err
+func (c *RebuildTableCommand) createCommand() *CreateTableCommand {
+	return &CreateTableCommand{
+		TableName:   c.tempName(),
+		Columns:     c.Columns,
+		PrimaryKey:  c.PrimaryKey,
+		ForeignKeys: c.ForeignKeys,
 	}
-	tableInfo.Columns = columns
+}
 
-	// Get indexes
-	indexes, err := dm.getIndexes(ctx, tableName)
-	if err != nil {
-		return nil, err
+// SQL renders the rebuild assuming all columns exist in the old table. Kept unique
+// constraints, indexes and triggers depend on the database, Statements returns them.
+func (c *RebuildTableCommand) SQL() string {
+	currentCols := make(map[string]bool, len(c.Columns))
+	for _, col := range c.Columns {
+		currentCols[col.Name] = true
 	}
-	tableInfo.Indexes = indexes
+	return strings.Join([]string{
+		c.createCommand().SQL(),
+		c.copySQL(currentCols),
+		"DROP TABLE `" + c.TableName + "`",
+		"ALTER TABLE `" + c.tempName() + "` RENAME TO `" + c.TableName + "`",
+	}, ";\n")
+}
 
-	return tableInfo, nil
+func (c *RebuildTableCommand) Description() string {
+	return fmt.Sprintf("REBUILD TABLE %s", c.TableName)
 }
 
-// getColumns retrieves column information for a table
-func (dm *DDLManager) getColumns(ctx context.Context, tableName string) (map[string]*ColumnDef, error) {
-	columns := make(map[string]*ColumnDef)
+// isSQLite reports whether db is a SQLite database
+func isSQLite(ctx context.Context, db ZormDBIFace) bool {
+	var version string
+	return db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version) == nil
+}
 
-	// Try SQLite first
-	rows, err := dm.db.QueryContext(ctx, "PRAGMA table_info(`"+tableName+"`)")
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var cid int
-			var name, dataType string
-			var notNull, pk int
-			var defaultValue sql.NullString
+// sqliteTableColumns returns the columns of a table in declaration order and its primary key
+func sqliteTableColumns(ctx context.Context, db ZormDBIFace, tableName string) ([]*ColumnDef, []string, error) {
+	var createSQL string
+	err := db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&createSQL)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
 
-			err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk)
-			if err != nil {
-				return nil, err
-			}
+	rows, err := db.QueryContext(ctx, "PRAGMA table_info(`"+tableName+"`)")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
 
-			// Check if column is auto increment (PRIMARY KEY with INTEGER type)
-			isAutoIncr := pk == 1 && (dataType == "INTEGER" || strings.HasPrefix(strings.ToUpper(dataType), "INTEGER"))
+	var (
+		columns []*ColumnDef
+		pkPos   []int
+		pkCols  []string
+	)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, dataType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, &ColumnDef{
+			Name:         name,
+			Type:         dataType,
+			Nullable:     notNull == 0,
+			DefaultValue: defaultValue.String,
+		})
+		if pk > 0 {
+			pkPos = append(pkPos, pk)
+			pkCols = append(pkCols, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-			columns[name] = &ColumnDef{
-				Name:          name,
-				Type:          dataType,
-				Nullable:      notNull == 0,
-				DefaultValue:  defaultValue.String,
-				AutoIncrement: isAutoIncr,
+	var primaryKey []string
+	if len(pkCols) > 0 {
+		primaryKey = make([]string, len(pkCols))
+	}
+	for i, pos := range pkPos {
+		primaryKey[pos-1] = pkCols[i]
+	}
+	if len(primaryKey) == 1 && strings.Contains(strings.ToUpper(createSQL), "AUTOINCREMENT") {
+		for _, col := range columns {
+			if col.Name == primaryKey[0] {
+				col.AutoIncrement = true
 			}
 		}
-		return columns, nil
 	}
+	return columns, primaryKey, nil
+}
 
-	// Fallback to MySQL/PostgreSQL
-	query := `
-		SELECT 
-			column_name, 
-			data_type, 
-			is_nullable, 
-			column_default,
-			extra
-		FROM information_schema.columns 
-		WHERE table_name = ? AND table_schema = DATABASE()
-	`
-	rows, err = dm.db.QueryContext(ctx, query, tableName)
+// sqliteSchemaObjects returns the CREATE statements of the explicit indexes and triggers of a table,
+// skipping indexes on columns that are dropped
+func sqliteSchemaObjects(ctx context.Context, db ZormDBIFace, tableName string, keep map[string]bool) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT type, name, sql FROM sqlite_master WHERE tbl_name=? AND type IN ('index', 'trigger') AND sql IS NOT NULL ORDER BY type", tableName)
 	if err != nil {
-		// Try PostgreSQL
+		return nil, err
+	}
+	type object struct{ typ, name, sql string }
+	var objects []object
+	for rows.Next() {
+		var o object
+		if err := rows.Scan(&o.typ, &o.name, &o.sql); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	rows.Close()
+
+	var stmts []string
+	for _, o := range objects {
+		if o.typ == "index" {
+			cols, err := sqliteIndexColumns(ctx, db, o.name)
+			if err != nil {
+				return nil, err
+			}
+			dropped := false
+			for _, col := range cols {
+				// expression indexes report an empty column name
+				dropped = dropped || (col != "" && !keep[col])
+			}
+			if dropped {
+				continue
+			}
+		}
+		stmts = append(stmts, o.sql)
+	}
+	return stmts, nil
+}
+
+func sqliteIndexColumns(ctx context.Context, db ZormDBIFace, indexName string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA index_info(`"+indexName+"`)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var seqno, cid int
+		var name sql.NullString
+		if err := rows.Scan(&seqno, &cid, &name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name.String)
+	}
+	return columns, rows.Err()
+}
+
+// sqliteUniqueConstraints returns the columns of UNIQUE constraints declared in the table definition
+func sqliteUniqueConstraints(ctx context.Context, db ZormDBIFace, tableName string) ([][]string, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA index_list(`"+tableName+"`)")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var seq, unique, partial int
+		var name, origin string
+		if err := rows.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if origin == "u" {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	var keys [][]string
+	for _, name := range names {
+		cols, err := sqliteIndexColumns(ctx, db, name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, cols)
+	}
+	return keys, nil
+}
+
+// sqliteForeignKeys returns the foreign keys of a table
+func sqliteForeignKeys(ctx context.Context, db ZormDBIFace, tableName string) ([]*ForeignKeyDef, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA foreign_key_list(`"+tableName+"`)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fks []*ForeignKeyDef
+	byID := make(map[int]*ForeignKeyDef)
+	for rows.Next() {
+		var id, seq int
+		var table, from, onUpdate, onDelete, match string
+		var to sql.NullString // NULL when referencing the primary key implicitly
+		if err := rows.Scan(&id, &seq, &table, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, err
+		}
+		fk, ok := byID[id]
+		if !ok {
+			fk = &ForeignKeyDef{RefTable: table}
+			if onDelete != "NO ACTION" {
+				fk.OnDelete = onDelete
+			}
+			if onUpdate != "NO ACTION" {
+				fk.OnUpdate = onUpdate
+			}
+			byID[id] = fk
+			fks = append(fks, fk)
+		}
+		fk.Columns = append(fk.Columns, from)
+		fk.RefColumns = append(fk.RefColumns, to.String)
+	}
+	return fks, rows.Err()
+}
+
+// ColumnDef represents a column definition
+type ColumnDef struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Nullable      bool   `json:"nullable,omitempty"`
+	DefaultValue  string `json:"default,omitempty"`
+	AutoIncrement bool   `json:"auto_increment,omitempty"`
+	Comment       string `json:"comment,omitempty"`
+
+	impliedDefault bool // DefaultValue comes from the Go type of a model field, not a default: tag
+}
+
+// ForeignKeyDef represents a foreign key constraint
+type ForeignKeyDef struct {
+	Columns    []string `json:"columns"`
+	RefTable   string   `json:"ref_table"`
+	RefColumns []string `json:"ref_columns"`
+	OnDelete   string   `json:"on_delete,omitempty"` // CASCADE, SET NULL, SET DEFAULT, RESTRICT; empty means NO ACTION
+	OnUpdate   string   `json:"on_update,omitempty"`
+}
+
+// SQL returns the FOREIGN KEY table constraint
+func (fk *ForeignKeyDef) SQL() string {
+	sb := strings.Builder{}
+	sb.WriteString("FOREIGN KEY (`")
+	sb.WriteString(strings.Join(fk.Columns, "`, `"))
+	sb.WriteString("`) REFERENCES `")
+	sb.WriteString(fk.RefTable)
+	sb.WriteString("` (`")
+	sb.WriteString(strings.Join(fk.RefColumns, "`, `"))
+	sb.WriteString("`)")
+	if fk.OnDelete != "" {
+		sb.WriteString(" ON DELETE ")
+		sb.WriteString(fk.OnDelete)
+	}
+	if fk.OnUpdate != "" {
+		sb.WriteString(" ON UPDATE ")
+		sb.WriteString(fk.OnUpdate)
+	}
+	return sb.String()
+}
+
+func (fk *ForeignKeyDef) equal(other *ForeignKeyDef) bool {
+	action := func(a string) string {
+		if a == "" {
+			return "NO ACTION"
+		}
+		return strings.ToUpper(a)
+	}
+	return slices.Equal(fk.Columns, other.Columns) &&
+		strings.EqualFold(fk.RefTable, other.RefTable) &&
+		slices.Equal(fk.RefColumns, other.RefColumns) &&
+		action(fk.OnDelete) == action(other.OnDelete) &&
+		action(fk.OnUpdate) == action(other.OnUpdate)
+}
+
+// SchemaInfo represents current database schema information
+type SchemaInfo struct {
+	Tables map[string]*TableInfo `json:"tables"`
+}
+
+// TableInfo represents table schema information
+type TableInfo struct {
+	Name        string                `json:"name"`
+	Columns     map[string]*ColumnDef `json:"columns"`
+	Indexes     map[string]*IndexInfo `json:"indexes,omitempty"`
+	ColumnOrder []string              `json:"column_order,omitempty"` // column names in declaration order, SQLite only
+	PrimaryKey  []string              `json:"primary_key,omitempty"`  // SQLite only
+	ForeignKeys []*ForeignKeyDef      `json:"foreign_keys,omitempty"` // SQLite only
+}
+
+// IndexInfo represents index information
+type IndexInfo struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+	Primary bool     `json:"primary,omitempty"`
+}
+
+// WriteJSON writes the schema as indented JSON with sorted keys, e.g. to ship the reference
+// schema of a release and compare databases against it with ReadSchemaJSON and DiffSchemas
+func (s *SchemaInfo) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// ReadSchemaJSON reads a schema written by WriteJSON
+func ReadSchemaJSON(r io.Reader) (*SchemaInfo, error) {
+	var schema SchemaInfo
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+	if schema.Tables == nil {
+		return nil, errors.New("read schema: no tables")
+	}
+
+	// Names are optional in the maps, the keys are authoritative
+	for name, table := range schema.Tables {
+		if table == nil {
+			return nil, fmt.Errorf("read schema: table %s is null", name)
+		}
+		table.Name = name
+		if table.Columns == nil {
+			table.Columns = make(map[string]*ColumnDef)
+		}
+		if table.Indexes == nil {
+			table.Indexes = make(map[string]*IndexInfo)
+		}
+		for colName, col := range table.Columns {
+			if col == nil {
+				return nil, fmt.Errorf("read schema: column %s.%s is null", name, colName)
+			}
+			col.Name = colName
+		}
+		for idxName, idx := range table.Indexes {
+			if idx == nil {
+				return nil, fmt.Errorf("read schema: index %s is null", idxName)
+			}
+			idx.Name = idxName
+		}
+	}
+	return &schema, nil
+}
+
+// SchemaPlan represents a plan for database schema changes
+type SchemaPlan struct {
+	Commands []DDLCommand
+	Summary  string
+}
+
+// Kinds returns the change kind of each command
+func (p *SchemaPlan) Kinds() []ChangeKind {
+	kinds := make([]ChangeKind, len(p.Commands))
+	for i, cmd := range p.Commands {
+		kinds[i] = ClassifyCommand(cmd)
+	}
+	return kinds
+}
+
+// Destructive returns the commands that lose data
+func (p *SchemaPlan) Destructive() []DDLCommand {
+	var cmds []DDLCommand
+	for _, cmd := range p.Commands {
+		if ClassifyCommand(cmd) == ChangeDestructive {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// DDLManager manages DDL operations with audit logging
+type DDLManager struct {
+	db               ZormDBIFace
+	logger           DDLLogger
+	allowDestructive bool
+	allowDataMoving  bool
+}
+
+// DDLLogger interface for logging DDL operations
+type DDLLogger interface {
+	LogCommand(ctx context.Context, cmd DDLCommand, err error)
+	LogSchemaChange(ctx context.Context, plan *SchemaPlan, err error)
+}
+
+// DefaultDDLLogger is a simple console logger
+type DefaultDDLLogger struct{}
+
+func (l *DefaultDDLLogger) LogCommand(ctx context.Context, cmd DDLCommand, err error) {
+	status := "SUCCESS"
+	if err != nil {
+		status = "FAILED"
+	}
+	fmt.Printf("[DDL] %s - %s: %s\n", status, cmd.Description(), cmd.SQL())
+	if err != nil {
+		fmt.Printf("[DDL] Error: %v\n", err)
+	}
+}
+
+func (l *DefaultDDLLogger) LogSchemaChange(ctx context.Context, plan *SchemaPlan, err error) {
+	status := "SUCCESS"
+	if err != nil {
+		status = "FAILED"
+	}
+	fmt.Printf("[SCHEMA] %s - %s\n", status, plan.Summary)
+	fmt.Printf("[SCHEMA] Commands: %d\n", len(plan.Commands))
+}
+
+// NewDDLManager creates a new DDL manager
+func NewDDLManager(db ZormDBIFace, logger DDLLogger) *DDLManager {
+	if logger == nil {
+		logger = &DefaultDDLLogger{}
+	}
+	return &DDLManager{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// AllowDestructive allows executing plans that drop tables or columns
+func (dm *DDLManager) AllowDestructive(allow bool) *DDLManager {
+	dm.allowDestructive = allow
+	return dm
+}
+
+// AllowDataMoving lets CreateTables run commands that copy data, like modifying columns
+// or rebuilding SQLite tables. ExecuteSchemaPlan runs them either way.
+func (dm *DDLManager) AllowDataMoving(allow bool) *DDLManager {
+	dm.allowDataMoving = allow
+	return dm
+}
+
+// GetCurrentSchema retrieves current database schema
+func (dm *DDLManager) GetCurrentSchema(ctx context.Context) (*SchemaInfo, error) {
+	schema := &SchemaInfo{
+		Tables: make(map[string]*TableInfo),
+	}
+
+	// Get all tables
+	tables, err := dm.getTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tableName := range tables {
+		tableInfo, err := dm.getTableInfo(ctx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		schema.Tables[tableName] = tableInfo
+	}
+
+	return schema, nil
+}
+
+// getTables retrieves all table names
+func (dm *DDLManager) getTables(ctx context.Context) ([]string, error) {
+	var tables []string
+
+	// Try SQLite first
+	rows, err := dm.db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'")
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return nil, err
+			}
+			tables = append(tables, name)
+		}
+		return tables, nil
+	}
+
+	// Fallback to MySQL/PostgreSQL
+	rows, err = dm.db.QueryContext(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()")
+	if err != nil {
+		// Try PostgreSQL
+		rows, err = dm.db.QueryContext(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public'")
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+
+	return tables, nil
+}
+
+// getTableInfo retrieves table schema information
+func (dm *DDLManager) getTableInfo(ctx context.Context, tableName string) (*TableInfo, error) {
+	tableInfo := &TableInfo{
+		Name:    tableName,
+		Columns: make(map[string]*ColumnDef),
+		Indexes: make(map[string]*IndexInfo),
+	}
+
+	// Get columns
+	columns, err := dm.getColumns(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	tableInfo.Columns = columns
+
+	// Get indexes
+	indexes, err := dm.getIndexes(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	tableInfo.Indexes = indexes
+
+	// Get column order and constraints, only supported on SQLite
+	if columns, primaryKey, foreignKeys, err := dm.getConstraints(ctx, tableName); err == nil {
+		for _, col := range columns {
+			tableInfo.ColumnOrder = append(tableInfo.ColumnOrder, col.Name)
+		}
+		tableInfo.PrimaryKey = primaryKey
+		tableInfo.ForeignKeys = foreignKeys
+	}
+
+	return tableInfo, nil
+}
+
+// getConstraints retrieves the ordered columns, primary key and foreign keys of a SQLite table
+func (dm *DDLManager) getConstraints(ctx context.Context, tableName string) ([]*ColumnDef, []string, []*ForeignKeyDef, error) {
+	columns, primaryKey, err := sqliteTableColumns(ctx, dm.db, tableName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	foreignKeys, err := sqliteForeignKeys(ctx, dm.db, tableName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return columns, primaryKey, foreignKeys, nil
+}
+
+// getColumns retrieves column information for a table
+func (dm *DDLManager) getColumns(ctx context.Context, tableName string) (map[string]*ColumnDef, error) {
+	columns := make(map[string]*ColumnDef)
+
+	// Try SQLite first
+	rows, err := dm.db.QueryContext(ctx, "PRAGMA table_info(`"+tableName+"`)")
+	if err == nil {
+		defer rows.Close()
+		// Only INTEGER PRIMARY KEY AUTOINCREMENT is auto increment, a plain INTEGER PRIMARY KEY
+		// is a rowid alias that reuses ids
+		var createSQL string
+		if err := dm.db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type='table' AND name=?", tableName).Scan(&createSQL); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		autoIncrement := strings.Contains(strings.ToUpper(createSQL), "AUTOINCREMENT")
+		pkCount := 0
+		for rows.Next() {
+			var cid int
+			var name, dataType string
+			var notNull, pk int
+			var defaultValue sql.NullString
+
+			err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk)
+			if err != nil {
+				return nil, err
+			}
+
+			isAutoIncr := autoIncrement && pk == 1 && strings.HasPrefix(strings.ToUpper(dataType), "INTEGER")
+			if pk > 0 {
+				pkCount++
+			}
+
+			columns[name] = &ColumnDef{
+				Name:          name,
+				Type:          dataType,
+				Nullable:      notNull == 0,
+				DefaultValue:  defaultValue.String,
+				AutoIncrement: isAutoIncr,
+			}
+		}
+		// Columns of a composite primary key are never auto increment
+		if pkCount > 1 {
+			for _, col := range columns {
+				col.AutoIncrement = false
+			}
+		}
+		return columns, nil
+	}
+
+	// Fallback to MySQL/PostgreSQL
+	query := `
+		SELECT 
+			column_name, 
+			data_type, 
+			is_nullable, 
+			column_default,
+			extra,
+			column_comment
+		FROM information_schema.columns 
+		WHERE table_name = ? AND table_schema = DATABASE()
+	`
+	rows, err = dm.db.QueryContext(ctx, query, tableName)
+	if err != nil {
+		// Try PostgreSQL
 		pgQuery := `
 			SELECT 
 				column_name, 
 				data_type, 
 				is_nullable, 
 				column_default,
-				''
+				'',
+				COALESCE(col_description(to_regclass(table_name)::oid, ordinal_position), '')
 			FROM information_schema.columns 
 			WHERE table_name = $1 AND table_schema = 'public'
 		`
@@ -501,8 +1243,8 @@ func (dm *DDLManager) getColumns(ctx context.Context, tableName string) (map[str
 	defer rows.Close()
 
 	for rows.Next() {
-		var name, dataType, isNullable, defaultValue, extra string
-		err := rows.Scan(&name, &dataType, &isNullable, &defaultValue, &extra)
+		var name, dataType, isNullable, defaultValue, extra, comment string
+		err := rows.Scan(&name, &dataType, &isNullable, &defaultValue, &extra, &comment)
 		if err != nil {
 			return nil, err
 		}
@@ -513,6 +1255,7 @@ func (dm *DDLManager) getColumns(ctx context.Context, tableName string) (map[str
 			Nullable:      isNullable == "YES",
 			DefaultValue:  defaultValue,
 			AutoIncrement: strings.Contains(extra, "auto_increment"),
+			Comment:       comment,
 		}
 	}
 
@@ -526,7 +1269,7 @@ func (dm *DDLManager) getIndexes(ctx context.Context, tableName string) (map[str
 	// Try SQLite first
 	rows, err := dm.db.QueryContext(ctx, "PRAGMA index_list(`"+tableName+"`)")
 	if err == nil {
-		defer rows.Close()
+		var list []*IndexInfo
 		for rows.Next() {
 			var seq int
 			var name string
@@ -536,6 +1279,7 @@ func (dm *DDLManager) getIndexes(ctx context.Context, tableName string) (map[str
 
 			err := rows.Scan(&seq, &name, &unique, &origin, &partial)
 			if err != nil {
+				rows.Close()
 				return nil, err
 			}
 
@@ -544,18 +1288,25 @@ func (dm *DDLManager) getIndexes(ctx context.Context, tableName string) (map[str
 				continue
 			}
 
-			// Get index columns
-			indexColumns, err := dm.getIndexColumns(ctx, name)
-			if err != nil {
-				return nil, err
-			}
-
-			indexes[name] = &IndexInfo{
+			list = append(list, &IndexInfo{
 				Name:    name,
-				Columns: indexColumns,
 				Unique:  unique == 1,
 				Primary: origin == "pk",
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		// Get index columns once the list is closed, so the connection that just loaded
+		// the schema is reused, another one may not know indexes created since
+		for _, idx := range list {
+			idx.Columns, err = dm.getIndexColumns(ctx, idx.Name)
+			if err != nil {
+				return nil, err
 			}
+			indexes[idx.Name] = idx
 		}
 		return indexes, nil
 	}
@@ -638,99 +1389,335 @@ func (dm *DDLManager) getIndexes(ctx context.Context, tableName string) (map[str
 			Primary: false, // TODO: detect primary key
 		}
 	}
-
-	return indexes, nil
+
+	return indexes, nil
+}
+
+// getIndexColumns retrieves columns for a specific index
+func (dm *DDLManager) getIndexColumns(ctx context.Context, indexName string) ([]string, error) {
+	var columns []string
+
+	rows, err := dm.db.QueryContext(ctx, "PRAGMA index_info(`"+indexName+"`)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var seqno int
+		var cid int
+		var name string
+
+		err := rows.Scan(&seqno, &cid, &name)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, name)
+	}
+
+	return columns, rows.Err()
+}
+
+// GenerateSchemaPlan generates a schema plan to transform current schema to target schema
+func (dm *DDLManager) GenerateSchemaPlan(ctx context.Context, targetModels []interface{}) (*SchemaPlan, error) {
+	currentSchema, err := dm.GetCurrentSchema(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []DDLCommand
+	var summary strings.Builder
+	sqlite := isSQLite(ctx, dm.db)
+
+	for _, model := range targetModels {
+		tableName := getTableName(model)
+		targetColumns, err := getModelColumns(model)
+		if err != nil {
+			return nil, err
+		}
+
+		targetIndexes, err := getModelIndexes(tableName, model)
+		if err != nil {
+			return nil, err
+		}
+
+		primaryKey, foreignKeys, err := getModelConstraints(model)
+		if err != nil {
+			return nil, err
+		}
+
+		currentTable, exists := currentSchema.Tables[tableName]
+		if !exists {
+			// Table doesn't exist, create it
+			createCmd, err := dm.createTableCommand(tableName, targetColumns, primaryKey, foreignKeys)
+			if err != nil {
+				return nil, err
+			}
+			commands = append(commands, createCmd)
+			for _, idx := range targetIndexes {
+				commands = append(commands, createIndexCommand(tableName, idx))
+			}
+			summary.WriteString(fmt.Sprintf("Create table %s; ", tableName))
+		} else {
+			// Table exists, check for constraint, column and index differences
+			renames, err := getModelRenames(model, currentTable, targetColumns)
+			if err != nil {
+				return nil, err
+			}
+
+			var tableCommands []DDLCommand
+			if sqlite && constraintsMissing(currentTable, primaryKey, foreignKeys) {
+				// SQLite can't add constraints to an existing table, rebuild it with the target columns
+				rebuildCmd, err := dm.rebuildTableCommand(ctx, currentTable, targetColumns, renames, primaryKey, foreignKeys)
+				if err != nil {
+					return nil, err
+				}
+				tableCommands = append(renameCommands(tableName, renames), rebuildCmd)
+			} else {
+				tableCommands, err = dm.generateTableSchemaCommands(tableName, currentTable, targetColumns, renames, sqlite)
+				if err != nil {
+					return nil, err
+				}
+			}
+			// Undeclared indexes are only dropped when the model declares indexes at all,
+			// so indexes created by hand on tables without index tags are kept
+			dropIndexes, createIndexes := dm.generateIndexCommands(tableName, currentTable, targetIndexes, len(targetIndexes) > 0)
+			tableCommands = append(append(dropIndexes, tableCommands...), createIndexes...)
+			commands = append(commands, tableCommands...)
+			if len(tableCommands) > 0 {
+				summary.WriteString(fmt.Sprintf("Update table %s; ", tableName))
+			}
+		}
+	}
+
+	return &SchemaPlan{
+		Commands: commands,
+		Summary:  strings.TrimSpace(summary.String()),
+	}, nil
+}
+
+// DiffSchemas generates a schema plan that turns the from schema into the to schema,
+// e.g. to check a database against the reference schema of a release read with ReadSchemaJSON.
+// Tables, columns with their types and defaults, indexes, and on SQLite primary and foreign keys
+// are compared; the plan is empty when the schemas match. Tables and columns missing from to
+// are dropped, which needs AllowDestructive to execute.
+func (dm *DDLManager) DiffSchemas(ctx context.Context, from, to *SchemaInfo) (*SchemaPlan, error) {
+	var commands []DDLCommand
+	var summary strings.Builder
+	sqlite := isSQLite(ctx, dm.db)
+
+	for _, tableName := range sortedTableNames(to) {
+		target := to.Tables[tableName]
+		targetIndexes := sortedIndexes(target)
+		current, exists := from.Tables[tableName]
+		if !exists {
+			commands = append(commands, createTableFromInfo(target))
+			for _, idx := range targetIndexes {
+				commands = append(commands, createIndexCommand(tableName, idx))
+			}
+			summary.WriteString(fmt.Sprintf("Create table %s; ", tableName))
+			continue
+		}
+
+		tableCommands, err := dm.generateTableSchemaCommands(tableName, current, target.Columns, nil, sqlite)
+		if err != nil {
+			return nil, err
+		}
+		if sqlite && constraintsDiffer(current, target) {
+			// SQLite can't alter constraints, rebuild the table with the target definition.
+			// Dropped columns stay separate commands so they are still gated as destructive.
+			var drops []DDLCommand
+			for _, cmd := range tableCommands {
+				if alter, ok := cmd.(*AlterTableCommand); ok && alter.Operation == "DROP COLUMN" {
+					drops = append(drops, cmd)
+				}
+			}
+			tableCommands = append(drops, &RebuildTableCommand{
+				TableName:   tableName,
+				Columns:     orderedColumns(target),
+				PrimaryKey:  target.PrimaryKey,
+				ForeignKeys: target.ForeignKeys,
+			})
+		}
+		dropIndexes, createIndexes := dm.generateIndexCommands(tableName, current, targetIndexes, true)
+		tableCommands = append(append(dropIndexes, tableCommands...), createIndexes...)
+		commands = append(commands, tableCommands...)
+		if len(tableCommands) > 0 {
+			summary.WriteString(fmt.Sprintf("Update table %s; ", tableName))
+		}
+	}
+
+	for _, tableName := range sortedTableNames(from) {
+		if _, exists := to.Tables[tableName]; !exists {
+			commands = append(commands, &DropTableCommand{TableName: tableName})
+			summary.WriteString(fmt.Sprintf("Drop table %s; ", tableName))
+		}
+	}
+
+	return &SchemaPlan{
+		Commands: commands,
+		Summary:  strings.TrimSpace(summary.String()),
+	}, nil
+}
+
+// constraintsDiffer reports whether the primary or foreign keys of two tables differ
+func constraintsDiffer(current, target *TableInfo) bool {
+	if !slices.Equal(current.PrimaryKey, target.PrimaryKey) || len(current.ForeignKeys) != len(target.ForeignKeys) {
+		return true
+	}
+	for _, fk := range target.ForeignKeys {
+		if !slices.ContainsFunc(current.ForeignKeys, fk.equal) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedIndexes returns the non-primary indexes of a table by name
+func sortedIndexes(table *TableInfo) []*IndexInfo {
+	indexes := make([]*IndexInfo, 0, len(table.Indexes))
+	for _, idx := range table.Indexes {
+		if !idx.Primary {
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].Name < indexes[j].Name })
+	return indexes
 }
 
-// getIndexColumns retrieves columns for a specific index
-func (dm *DDLManager) getIndexColumns(ctx context.Context, indexName string) ([]string, error) {
-	var columns []string
-
-	rows, err := dm.db.QueryContext(ctx, "PRAGMA index_info(`"+indexName+"`)")
-	if err != nil {
-		return nil, err
+func sortedTableNames(schema *SchemaInfo) []string {
+	names := make([]string, 0, len(schema.Tables))
+	for name := range schema.Tables {
+		if !strings.HasPrefix(name, "sqlite_") {
+			names = append(names, name)
+		}
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var seqno int
-		var cid int
-		var name string
+	sort.Strings(names)
+	return names
+}
 
-		err := rows.Scan(&seqno, &cid, &name)
-		if err != nil {
-			return nil, err
+// createTableFromInfo creates a CREATE TABLE command reproducing a table of a schema
+func createTableFromInfo(table *TableInfo) *CreateTableCommand {
+	cmd := &CreateTableCommand{
+		TableName:   table.Name,
+		Columns:     orderedColumns(table),
+		PrimaryKey:  table.PrimaryKey,
+		ForeignKeys: table.ForeignKeys,
+	}
+	if len(cmd.PrimaryKey) == 0 {
+		for _, col := range cmd.Columns {
+			if col.AutoIncrement {
+				cmd.PrimaryKey = append(cmd.PrimaryKey, col.Name)
+			}
 		}
-
-		columns = append(columns, name)
 	}
+	return cmd
+}
 
-	return columns, nil
+// SchemaPlanError reports the command of a schema plan that failed
+type SchemaPlanError struct {
+	Index   int // position of the command in SchemaPlan.Commands
+	Command DDLCommand
+	Err     error
 }
 
-// GenerateSchemaPlan generates a schema plan to transform current schema to target schema
-func (dm *DDLManager) GenerateSchemaPlan(ctx context.Context, targetModels []interface{}) (*SchemaPlan, error) {
-	currentSchema, err := dm.GetCurrentSchema(ctx)
-	if err != nil {
-		return nil, err
-	}
+func (e *SchemaPlanError) Error() string {
+	return fmt.Sprintf("failed to execute command #%d %s: %v", e.Index+1, e.Command.Description(), e.Err)
+}
 
-	var commands []DDLCommand
-	var summary strings.Builder
+func (e *SchemaPlanError) Unwrap() error {
+	return e.Err
+}
 
-	for _, model := range targetModels {
-		tableName := getTableName(model)
-		targetColumns, err := dm.getModelColumns(model)
-		if err != nil {
-			return nil, err
+// ExecuteSchemaPlan executes a schema plan in a single transaction when the database supports it,
+// so a failing command rolls back the whole plan. A failing command is reported as a *SchemaPlanError.
+// Plans with destructive commands are refused with ErrDestructiveChange before anything runs,
+// unless the manager allows them.
+// MySQL commits DDL statements implicitly, so there earlier commands stay applied.
+func (dm *DDLManager) ExecuteSchemaPlan(ctx context.Context, plan *SchemaPlan) error {
+	if destructive := plan.Destructive(); len(destructive) > 0 && !dm.allowDestructive {
+		descs := make([]string, len(destructive))
+		for i, cmd := range destructive {
+			descs[i] = cmd.Description()
 		}
+		err := fmt.Errorf("%w: %s", ErrDestructiveChange, strings.Join(descs, ", "))
+		dm.logger.LogSchemaChange(ctx, plan, err)
+		return err
+	}
 
-		currentTable, exists := currentSchema.Tables[tableName]
-		if !exists {
-			// Table doesn't exist, create it
-			createCmd, err := dm.createTableCommand(tableName, targetColumns)
-			if err != nil {
-				return nil, err
-			}
-			commands = append(commands, createCmd)
-			summary.WriteString(fmt.Sprintf("Create table %s; ", tableName))
-		} else {
-			// Table exists, check for column differences
-			tableCommands, err := dm.generateTableSchemaCommands(tableName, currentTable, targetColumns)
+	err := runDDLTx(ctx, dm.db, func(ctx context.Context, db ZormDBIFace) error {
+		for i, cmd := range plan.Commands {
+			err := cmd.Execute(ctx, db)
+			dm.logger.LogCommand(ctx, cmd, err)
 			if err != nil {
-				return nil, err
-			}
-			commands = append(commands, tableCommands...)
-			if len(tableCommands) > 0 {
-				summary.WriteString(fmt.Sprintf("Update table %s; ", tableName))
+				return &SchemaPlanError{Index: i, Command: cmd, Err: err}
 			}
 		}
-	}
+		return nil
+	})
 
-	return &SchemaPlan{
-		Commands: commands,
-		Summary:  strings.TrimSpace(summary.String()),
-	}, nil
+	dm.logger.LogSchemaChange(ctx, plan, err)
+	return err
 }
 
-// ExecuteSchemaPlan executes a schema plan
-func (dm *DDLManager) ExecuteSchemaPlan(ctx context.Context, plan *SchemaPlan) error {
-	for _, cmd := range plan.Commands {
-		err := cmd.Execute(ctx, dm.db)
-		dm.logger.LogCommand(ctx, cmd, err)
-		if err != nil {
-			dm.logger.LogSchemaChange(ctx, plan, err)
-			return fmt.Errorf("failed to execute command %s: %w", cmd.Description(), err)
+// Script renders the plan as a SQL script without executing it.
+// Commands are rendered by SQL() without looking at a database, so SQLite table rebuilds don't
+// show the indexes and triggers they recreate. DDLManager.Script renders what actually runs.
+func (p *SchemaPlan) Script() string {
+	var sb strings.Builder
+	for _, cmd := range p.Commands {
+		writeScriptCommand(&sb, cmd, []string{cmd.SQL()})
+	}
+	return sb.String()
+}
+
+// Script renders the plan as the statements ExecuteSchemaPlan would run on the manager's
+// database, without executing them. Commands implementing
+// Statements(ctx, db) ([]string, error) are rendered by it, others by SQL().
+// Each command is rendered against the current database, not the result of earlier commands.
+func (dm *DDLManager) Script(ctx context.Context, plan *SchemaPlan) (string, error) {
+	var sb strings.Builder
+	for i, cmd := range plan.Commands {
+		stmts := []string{cmd.SQL()}
+		if c, ok := cmd.(interface {
+			Statements(ctx context.Context, db ZormDBIFace) ([]string, error)
+		}); ok {
+			var err error
+			if stmts, err = c.Statements(ctx, dm.db); err != nil {
+				return "", &SchemaPlanError{Index: i, Command: cmd, Err: err}
+			}
 		}
+		writeScriptCommand(&sb, cmd, stmts)
 	}
+	return sb.String(), nil
+}
+
+func writeScriptCommand(sb *strings.Builder, cmd DDLCommand, stmts []string) {
+	sb.WriteString("-- ")
+	sb.WriteString(cmd.Description())
+	sb.WriteString(" (")
+	sb.WriteString(ClassifyCommand(cmd).String())
+	sb.WriteString(")\n")
+	for _, stmt := range stmts {
+		sb.WriteString(stmt)
+		sb.WriteString(";\n")
+	}
+}
 
-	dm.logger.LogSchemaChange(ctx, plan, nil)
-	return nil
+// DryRun generates the schema plan for the models and returns the statements it would run
+// as a SQL script without executing them
+func (dm *DDLManager) DryRun(ctx context.Context, targetModels ...interface{}) (string, error) {
+	plan, err := dm.GenerateSchemaPlan(ctx, targetModels)
+	if err != nil {
+		return "", err
+	}
+	return dm.Script(ctx, plan)
 }
 
 // getModelColumns extracts column definitions from a model struct
 // Only supports zorm:"auto_incr" tag, ignores all other tags
-func (dm *DDLManager) getModelColumns(model interface{}) (map[string]*ColumnDef, error) {
+func getModelColumns(model interface{}) (map[string]*ColumnDef, error) {
 	rt := reflect2.TypeOf(model)
 	// 解引用所有指针层，直到找到非指针类型
 	for rt.Kind() == reflect.Ptr {
@@ -749,7 +1736,7 @@ func (dm *DDLManager) getModelColumns(model interface{}) (map[string]*ColumnDef,
 		ft := f.Tag().Get("zorm")
 
 		// Skip fields with zorm tag "-"
-		if ft == "-" {
+		if isIgnoredTag(ft) {
 			continue
 		}
 
@@ -760,11 +1747,13 @@ func (dm *DDLManager) getModelColumns(model interface{}) (map[string]*ColumnDef,
 		}
 
 		column := &ColumnDef{
-			Name:          fieldName,
-			Type:          getSQLType(f.Type()),
-			Nullable:      isNullable(f),
-			DefaultValue:  getDefaultValue(f),
-			AutoIncrement: isAutoIncrementField(f),
+			Name:           fieldName,
+			Type:           getColumnType(f),
+			Nullable:       isNullable(f),
+			DefaultValue:   getDefaultValue(f),
+			AutoIncrement:  isAutoIncrementField(f),
+			Comment:        getComment(f),
+			impliedDefault: !hasDefaultTag(f),
 		}
 
 		columns[fieldName] = column
@@ -774,31 +1763,105 @@ func (dm *DDLManager) getModelColumns(model interface{}) (map[string]*ColumnDef,
 }
 
 // createTableCommand creates a CREATE TABLE command
-func (dm *DDLManager) createTableCommand(tableName string, columns map[string]*ColumnDef) (*CreateTableCommand, error) {
+func (dm *DDLManager) createTableCommand(tableName string, columns map[string]*ColumnDef, primaryKey []string, foreignKeys []*ForeignKeyDef) (*CreateTableCommand, error) {
 	var columnList []*ColumnDef
-	var primaryKey []string
 
 	for _, col := range columns {
 		columnList = append(columnList, col)
-		if col.AutoIncrement {
-			primaryKey = append(primaryKey, col.Name)
-		}
 	}
 
 	return &CreateTableCommand{
-		TableName:  tableName,
-		Columns:    columnList,
-		PrimaryKey: primaryKey,
+		TableName:   tableName,
+		Columns:     columnList,
+		PrimaryKey:  primaryKey,
+		ForeignKeys: foreignKeys,
 	}, nil
 }
 
-// generateTableSchemaCommands generates commands to modify a table schema
-func (dm *DDLManager) generateTableSchemaCommands(tableName string, currentTable *TableInfo, targetColumns map[string]*ColumnDef) ([]DDLCommand, error) {
-	var commands []DDLCommand
+// rebuildTableCommand creates a command rebuilding an existing SQLite table with the declared constraints.
+// Model columns replace the current definitions, columns missing from the model are kept,
+// and undeclared constraints are kept when the model declares none.
+func (dm *DDLManager) rebuildTableCommand(ctx context.Context, currentTable *TableInfo, targetColumns map[string]*ColumnDef, renames map[string]string, primaryKey []string, foreignKeys []*ForeignKeyDef) (*RebuildTableCommand, error) {
+	current, _, err := sqliteTableColumns(ctx, dm.db, currentTable.Name)
+	if err != nil {
+		return nil, err
+	}
+	// The renames run before the rebuild
+	renamedFrom := make(map[string]string, len(renames))
+	for newName, oldName := range renames {
+		renamedFrom[oldName] = newName
+	}
+
+	columns := make([]*ColumnDef, 0, len(targetColumns))
+	seen := make(map[string]bool, len(current))
+	for _, col := range current {
+		if newName, ok := renamedFrom[col.Name]; ok {
+			col = targetColumns[newName]
+		} else if target, ok := targetColumns[col.Name]; ok {
+			col = target
+		}
+		seen[col.Name] = true
+		columns = append(columns, col)
+	}
+	var added []string
+	for name := range targetColumns {
+		if !seen[name] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		columns = append(columns, targetColumns[name])
+	}
+
+	if len(primaryKey) == 0 {
+		primaryKey = currentTable.PrimaryKey
+	}
+	if len(foreignKeys) == 0 {
+		foreignKeys = currentTable.ForeignKeys
+	}
+	return &RebuildTableCommand{
+		TableName:   currentTable.Name,
+		Columns:     columns,
+		PrimaryKey:  primaryKey,
+		ForeignKeys: foreignKeys,
+	}, nil
+}
+
+// constraintsMissing reports whether the declared primary key or one of the declared foreign keys
+// is missing from the table
+func constraintsMissing(current *TableInfo, primaryKey []string, foreignKeys []*ForeignKeyDef) bool {
+	if len(primaryKey) > 0 && !slices.Equal(current.PrimaryKey, primaryKey) {
+		return true
+	}
+	for _, fk := range foreignKeys {
+		if !slices.ContainsFunc(current.ForeignKeys, fk.equal) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateTableSchemaCommands generates commands to modify a table schema:
+// renames from was: tags, new columns, modified columns and dropped columns.
+// Dropped columns are destructive and need AllowDestructive to be executed.
+func (dm *DDLManager) generateTableSchemaCommands(tableName string, currentTable *TableInfo, targetColumns map[string]*ColumnDef, renames map[string]string, sqlite bool) ([]DDLCommand, error) {
+	commands := renameCommands(tableName, renames)
+	renamedFrom := make(map[string]bool, len(renames))
+	for newName, oldName := range renames {
+		renamedFrom[oldName] = true
+		if dm.columnChanged(currentTable.Columns[oldName], targetColumns[newName], sqlite) {
+			commands = append(commands, &AlterTableCommand{
+				TableName: tableName,
+				Operation: "MODIFY COLUMN",
+				Column:    targetColumns[newName],
+			})
+		}
+	}
 
 	// Check for new columns
 	for colName, targetCol := range targetColumns {
-		if _, exists := currentTable.Columns[colName]; !exists {
+		if _, exists := currentTable.Columns[colName]; !exists && renames[colName] == "" {
 			cmd := &AlterTableCommand{
 				TableName: tableName,
 				Operation: "ADD COLUMN",
@@ -811,7 +1874,7 @@ func (dm *DDLManager) generateTableSchemaCommands(tableName string, currentTable
 	// Check for modified columns
 	for colName, targetCol := range targetColumns {
 		if currentCol, exists := currentTable.Columns[colName]; exists {
-			if dm.columnChanged(currentCol, targetCol) {
+			if dm.columnChanged(currentCol, targetCol, sqlite) {
 				cmd := &AlterTableCommand{
 					TableName: tableName,
 					Operation: "MODIFY COLUMN",
@@ -822,33 +1885,353 @@ func (dm *DDLManager) generateTableSchemaCommands(tableName string, currentTable
 		}
 	}
 
-	// Check for dropped columns (optional - might want to be more careful)
-	// This is commented out for safety - dropping columns can cause data loss
-	/*
-		for colName := range currentTable.Columns {
-			if _, exists := targetColumns[colName]; !exists {
-				cmd := &AlterTableCommand{
-					TableName: tableName,
-					Operation: "DROP COLUMN",
-					Column:    &ColumnDef{Name: colName},
+	// Check for dropped columns
+	var dropped []string
+	for colName := range currentTable.Columns {
+		if _, exists := targetColumns[colName]; !exists && !renamedFrom[colName] {
+			dropped = append(dropped, colName)
+		}
+	}
+	sort.Strings(dropped)
+	for _, colName := range dropped {
+		commands = append(commands, &AlterTableCommand{
+			TableName: tableName,
+			Operation: "DROP COLUMN",
+			Column:    &ColumnDef{Name: colName},
+		})
+	}
+
+	return commands, nil
+}
+
+// renameCommands creates RENAME COLUMN commands for renames, new name -> old name
+func renameCommands(tableName string, renames map[string]string) []DDLCommand {
+	newNames := make([]string, 0, len(renames))
+	for newName := range renames {
+		newNames = append(newNames, newName)
+	}
+	sort.Strings(newNames)
+
+	var commands []DDLCommand
+	for _, newName := range newNames {
+		commands = append(commands, &AlterTableCommand{
+			TableName: tableName,
+			Operation: "RENAME COLUMN",
+			OldName:   renames[newName],
+			NewName:   newName,
+		})
+	}
+	return commands
+}
+
+// getModelRenames returns the pending renames declared with zorm:"new_name,was:old_name",
+// new name -> old name. A rename is pending when the new column doesn't exist yet and the old one does;
+// several was: tags may list earlier names.
+func getModelRenames(model interface{}, currentTable *TableInfo, targetColumns map[string]*ColumnDef) (map[string]string, error) {
+	rt := reflect2.TypeOf(model)
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.(reflect2.PtrType).Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil, errors.New("model must be a struct")
+	}
+
+	s := rt.(reflect2.StructType)
+	renames := make(map[string]string)
+	sources := make(map[string]string)
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		ft := f.Tag().Get("zorm")
+		if ft == "" || isIgnoredTag(ft) {
+			continue
+		}
+		fieldName := getFieldName(f)
+		if _, exists := currentTable.Columns[fieldName]; fieldName == "" || exists {
+			continue
+		}
+
+		for _, tag := range tagOptions(ft) {
+			kind, oldName, _ := strings.Cut(tag, ":")
+			if kind != "was" {
+				continue
+			}
+			if _, exists := currentTable.Columns[oldName]; !exists {
+				continue
+			}
+			if _, declared := targetColumns[oldName]; declared {
+				return nil, fmt.Errorf("field %s: was:%s is still a column of the model", f.Name(), oldName)
+			}
+			if other, ok := sources[oldName]; ok {
+				return nil, fmt.Errorf("column %s is renamed to both %s and %s", oldName, other, fieldName)
+			}
+			sources[oldName] = fieldName
+			renames[fieldName] = oldName
+			break
+		}
+	}
+	return renames, nil
+}
+
+// generateIndexCommands diffs declared indexes against the current ones.
+// Undeclared indexes are only dropped with dropUndeclared.
+func (dm *DDLManager) generateIndexCommands(tableName string, currentTable *TableInfo, targetIndexes []*IndexInfo, dropUndeclared bool) (drops, creates []DDLCommand) {
+	declared := make(map[string]*IndexInfo, len(targetIndexes))
+	for _, idx := range targetIndexes {
+		declared[idx.Name] = idx
+	}
+
+	var names []string
+	for name := range currentTable.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		current := currentTable.Indexes[name]
+		if current.Primary {
+			continue
+		}
+		target, ok := declared[name]
+		if (!ok && dropUndeclared) || (ok && indexChanged(current, target)) {
+			drops = append(drops, &DropIndexCommand{IndexName: name, TableName: tableName})
+		}
+	}
+
+	for _, idx := range targetIndexes {
+		current, ok := currentTable.Indexes[idx.Name]
+		if !ok || indexChanged(current, idx) {
+			creates = append(creates, createIndexCommand(tableName, idx))
+		}
+	}
+	return drops, creates
+}
+
+func indexChanged(current, target *IndexInfo) bool {
+	if current.Unique != target.Unique || len(current.Columns) != len(target.Columns) {
+		return true
+	}
+	for i := range current.Columns {
+		if current.Columns[i] != target.Columns[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func createIndexCommand(tableName string, idx *IndexInfo) *CreateIndexCommand {
+	return &CreateIndexCommand{
+		IndexName: idx.Name,
+		TableName: tableName,
+		Columns:   idx.Columns,
+		Unique:    idx.Unique,
+	}
+}
+
+// getModelIndexes extracts indexes declared in struct tags:
+//   - zorm:"email,unique"            unique index uniq_<table>_email
+//   - zorm:"user_id,index"           index idx_<table>_user_id
+//   - zorm:"user_id,index:idx_name"  composite index, columns in field order
+//   - zorm:"a,unique:uniq_name"      composite unique index
+func getModelIndexes(tableName string, model interface{}) ([]*IndexInfo, error) {
+	rt := reflect2.TypeOf(model)
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.(reflect2.PtrType).Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil, errors.New("model must be a struct")
+	}
+
+	s := rt.(reflect2.StructType)
+	var indexes []*IndexInfo
+	byName := make(map[string]*IndexInfo)
+	add := func(name, column string, unique bool) error {
+		idx, ok := byName[name]
+		if !ok {
+			idx = &IndexInfo{Name: name, Unique: unique}
+			byName[name] = idx
+			indexes = append(indexes, idx)
+		} else if idx.Unique != unique {
+			return fmt.Errorf("index %s is declared both unique and non-unique", name)
+		}
+		idx.Columns = append(idx.Columns, column)
+		return nil
+	}
+
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		ft := f.Tag().Get("zorm")
+		if ft == "" || isIgnoredTag(ft) {
+			continue
+		}
+		fieldName := getFieldName(f)
+		if fieldName == "" {
+			continue
+		}
+
+		for _, tag := range tagOptions(ft) {
+			kind, name, _ := strings.Cut(tag, ":")
+			var err error
+			switch kind {
+			case "index":
+				if name == "" {
+					name = "idx_" + tableName + "_" + fieldName
 				}
-				commands = append(commands, cmd)
+				err = add(name, fieldName, false)
+			case "unique":
+				if name == "" {
+					name = "uniq_" + tableName + "_" + fieldName
+				}
+				err = add(name, fieldName, true)
+			}
+			if err != nil {
+				return nil, err
 			}
 		}
-	*/
+	}
+	return indexes, nil
+}
 
-	return commands, nil
+// getModelConstraints extracts the primary key and foreign keys declared in struct tags:
+//   - zorm:"id,auto_incr"                           auto increment primary key
+//   - zorm:"user_id,pk"                             primary key, pk fields form a composite key in field order
+//   - zorm:"user_id,fk:users.id"                    foreign key referencing users(id)
+//   - zorm:"user_id,fk:users.id,on_delete:cascade"  with ON DELETE action, on_update:<action> likewise
+func getModelConstraints(model interface{}) ([]string, []*ForeignKeyDef, error) {
+	rt := reflect2.TypeOf(model)
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.(reflect2.PtrType).Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil, nil, errors.New("model must be a struct")
+	}
+
+	s := rt.(reflect2.StructType)
+	var (
+		primaryKey  []string
+		foreignKeys []*ForeignKeyDef
+		autoIncr    string
+	)
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		ft := f.Tag().Get("zorm")
+		if ft == "" || isIgnoredTag(ft) {
+			continue
+		}
+		fieldName := getFieldName(f)
+		if fieldName == "" {
+			continue
+		}
+
+		if isAutoIncrementField(f) {
+			autoIncr = fieldName
+			primaryKey = append(primaryKey, fieldName)
+		}
+
+		var fk *ForeignKeyDef
+		for _, tag := range tagOptions(ft) {
+			kind, value, _ := strings.Cut(tag, ":")
+			switch kind {
+			case "pk":
+				if !isAutoIncrementField(f) {
+					primaryKey = append(primaryKey, fieldName)
+				}
+			case "fk":
+				table, column, ok := strings.Cut(value, ".")
+				if !ok || table == "" || column == "" {
+					return nil, nil, fmt.Errorf("field %s: invalid foreign key %q, expect fk:table.column", f.Name(), value)
+				}
+				fk = &ForeignKeyDef{Columns: []string{fieldName}, RefTable: table, RefColumns: []string{column}}
+				foreignKeys = append(foreignKeys, fk)
+			case "on_delete", "on_update":
+				if fk == nil {
+					return nil, nil, fmt.Errorf("field %s: %s must follow fk", f.Name(), kind)
+				}
+				action := strings.ToUpper(strings.ReplaceAll(value, "_", " "))
+				switch action {
+				case "CASCADE", "SET NULL", "SET DEFAULT", "RESTRICT", "NO ACTION":
+				default:
+					return nil, nil, fmt.Errorf("field %s: invalid %s action %q", f.Name(), kind, value)
+				}
+				if kind == "on_delete" {
+					fk.OnDelete = action
+				} else {
+					fk.OnUpdate = action
+				}
+			}
+		}
+	}
+
+	if autoIncr != "" && len(primaryKey) > 1 {
+		return nil, nil, fmt.Errorf("auto_incr column %s can't be part of a composite primary key", autoIncr)
+	}
+	return primaryKey, foreignKeys, nil
 }
 
-// columnChanged checks if a column definition has changed
-func (dm *DDLManager) columnChanged(current, target *ColumnDef) bool {
-	return current.Type != target.Type ||
+// columnChanged checks if a column definition has changed.
+// On SQLite types are compared by affinity, VARCHAR(64) and TEXT or INTEGER and BIGINT store
+// the same values, so rebuilding the table for them would only rewrite the declared type.
+func (dm *DDLManager) columnChanged(current, target *ColumnDef, sqlite bool) bool {
+	// Auto-increment columns are always created as INTEGER PRIMARY KEY AUTOINCREMENT. An existing
+	// INTEGER PRIMARY KEY without AUTOINCREMENT also assigns ids, and MODIFY COLUMN can't change
+	// AUTOINCREMENT anyway, so only the primary key checks look at these columns.
+	if target.AutoIncrement && (current.AutoIncrement || strings.HasPrefix(strings.ToUpper(current.Type), "INTEGER")) {
+		return false
+	}
+	typeChanged := !strings.EqualFold(current.Type, target.Type)
+	if sqlite {
+		typeChanged = sqliteAffinity(current.Type) != sqliteAffinity(target.Type)
+	}
+	return typeChanged ||
 		current.Nullable != target.Nullable ||
-		current.DefaultValue != target.DefaultValue ||
-		current.AutoIncrement != target.AutoIncrement
+		!defaultsEqual(current, target)
+}
+
+// sqliteAffinity returns the type affinity of a declared column type,
+// following https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+func sqliteAffinity(typ string) string {
+	typ = strings.ToUpper(typ)
+	switch {
+	case strings.Contains(typ, "INT"):
+		return "INTEGER"
+	case strings.Contains(typ, "CHAR"), strings.Contains(typ, "CLOB"), strings.Contains(typ, "TEXT"):
+		return "TEXT"
+	case typ == "" || strings.Contains(typ, "BLOB"):
+		return "BLOB"
+	case strings.Contains(typ, "REAL"), strings.Contains(typ, "FLOA"), strings.Contains(typ, "DOUB"):
+		return "REAL"
+	}
+	return "NUMERIC"
 }
 
-// CreateTables performs atomic table creation using the new DDL system
+// defaultsEqual compares column defaults after normalising how databases report them:
+// surrounding parentheses, NULL, keyword case and number formatting.
+// A default the model only implies from the Go type matches a column without default.
+func defaultsEqual(current, target *ColumnDef) bool {
+	cur, tgt := normalizeDefault(current.DefaultValue), normalizeDefault(target.DefaultValue)
+	if cur == tgt || (cur == "" && target.impliedDefault) {
+		return true
+	}
+	a, errA := strconv.ParseFloat(cur, 64)
+	b, errB := strconv.ParseFloat(tgt, 64)
+	return errA == nil && errB == nil && a == b
+}
+
+func normalizeDefault(v string) string {
+	v = strings.TrimSpace(v)
+	for len(v) >= 2 && v[0] == '(' && v[len(v)-1] == ')' {
+		v = strings.TrimSpace(v[1 : len(v)-1])
+	}
+	if strings.EqualFold(v, "NULL") {
+		return ""
+	}
+	if !strings.HasPrefix(v, "'") && !strings.HasPrefix(v, "\"") {
+		v = strings.ToUpper(v)
+	}
+	return v
+}
+
+// CreateTables performs atomic table creation using the new DDL system.
+// Destructive and data-moving commands of the plan are skipped unless AllowDestructive or
+// AllowDataMoving is set, so existing tables are only extended.
 func (dm *DDLManager) CreateTables(ctx context.Context, models ...interface{}) error {
 	// 如果传入的是单个切片参数，展开它
 	var modelList []interface{}
@@ -867,6 +2250,23 @@ func (dm *DDLManager) CreateTables(ctx context.Context, models ...interface{}) e
 		return err
 	}
 
+	// Never drop or rewrite existing data here unless explicitly allowed
+	commands := plan.Commands[:0:0]
+	for _, cmd := range plan.Commands {
+		switch ClassifyCommand(cmd) {
+		case ChangeDestructive:
+			if !dm.allowDestructive {
+				continue
+			}
+		case ChangeDataMoving:
+			if !dm.allowDataMoving {
+				continue
+			}
+		}
+		commands = append(commands, cmd)
+	}
+	plan.Commands = commands
+
 	if len(plan.Commands) == 0 {
 		// No schema changes needed
 		return nil
@@ -0,0 +1,284 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DocOptions controls the documentation emitted by GenerateDocs and GenerateERDiagram
+type DocOptions struct {
+	Title  string        // top heading, "Database Schema" by default
+	Tables []string      // tables to document, all but SQLite and zorm internal tables when empty
+	Models []interface{} // models whose comment: tags describe columns without a comment in the schema
+}
+
+// GenerateDocs emits Markdown documentation for the tables of schema, e.g. from
+// DDLManager.GetCurrentSchema: a Mermaid ER diagram followed by the columns, indexes
+// and foreign keys of each table. Column comments come from the schema or from the
+// comment: tags of opts.Models, as SQLite doesn't store comments.
+func GenerateDocs(schema *SchemaInfo, opts DocOptions) ([]byte, error) {
+	tables, err := docTables(schema, opts)
+	if err != nil {
+		return nil, err
+	}
+	title := opts.Title
+	if title == "" {
+		title = "Database Schema"
+	}
+
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "# %s\n\n", title)
+	w.WriteString("```mermaid\n")
+	writeERDiagram(&w, tables)
+	w.WriteString("```\n\n")
+
+	for _, table := range tables {
+		fmt.Fprintf(&w, "- [%s](#%s)\n", table.Name, anchor(table.Name))
+	}
+	for _, table := range tables {
+		w.WriteString("\n")
+		writeTableDoc(&w, table)
+	}
+	return w.Bytes(), nil
+}
+
+// GenerateERDiagram emits a Mermaid erDiagram of the tables of schema and their foreign keys
+func GenerateERDiagram(schema *SchemaInfo, opts DocOptions) ([]byte, error) {
+	tables, err := docTables(schema, opts)
+	if err != nil {
+		return nil, err
+	}
+	var w bytes.Buffer
+	writeERDiagram(&w, tables)
+	return w.Bytes(), nil
+}
+
+// docTables returns the tables to document sorted by name, with the comments of the models filled in.
+// The schema itself is left untouched.
+func docTables(schema *SchemaInfo, opts DocOptions) ([]*TableInfo, error) {
+	names := opts.Tables
+	if len(names) == 0 {
+		for name := range schema.Tables {
+			if !strings.HasPrefix(name, "sqlite_") && !strings.HasPrefix(name, "_zorm_") && name != DefaultMigrationTable {
+				names = append(names, name)
+			}
+		}
+	}
+	names = slices.Clone(names)
+	sort.Strings(names)
+
+	comments := make(map[string]map[string]string, len(opts.Models))
+	for _, model := range opts.Models {
+		columns, err := getModelColumns(model)
+		if err != nil {
+			return nil, err
+		}
+		tableName := getTableName(model)
+		for name, col := range columns {
+			if col.Comment != "" {
+				if comments[tableName] == nil {
+					comments[tableName] = make(map[string]string)
+				}
+				comments[tableName][name] = col.Comment
+			}
+		}
+	}
+
+	tables := make([]*TableInfo, 0, len(names))
+	for _, name := range names {
+		table, ok := schema.Tables[name]
+		if !ok {
+			return nil, fmt.Errorf("table %s not found", name)
+		}
+		if len(comments[name]) > 0 {
+			copied := *table
+			copied.Columns = make(map[string]*ColumnDef, len(table.Columns))
+			for colName, col := range table.Columns {
+				c := *col
+				if c.Comment == "" {
+					c.Comment = comments[name][colName]
+				}
+				copied.Columns[colName] = &c
+			}
+			table = &copied
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func writeERDiagram(w *bytes.Buffer, tables []*TableInfo) {
+	w.WriteString("erDiagram\n")
+	for _, table := range tables {
+		keys := columnKeys(table)
+		fmt.Fprintf(w, "    %s {\n", table.Name)
+		for _, col := range orderedColumns(table) {
+			fmt.Fprintf(w, "        %s %s", mermaidType(col.Type), col.Name)
+			if k := keys[col.Name]; len(k) > 0 {
+				fmt.Fprintf(w, " %s", strings.Join(k, ", "))
+			}
+			if col.Comment != "" {
+				// Mermaid comments can't escape quotes
+				fmt.Fprintf(w, " \"%s\"", strings.ReplaceAll(cell(col.Comment), `"`, "'"))
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString("    }\n")
+	}
+
+	// One relationship per foreign key, the parent on the left
+	for _, table := range tables {
+		for _, fk := range table.ForeignKeys {
+			parent := "||"
+			for _, name := range fk.Columns {
+				if col := table.Columns[name]; col != nil && col.Nullable {
+					parent = "|o"
+				}
+			}
+			child := "o{"
+			if uniqueColumns(table, fk.Columns) {
+				child = "o|"
+			}
+			fmt.Fprintf(w, "    %s %s--%s %s : \"%s\"\n", fk.RefTable, parent, child, table.Name, strings.Join(fk.Columns, ", "))
+		}
+	}
+}
+
+func writeTableDoc(w *bytes.Buffer, table *TableInfo) {
+	fmt.Fprintf(w, "## %s\n\n", table.Name)
+	keys := columnKeys(table)
+
+	w.WriteString("| Column | Type | Nullable | Default | Key | Comment |\n")
+	w.WriteString("|--------|------|----------|---------|-----|---------|\n")
+	for _, col := range orderedColumns(table) {
+		nullable := "NO"
+		if col.Nullable && !col.AutoIncrement {
+			nullable = "YES"
+		}
+		key := strings.Join(keys[col.Name], ", ")
+		if col.AutoIncrement {
+			key = strings.TrimPrefix(key+", auto increment", ", ")
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n", cell(col.Name), cell(col.Type), nullable,
+			cell(col.DefaultValue), key, cell(col.Comment))
+	}
+
+	var indexNames []string
+	for name, idx := range table.Indexes {
+		if !idx.Primary {
+			indexNames = append(indexNames, name)
+		}
+	}
+	sort.Strings(indexNames)
+	if len(indexNames) > 0 {
+		w.WriteString("\n| Index | Columns | Unique |\n")
+		w.WriteString("|-------|---------|--------|\n")
+		for _, name := range indexNames {
+			idx := table.Indexes[name]
+			unique := "NO"
+			if idx.Unique {
+				unique = "YES"
+			}
+			fmt.Fprintf(w, "| %s | %s | %s |\n", cell(name), cell(strings.Join(idx.Columns, ", ")), unique)
+		}
+	}
+
+	if len(table.ForeignKeys) > 0 {
+		w.WriteString("\nForeign keys:\n\n")
+		for _, fk := range table.ForeignKeys {
+			fmt.Fprintf(w, "- `%s` references [%s](#%s) (`%s`)", strings.Join(fk.Columns, "`, `"),
+				fk.RefTable, anchor(fk.RefTable), strings.Join(fk.RefColumns, "`, `"))
+			if fk.OnDelete != "" {
+				fmt.Fprintf(w, " on delete %s", strings.ToLower(fk.OnDelete))
+			}
+			if fk.OnUpdate != "" {
+				fmt.Fprintf(w, " on update %s", strings.ToLower(fk.OnUpdate))
+			}
+			w.WriteString("\n")
+		}
+	}
+}
+
+// columnKeys returns the PK, FK and UK markers of each column
+func columnKeys(table *TableInfo) map[string][]string {
+	keys := make(map[string][]string)
+	for _, name := range table.PrimaryKey {
+		keys[name] = append(keys[name], "PK")
+	}
+	for _, col := range table.Columns {
+		if col.AutoIncrement && len(table.PrimaryKey) == 0 {
+			keys[col.Name] = append(keys[col.Name], "PK")
+		}
+	}
+	for _, fk := range table.ForeignKeys {
+		for _, name := range fk.Columns {
+			if !slices.Contains(keys[name], "FK") {
+				keys[name] = append(keys[name], "FK")
+			}
+		}
+	}
+	for _, idx := range table.Indexes {
+		if idx.Unique && len(idx.Columns) == 1 && !slices.Contains(keys[idx.Columns[0]], "UK") {
+			keys[idx.Columns[0]] = append(keys[idx.Columns[0]], "UK")
+		}
+	}
+	return keys
+}
+
+// uniqueColumns reports whether the columns are the primary key or a unique index of the table
+func uniqueColumns(table *TableInfo, columns []string) bool {
+	if slices.Equal(table.PrimaryKey, columns) {
+		return true
+	}
+	for _, idx := range table.Indexes {
+		if idx.Unique && slices.Equal(idx.Columns, columns) {
+			return true
+		}
+	}
+	return false
+}
+
+// mermaidType turns a column type into a Mermaid attribute type, e.g. VARCHAR(255) -> VARCHAR
+func mermaidType(sqlType string) string {
+	if i := strings.IndexByte(sqlType, '('); i >= 0 {
+		sqlType = sqlType[:i]
+	}
+	t := strings.Join(strings.Fields(sqlType), "_")
+	if t == "" {
+		return "ANY"
+	}
+	return t
+}
+
+// anchor returns the GitHub anchor of a Markdown heading
+func anchor(heading string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ':
+			return '-'
+		case r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, heading)
+}
+
+// cell escapes a value for a Markdown table cell
+func cell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
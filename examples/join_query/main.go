@@ -22,6 +22,12 @@ type Order struct {
 	Status string `zorm:"status"`
 }
 
+// UserWithOrder 用嵌套结构体接收联表结果，LEFT JOIN 未匹配时 Order 为 nil
+type UserWithOrder struct {
+	User  `zorm:"users.*"`
+	Order *Order `zorm:"orders.*"`
+}
+
 type UserOrder struct {
 	UserID   int64  `zorm:"users.id"`
 	UserName string `zorm:"users.name"`
@@ -168,6 +174,26 @@ func testJoins(db *sql.DB) {
 			result.UserName, result.UserID, result.OrderID, result.Amount, result.Status)
 	}
 
+	// 3.1 嵌套结构体接收联表结果
+	fmt.Println("\n3.1 嵌套结构体接收联表结果:")
+	var nested []UserWithOrder
+	n, err = tbl.Select(&nested,
+		zorm.LeftJoin("orders", "users.id = orders.user_id"),
+		zorm.OrderBy("users.id", "orders.id"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("找到 %d 条记录:\n", n)
+	for _, result := range nested {
+		if result.Order == nil {
+			fmt.Printf("  用户: %s (ID: %d), 无订单\n", result.Name, result.ID)
+			continue
+		}
+		fmt.Printf("  用户: %s (ID: %d), 订单: %d, 金额: %d, 状态: %s\n",
+			result.Name, result.ID, result.Order.ID, result.Order.Amount, result.Order.Status)
+	}
+
 	// 4. 测试非指针类型支持
 	fmt.Println("\n4. 测试非指针类型支持:")
 	user := User{Name: "David", Age: 28}
@@ -58,4 +58,24 @@ func main() {
 		log.Fatal(err)
 	}
 	fmt.Printf("多条记录: count=%d, results=%+v\n", count, results)
+
+	// 按 id 组织的 map
+	var names map[int64]string
+	count, err = table.Select(&names, zorm.Fields("id", "name"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("按键映射: count=%d, names=%+v\n", count, names)
+
+	type User struct {
+		ID   int64  `zorm:"id"`
+		Name string `zorm:"name"`
+		Age  int    `zorm:"age"`
+	}
+	var users map[int64]User
+	count, err = table.Select(&users, zorm.KeyBy("id"), zorm.Where(zorm.Gt("age", 25)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("按键映射结构体: count=%d, users=%+v\n", count, users)
 }
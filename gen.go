@@ -0,0 +1,327 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/modern-go/reflect2"
+)
+
+// GenerateOptions controls the code emitted by GenerateStructs
+type GenerateOptions struct {
+	Package string   // package name of the generated file, "models" by default
+	Tables  []string // tables to generate, all but SQLite and zorm internal tables when empty
+}
+
+// GenerateStructs emits gofmt-ed Go structs with zorm tags for the tables of schema, e.g. from
+// DDLManager.GetCurrentSchema. Nullable columns become pointers; auto increment, primary and
+// foreign keys, declared types, defaults and indexes become tags. A TableName method is emitted when the
+// naming strategy wouldn't derive the table name from the struct name.
+func GenerateStructs(schema *SchemaInfo, opts GenerateOptions) ([]byte, error) {
+	if opts.Package == "" {
+		opts.Package = "models"
+	}
+
+	tables := opts.Tables
+	if len(tables) == 0 {
+		for name := range schema.Tables {
+			if !strings.HasPrefix(name, "sqlite_") && !strings.HasPrefix(name, "_zorm_") && name != DefaultMigrationTable {
+				tables = append(tables, name)
+			}
+		}
+		sort.Strings(tables)
+	}
+
+	var body bytes.Buffer
+	usesTime := false
+	for _, name := range tables {
+		table, ok := schema.Tables[name]
+		if !ok {
+			return nil, fmt.Errorf("table %s not found", name)
+		}
+		if generateStruct(&body, table) {
+			usesTime = true
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString("// Generated by zorm from the database schema, edit as needed.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", opts.Package)
+	if usesTime {
+		out.WriteString("import \"time\"\n\n")
+	}
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// generateStruct writes the struct of a table and reports whether it uses time.Time
+func generateStruct(w *bytes.Buffer, table *TableInfo) bool {
+	structName := goIdentifier(singularize(table.Name))
+	columns := orderedColumns(table)
+
+	// Tags of each column, in declaration order
+	tags := make(map[string][]string, len(columns))
+	for _, col := range columns {
+		tags[col.Name] = []string{col.Name}
+	}
+	var notes []string
+
+	primaryKey := make(map[string]bool, len(table.PrimaryKey))
+	for _, key := range table.PrimaryKey {
+		primaryKey[key] = true
+	}
+
+	for _, fk := range table.ForeignKeys {
+		if len(fk.Columns) != 1 || fk.RefColumns[0] == "" {
+			notes = append(notes, "foreign key "+fk.SQL()+" can't be expressed as a tag")
+			continue
+		}
+		tag := "fk:" + fk.RefTable + "." + fk.RefColumns[0]
+		if fk.OnDelete != "" {
+			tag += ",on_delete:" + tagAction(fk.OnDelete)
+		}
+		if fk.OnUpdate != "" {
+			tag += ",on_update:" + tagAction(fk.OnUpdate)
+		}
+		tags[fk.Columns[0]] = append(tags[fk.Columns[0]], tag)
+	}
+
+	position := make(map[string]int, len(columns))
+	for i, col := range columns {
+		position[col.Name] = i
+	}
+	var indexNames []string
+	for name, idx := range table.Indexes {
+		if !idx.Primary {
+			indexNames = append(indexNames, name)
+		}
+	}
+	sort.Strings(indexNames)
+	for _, name := range indexNames {
+		idx := table.Indexes[name]
+		kind, prefix := "index", "idx_"
+		if idx.Unique {
+			kind, prefix = "unique", "uniq_"
+		}
+		if slices.Contains(idx.Columns, "") {
+			notes = append(notes, "expression index "+name+" can't be expressed as a tag")
+			continue
+		}
+		if len(idx.Columns) == 1 && name == prefix+table.Name+"_"+idx.Columns[0] {
+			tags[idx.Columns[0]] = append(tags[idx.Columns[0]], kind)
+			continue
+		}
+		for _, col := range idx.Columns {
+			tags[col] = append(tags[col], kind+":"+name)
+		}
+		if !slices.IsSortedFunc(idx.Columns, func(a, b string) int { return position[a] - position[b] }) {
+			notes = append(notes, fmt.Sprintf("index %s is on (%s), tags list the columns in field order",
+				name, strings.Join(idx.Columns, ", ")))
+		}
+	}
+
+	usesTime := false
+	fmt.Fprintf(w, "// %s maps table %s\n", structName, table.Name)
+	for _, note := range notes {
+		fmt.Fprintf(w, "// NOTE: %s\n", note)
+	}
+	fmt.Fprintf(w, "type %s struct {\n", structName)
+
+	usedNames := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		fieldName := goIdentifier(col.Name)
+		for i := 2; usedNames[fieldName]; i++ {
+			fieldName = goIdentifier(col.Name) + strconv.Itoa(i)
+		}
+		usedNames[fieldName] = true
+
+		goType := goTypeOf(col.Type)
+		colTags := []string{col.Name}
+		comment := ""
+		switch {
+		case col.AutoIncrement:
+			goType = "int64"
+			colTags = append(colTags, "auto_incr")
+		case primaryKey[col.Name]:
+			colTags = append(colTags, "pk")
+		}
+		// Keep the declared type when the Go type maps to another one
+		if !col.AutoIncrement && col.Type != "" && !strings.EqualFold(col.Type, goSQLTypes[goType]) {
+			if strings.ContainsAny(col.Type, ",`") {
+				comment = " // type " + col.Type
+			} else {
+				colTags = append(colTags, "type:"+col.Type)
+			}
+		}
+		if col.Nullable && !col.AutoIncrement && goType != "[]byte" {
+			goType = "*" + goType
+		} else if !col.Nullable && goType == "[]byte" {
+			colTags = append(colTags, "not_null")
+		}
+		if strings.HasSuffix(goType, "time.Time") {
+			usesTime = true
+		}
+
+		if col.DefaultValue != "" && !col.AutoIncrement {
+			// Tags are split on commas
+			if strings.ContainsAny(col.DefaultValue, ",`") {
+				comment += " // default " + col.DefaultValue
+			} else {
+				colTags = append(colTags, "default:"+col.DefaultValue)
+			}
+		}
+		colTags = append(colTags, tags[col.Name][1:]...)
+		if col.Comment != "" {
+			// The comment: option comes last and may contain commas, but not the backticks of the tag
+			if strings.Contains(col.Comment, "`") {
+				comment += " // " + strings.ReplaceAll(col.Comment, "\n", " ")
+			} else {
+				colTags = append(colTags, "comment:"+strings.ReplaceAll(col.Comment, "\n", " "))
+			}
+		}
+
+		tag := "`zorm:" + strconv.Quote(strings.Join(colTags, ",")) + "`"
+		fmt.Fprintf(w, "\t%s %s %s%s\n", fieldName, goType, tag, comment)
+	}
+	w.WriteString("}\n\n")
+
+	if naming().TableName(structName) != table.Name {
+		fmt.Fprintf(w, "// TableName returns the table name of %s\n", structName)
+		fmt.Fprintf(w, "func (*%s) TableName() string { return %s }\n\n", structName, strconv.Quote(table.Name))
+	}
+	return usesTime
+}
+
+// orderedColumns returns the columns in declaration order when known,
+// otherwise primary key columns first and the others by name
+func orderedColumns(table *TableInfo) []*ColumnDef {
+	names := table.ColumnOrder
+	if len(names) != len(table.Columns) {
+		names = make([]string, 0, len(table.Columns))
+		for name := range table.Columns {
+			names = append(names, name)
+		}
+		rank := func(name string) int {
+			for i, key := range table.PrimaryKey {
+				if key == name {
+					return i
+				}
+			}
+			if table.Columns[name].AutoIncrement {
+				return -1
+			}
+			return len(table.PrimaryKey)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if ri, rj := rank(names[i]), rank(names[j]); ri != rj {
+				return ri < rj
+			}
+			return names[i] < names[j]
+		})
+	}
+
+	columns := make([]*ColumnDef, 0, len(names))
+	for _, name := range names {
+		columns = append(columns, table.Columns[name])
+	}
+	return columns
+}
+
+// goTypeOf maps a column type to a Go type following SQLite's type affinity rules
+func goTypeOf(sqlType string) string {
+	t := strings.ToUpper(sqlType)
+	switch {
+	case strings.Contains(t, "BOOL"):
+		return "bool"
+	case strings.Contains(t, "INT"):
+		return "int64"
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return "string"
+	case strings.Contains(t, "BLOB"), t == "":
+		return "[]byte"
+	case strings.Contains(t, "DATE"), strings.Contains(t, "TIME"):
+		return "time.Time"
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"),
+		strings.Contains(t, "DEC"), strings.Contains(t, "NUM"):
+		return "float64"
+	}
+	return "string"
+}
+
+// goSQLTypes are the SQL types CreateTable uses for the Go types of goTypeOf
+var goSQLTypes = map[string]string{
+	"bool":      getSQLType(reflect2.TypeOf(false)),
+	"int64":     getSQLType(reflect2.TypeOf(int64(0))),
+	"float64":   getSQLType(reflect2.TypeOf(float64(0))),
+	"string":    getSQLType(reflect2.TypeOf("")),
+	"[]byte":    getSQLType(reflect2.TypeOf([]byte(nil))),
+	"time.Time": getSQLType(reflect2.TypeOf(time.Time{})),
+}
+
+func tagAction(action string) string {
+	return strings.ToLower(strings.ReplaceAll(action, " ", "_"))
+}
+
+// commonInitialisms are written in upper case in Go identifiers
+var commonInitialisms = map[string]bool{
+	"API": true, "CPU": true, "CSS": true, "DB": true, "DNS": true, "HTML": true, "HTTP": true,
+	"HTTPS": true, "ID": true, "IP": true, "JSON": true, "MAC": true, "OS": true, "SQL": true,
+	"SSH": true, "TCP": true, "TLS": true, "TTL": true, "UDP": true, "UI": true, "UID": true,
+	"URI": true, "URL": true, "UUID": true, "XML": true,
+}
+
+// goIdentifier converts a snake_case name to an exported Go identifier, e.g. user_id -> UserID
+func goIdentifier(name string) string {
+	var sb strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if upper := strings.ToUpper(part); commonInitialisms[upper] {
+			sb.WriteString(upper)
+			continue
+		}
+		runes := []rune(part)
+		sb.WriteRune(unicode.ToUpper(runes[0]))
+		sb.WriteString(string(runes[1:]))
+	}
+	id := sb.String()
+	if id == "" || unicode.IsDigit([]rune(id)[0]) {
+		id = "F" + id
+	}
+	return id
+}
+
+// singularize returns the singular of the last word of a plural snake_case name
+func singularize(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "sses"), strings.HasSuffix(name, "xes"), strings.HasSuffix(name, "zes"),
+		strings.HasSuffix(name, "ches"), strings.HasSuffix(name, "shes"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss"):
+		return name[:len(name)-1]
+	}
+	return name
+}
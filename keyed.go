@@ -0,0 +1,276 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/modern-go/reflect2"
+)
+
+// KeyBy 指定按键组织的 map 结果以哪一列作为键
+// 结果类型支持：
+//   - map[K]T、map[K]*T             每行一个结构体，键相同时后面的行覆盖前面的行
+//   - map[K][]T、map[K][]*T         按键分组，组内保持查询顺序
+//   - map[K]V、map[K][]V            V 为基础类型时取两列，KeyBy 指定键列，Fields 指定值列；
+//     不使用 KeyBy 时 Fields 的第一列为键，第二列为值
+//
+// 键列为 NULL 的行被忽略；map[string]interface{} 不带 KeyBy 时仍按单行的列名到值的映射处理
+// Example:
+//
+//	var users map[int64]User
+//	Select(&users, KeyBy("id"), Where("age > ?", 18))
+//	var names map[int64]string
+//	Select(&names, Fields("id", "name"))
+//	var orders map[int64][]Order
+//	Select(&orders, KeyBy("user_id"), OrderBy("id"))
+func KeyBy(field string) *keyByItem {
+	return &keyByItem{Field: field}
+}
+
+type keyByItem struct {
+	Field string
+}
+
+func (k *keyByItem) Type() int {
+	return _keyBy
+}
+
+// BuildSQL 键列不参与SQL构建，只把列名写入复用缓存的形状key
+func (k *keyByItem) BuildSQL(sb *strings.Builder) {
+	sb.WriteString(k.Field)
+}
+
+func (k *keyByItem) BuildArgs(stmtArgs *[]interface{}) {}
+
+// splitKeyBy 从查询参数中取出 KeyBy，不修改调用方传入的切片
+func splitKeyBy(args []ZormItem) ([]ZormItem, string) {
+	for i, arg := range args {
+		if k, ok := arg.(*keyByItem); ok {
+			rest := append(make([]ZormItem, 0, len(args)-1), args[:i]...)
+			rest, _ = splitKeyBy(append(rest, args[i+1:]...))
+			return rest, k.Field
+		}
+	}
+	return args, ""
+}
+
+// isKeyedMap 判断 map 结果是否按键组织，map[string]interface{} 为单行结果
+func isKeyedMap(rt reflect.Type, keyBy string) bool {
+	return keyBy != "" || rt.Key().Kind() != reflect.String || rt.Elem().Kind() != reflect.Interface
+}
+
+// selectKeyed 查询并按键写入 map 结果，返回查询到的行数
+func (t *ZormTable) selectKeyed(res interface{}, keyBy string, args []ZormItem, preloads []*preloadItem) (int, error) {
+	mv := reflect.ValueOf(res)
+	for mv.Kind() == reflect.Ptr {
+		if mv.IsNil() {
+			return 0, errors.New("argument 2 should be a non-nil pointer")
+		}
+		if mv.Elem().Kind() == reflect.Ptr && mv.Elem().IsNil() {
+			mv.Elem().Set(reflect.New(mv.Type().Elem().Elem()))
+		}
+		mv = mv.Elem()
+	}
+	if mv.IsNil() {
+		if !mv.CanSet() {
+			return 0, errors.New("argument 2 should be a non-nil map or a pointer to map")
+		}
+		mv.Set(reflect.MakeMap(mv.Type()))
+	}
+
+	valType := mv.Type().Elem()
+	grouped := valType.Kind() == reflect.Slice && valType.Elem().Kind() != reflect.Uint8
+	rowType := valType
+	if grouped {
+		rowType = valType.Elem()
+	}
+
+	structType := rowType
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	if structType.Kind() == reflect.Struct && structType != reflect.TypeOf(time.Time{}) {
+		return t.selectKeyedStructs(mv, keyBy, structType, rowType, grouped, args, preloads)
+	}
+	if len(preloads) > 0 {
+		return 0, errors.New("preload needs struct values")
+	}
+	return t.selectKeyedValues(mv, keyBy, rowType, grouped, args)
+}
+
+// selectKeyedStructs 先按切片查询结构体，再按键列的字段值写入 map
+func (t *ZormTable) selectKeyedStructs(mv reflect.Value, keyBy string, structType, rowType reflect.Type, grouped bool,
+	args []ZormItem, preloads []*preloadItem) (int, error) {
+	if keyBy == "" {
+		return 0, fmt.Errorf("map of %s needs KeyBy(\"column\")", structType)
+	}
+	keyPath, ok := structFieldPaths(structType)[keyBy]
+	if !ok {
+		return 0, fmt.Errorf("%s has no field for column %s", structType, keyBy)
+	}
+
+	// 确保 Fields 包含键列
+	queryArgs := make([]ZormItem, 0, len(args)+len(preloads))
+	for _, arg := range args {
+		if fi, ok := arg.(*fieldsItem); ok && len(fi.Fields) > 0 && !containsField(fi.Fields, keyBy) {
+			arg = Fields(append(append([]string{}, fi.Fields...), keyBy)...)
+		}
+		queryArgs = append(queryArgs, arg)
+	}
+	for _, p := range preloads {
+		queryArgs = append(queryArgs, p)
+	}
+
+	// 内部调用点相同，关闭复用以免不同类型共用绑定
+	child := t.clone()
+	child.Cfg.Reuse = false
+	// 按外层的结果参数匹配mock
+	child.mock = t.mock
+	dest := reflect.New(reflect.SliceOf(rowType))
+	n, err := child.Select(dest.Interface(), queryArgs...)
+	if err != nil {
+		return n, err
+	}
+
+	keyType := mv.Type().Key()
+	rows := dest.Elem()
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		key, ok, err := mapKey(derefStruct(row).FieldByIndex(keyPath), keyType)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			setMapEntry(mv, key, row, grouped)
+		}
+	}
+	return n, nil
+}
+
+// selectKeyedValues 查询键列和值列两列，直接用 scanner 扫描到键和值中
+func (t *ZormTable) selectKeyedValues(mv reflect.Value, keyBy string, rowType reflect.Type, grouped bool, args []ZormItem) (int, error) {
+	var fields []string
+	rest := make([]ZormItem, 0, len(args))
+	for _, arg := range args {
+		if fi, ok := arg.(*fieldsItem); ok {
+			fields = fi.Fields
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if keyBy != "" {
+		fields = append([]string{keyBy}, fields...)
+	}
+	if len(fields) != 2 {
+		return 0, errors.New("map of values needs Fields(\"key\", \"value\") or KeyBy(\"key\") with Fields(\"value\")")
+	}
+
+	sb := getSQLBuilder()
+	sb.WriteString("select ")
+	fieldEscape(sb, fields[0])
+	sb.WriteString(",")
+	fieldEscape(sb, fields[1])
+	selectFields := sb.String()[len("select "):]
+	sb.WriteString(" from ")
+	fieldEscape(sb, t.Name)
+
+	stmtArgs := getArgsSlice()
+	defer putArgsSlice(stmtArgs)
+	for _, arg := range rest {
+		if c, ok := arg.(*compoundItem); ok {
+			c.buildSQL(sb, selectFields)
+			c.BuildArgs(&stmtArgs)
+			continue
+		}
+		arg = wrapCond(arg)
+		arg.BuildSQL(sb)
+		arg.BuildArgs(&stmtArgs)
+	}
+	query := sb.String()
+	putSQLBuilder(sb)
+
+	if err := t.checkStmtMock(query, stmtArgs); err != nil {
+		return 0, err
+	}
+
+	if t.Cfg.Debug {
+		log.Println(query, stmtArgs)
+	}
+
+	rows, err := t.DB.QueryContext(t.ctx, query, stmtArgs...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	keyType := mv.Type().Key()
+	count := 0
+	for rows.Next() {
+		// 键列为 NULL 时 scanner 不会写入，用指针类型判断
+		key := reflect.New(reflect.PtrTo(keyType))
+		val := reflect.New(rowType)
+		if err := rows.Scan(
+			&scanner{Type: reflect2.Type2(key.Type().Elem()), Val: unsafe.Pointer(key.Pointer())},
+			&scanner{Type: reflect2.Type2(rowType), Val: unsafe.Pointer(val.Pointer())},
+		); err != nil {
+			return count, err
+		}
+		count++
+		if !key.Elem().IsNil() {
+			setMapEntry(mv, key.Elem().Elem(), val.Elem(), grouped)
+		}
+	}
+	return count, rows.Err()
+}
+
+// mapKey 将键列的字段值转换为 map 的键类型，NULL 返回 false
+func mapKey(v reflect.Value, keyType reflect.Type) (reflect.Value, bool, error) {
+	for v.Kind() == reflect.Ptr && keyType.Kind() != reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false, nil
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Type().AssignableTo(keyType):
+		return v, true, nil
+	case v.Type().ConvertibleTo(keyType) && (v.Kind() == reflect.String) == (keyType.Kind() == reflect.String):
+		return v.Convert(keyType), true, nil
+	}
+	return reflect.Value{}, false, fmt.Errorf("cannot use %s as map key type %s", v.Type(), keyType)
+}
+
+// setMapEntry 写入 map，分组结果追加到键对应的切片
+func setMapEntry(mv, key, val reflect.Value, grouped bool) {
+	if grouped {
+		group := mv.MapIndex(key)
+		if !group.IsValid() {
+			group = reflect.MakeSlice(mv.Type().Elem(), 0, 1)
+		}
+		val = reflect.Append(group, val)
+	}
+	mv.SetMapIndex(key, val)
+}
+
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field || f == "*" {
+			return true
+		}
+	}
+	return false
+}
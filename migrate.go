@@ -0,0 +1,386 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMigrationTable is the table used to track applied migrations
+const DefaultMigrationTable = "zorm_migrations"
+
+var (
+	// ErrMigrationChanged is returned when an applied migration was edited afterwards
+	ErrMigrationChanged = errors.New("applied migration has been changed")
+	// ErrNoDownMigration is returned when rolling back a migration without a down step
+	ErrNoDownMigration = errors.New("migration has no down step")
+)
+
+// MigrationFunc runs a migration step; db is the migration transaction unless NoTx is set
+type MigrationFunc func(ctx context.Context, db ZormDBIFace) error
+
+// Migration represents a single versioned migration.
+// Either the Go funcs or the SQL scripts are used, Go funcs take precedence.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      MigrationFunc
+	Down    MigrationFunc
+	UpSQL   string
+	DownSQL string
+	// Checksum identifies the migration content. It defaults to a hash of the SQL scripts.
+	// Go funcs can't be hashed, so Go func migrations default to a hash of the name only:
+	// set Checksum (e.g. "v2") and bump it whenever the funcs change, or edits go undetected.
+	Checksum string
+	// NoTx runs the migration outside a transaction, e.g. for statements SQLite forbids in one
+	NoTx bool
+}
+
+func (m *Migration) checksum() string {
+	if m.Checksum != "" {
+		return m.Checksum
+	}
+	h := sha256.New()
+	if m.Up == nil && m.Down == nil {
+		h.Write([]byte(m.UpSQL))
+		h.Write([]byte{0})
+		h.Write([]byte(m.DownSQL))
+	} else {
+		h.Write([]byte(m.Name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+func (m *Migration) run(ctx context.Context, db ZormDBIFace, up bool) error {
+	fn, script := m.Up, m.UpSQL
+	if !up {
+		fn, script = m.Down, m.DownSQL
+	}
+	if fn != nil {
+		return fn(ctx, db)
+	}
+	if strings.TrimSpace(script) == "" {
+		if up {
+			return nil
+		}
+		return ErrNoDownMigration
+	}
+	_, err := db.ExecContext(ctx, script)
+	return err
+}
+
+func (m *Migration) hasDown() bool {
+	return m.Down != nil || strings.TrimSpace(m.DownSQL) != ""
+}
+
+// MigrationStatus describes the state of a migration
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Changed   bool // applied checksum differs from the registered migration
+	Missing   bool // applied but no longer registered
+}
+
+// migrationRecord is a row of the migration table
+type migrationRecord struct {
+	Version   int64     `zorm:"version"`
+	Name      string    `zorm:"name"`
+	Checksum  string    `zorm:"checksum"`
+	AppliedAt time.Time `zorm:"applied_at"`
+}
+
+// Migrator applies and rolls back versioned migrations
+type Migrator struct {
+	db         ZormDBIFace
+	table      string
+	migrations []*Migration
+}
+
+// NewMigrator creates a migrator tracking versions in DefaultMigrationTable
+func NewMigrator(db ZormDBIFace) *Migrator {
+	return &Migrator{db: db, table: DefaultMigrationTable}
+}
+
+// WithTable sets the table used to track applied migrations
+func (mg *Migrator) WithTable(table string) *Migrator {
+	mg.table = table
+	return mg
+}
+
+// Register adds migrations; versions must be positive and unique
+func (mg *Migrator) Register(migrations ...*Migration) error {
+	for _, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration %s: version must be positive", m)
+		}
+		for _, exist := range mg.migrations {
+			if exist.Version == m.Version {
+				return fmt.Errorf("migration %s: duplicate version %d", m, m.Version)
+			}
+		}
+		mg.migrations = append(mg.migrations, m)
+	}
+	sort.Slice(mg.migrations, func(i, j int) bool {
+		return mg.migrations[i].Version < mg.migrations[j].Version
+	})
+	return nil
+}
+
+// RegisterFunc adds a Go func migration.
+//
+// WARNING: its checksum is a hash of the name only, so editing up or down is NOT detected
+// by Verify, Up or Down. Register a Migration with an explicit Checksum to track changes.
+func (mg *Migrator) RegisterFunc(version int64, name string, up, down MigrationFunc) error {
+	return mg.Register(&Migration{Version: version, Name: name, Up: up, Down: down})
+}
+
+// RegisterFS adds SQL migrations from dir in fsys, e.g. an embed.FS.
+// Files are named <version>_<name>.up.sql and <version>_<name>.down.sql, the down file is optional.
+func (mg *Migrator) RegisterFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(fileName, ".sql")
+		up := true
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			base = strings.TrimSuffix(base, ".up")
+		case strings.HasSuffix(base, ".down"):
+			base, up = strings.TrimSuffix(base, ".down"), false
+		default:
+			return fmt.Errorf("migration file %s: expect .up.sql or .down.sql suffix", fileName)
+		}
+
+		versionStr, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("migration file %s: invalid version %q", fileName, versionStr)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+		if err != nil {
+			return err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return fmt.Errorf("migration file %s: version %d already used by %s", fileName, version, m)
+		}
+		if up {
+			m.UpSQL = string(content)
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.UpSQL == "" {
+			return fmt.Errorf("migration %s: missing up file", m)
+		}
+		migrations = append(migrations, m)
+	}
+	return mg.Register(migrations...)
+}
+
+// Migrations returns the registered migrations ordered by version
+func (mg *Migrator) Migrations() []*Migration {
+	return append([]*Migration(nil), mg.migrations...)
+}
+
+func (mg *Migrator) ensureTable(ctx context.Context) error {
+	_, err := mg.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `"+mg.table+"` ("+
+		"`version` INTEGER PRIMARY KEY, "+
+		"`name` TEXT NOT NULL, "+
+		"`checksum` TEXT NOT NULL, "+
+		"`applied_at` DATETIME NOT NULL)")
+	return err
+}
+
+func (mg *Migrator) applied(ctx context.Context) (map[int64]*migrationRecord, error) {
+	if err := mg.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	var records []migrationRecord
+	if _, err := TableContext(ctx, mg.db, mg.table).Select(&records); err != nil {
+		return nil, err
+	}
+	res := make(map[int64]*migrationRecord, len(records))
+	for i := range records {
+		res[records[i].Version] = &records[i]
+	}
+	return res, nil
+}
+
+// verify reports applied migrations whose content changed
+func (mg *Migrator) verify(applied map[int64]*migrationRecord) error {
+	var errs []error
+	for _, m := range mg.migrations {
+		if r, ok := applied[m.Version]; ok && r.Checksum != m.checksum() {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrMigrationChanged, m))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Verify checks that no applied migration has been edited
+func (mg *Migrator) Verify(ctx context.Context) error {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return err
+	}
+	return mg.verify(applied)
+}
+
+// Status returns the state of registered and applied migrations ordered by version
+func (mg *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []MigrationStatus
+	for _, m := range mg.migrations {
+		st := MigrationStatus{Version: m.Version, Name: m.Name}
+		if r, ok := applied[m.Version]; ok {
+			st.Applied, st.AppliedAt = true, r.AppliedAt
+			st.Changed = r.Checksum != m.checksum()
+			delete(applied, m.Version)
+		}
+		res = append(res, st)
+	}
+	for _, r := range applied {
+		res = append(res, MigrationStatus{
+			Version:   r.Version,
+			Name:      r.Name,
+			Applied:   true,
+			AppliedAt: r.AppliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+// Up applies all pending migrations
+func (mg *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	return mg.UpTo(ctx, 0)
+}
+
+// UpTo applies pending migrations up to and including version, 0 means all.
+// Each migration runs in its own transaction together with its version record.
+func (mg *Migrator) UpTo(ctx context.Context, version int64) ([]*Migration, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mg.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for _, m := range mg.migrations {
+		if version > 0 && m.Version > version {
+			break
+		}
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := mg.exec(ctx, m, func(ctx context.Context, db ZormDBIFace) error {
+			if err := m.run(ctx, db, true); err != nil {
+				return err
+			}
+			_, err := TableContext(ctx, db, mg.table).Insert(&migrationRecord{
+				Version:   m.Version,
+				Name:      m.Name,
+				Checksum:  m.checksum(),
+				AppliedAt: time.Now().UTC(),
+			})
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %s up: %w", m, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+// Like Up, it refuses to run when an applied migration was edited.
+func (mg *Migrator) Down(ctx context.Context, steps int) ([]*Migration, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := mg.verify(applied); err != nil {
+		return nil, err
+	}
+
+	var done []*Migration
+	for i := len(mg.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := mg.migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if !m.hasDown() {
+			return done, fmt.Errorf("migration %s: %w", m, ErrNoDownMigration)
+		}
+		err := mg.exec(ctx, m, func(ctx context.Context, db ZormDBIFace) error {
+			if err := m.run(ctx, db, false); err != nil {
+				return err
+			}
+			_, err := TableContext(ctx, db, mg.table).Delete(Where(Eq("version", m.Version)))
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %s down: %w", m, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func (mg *Migrator) exec(ctx context.Context, m *Migration, fn MigrationFunc) error {
+	if m.NoTx {
+		return fn(ctx, mg.db)
+	}
+	return Tx(ctx, mg.db, func(tx ZormTxIFace) error {
+		return fn(ctx, tx)
+	})
+}
@@ -0,0 +1,253 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 命名参数：Where、Having、Cond、Expr、Exec 的唯一参数为 V、map[string]T 或结构体（指针）时，
+// 语句中的 :name、@name 按名字取值并改写为 ? 占位符
+//   - 结构体按 zorm 标签的列名取值，没有同名列时按字段名取值
+//   - 引号内的内容（反斜杠按普通字符处理）、-- 和 /* */ 注释、:: 类型转换和 @@ 系统变量保持原样
+//   - 语句中已有 ? 占位符时不改写
+//   - 取不到参数时 Where、Having、Cond、Expr 记下错误，由 Select、Update、Delete 返回
+//
+// 改写后的语句参与复用缓存的形状key，解析结果按原语句缓存，最多缓存 namedQueryCacheSize 条
+// Example:
+//
+//	Where("age > :min and city = :city", V{"min": 18, "city": "Shenzhen"})
+//	t.Exec("update users set name = @name where id = @id", &user)
+
+// namedQuery 解析后的命名参数语句
+type namedQuery struct {
+	SQL   string   // 改写为 ? 占位符后的语句
+	Names []string // 按占位符顺序排列的参数名，可重复
+}
+
+// namedQueryCacheSize 解析结果的缓存上限，动态拼接的语句超出后每次重新解析
+const namedQueryCacheSize = 1024
+
+var (
+	_namedQueryCache sync.Map // map[string]*namedQuery
+	_namedQueryCount atomic.Int64
+)
+
+// namedCond 供 Where、Having、Cond、Expr 使用，出错时返回原语句和参数，错误记在条件上
+func namedCond(query string, args []interface{}) (string, []interface{}, error) {
+	bound, boundArgs, err := bindNamed(query, args)
+	if err != nil {
+		return query, args, err
+	}
+	return bound, boundArgs, nil
+}
+
+// namedErr 返回查询参数中命名参数绑定失败的错误，包括 And、Or 嵌套的条件和 Join 的 On 条件
+func namedErr(args []ZormItem) error {
+	for _, arg := range args {
+		var conds []interface{}
+		switch a := arg.(type) {
+		case *whereItem:
+			conds = a.Conds
+		case *havingItem:
+			conds = a.Conds
+		case *joinItem:
+			conds = a.On
+		default:
+			conds = []interface{}{a}
+		}
+		if err := condsErr(conds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func condsErr(conds []interface{}) error {
+	for _, c := range conds {
+		switch c := c.(type) {
+		case *ormCond:
+			if c.err != nil {
+				return c.err
+			}
+		case *ormExpr:
+			if c.err != nil {
+				return c.err
+			}
+		case *ormCondEx:
+			if err := condsErr(c.Conds); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bindNamed 改写命名参数语句，不是命名参数的调用原样返回
+func bindNamed(query string, args []interface{}) (string, []interface{}, error) {
+	if len(args) != 1 || !isNamedSource(args[0]) {
+		return query, args, nil
+	}
+
+	var nq *namedQuery
+	if cached, ok := _namedQueryCache.Load(query); ok {
+		nq = cached.(*namedQuery)
+	} else {
+		nq = parseNamed(query)
+		if _namedQueryCount.Load() < namedQueryCacheSize {
+			if _, loaded := _namedQueryCache.LoadOrStore(query, nq); !loaded {
+				_namedQueryCount.Add(1)
+			}
+		}
+	}
+	if len(nq.Names) == 0 {
+		return query, args, nil
+	}
+
+	src := reflect.ValueOf(args[0])
+	for src.Kind() == reflect.Ptr {
+		src = src.Elem()
+	}
+	values := make([]interface{}, len(nq.Names))
+	for i, name := range nq.Names {
+		v, ok := namedValue(src, name)
+		if !ok {
+			return "", nil, fmt.Errorf("named parameter %s not found in %s", name, src.Type())
+		}
+		values[i] = v
+	}
+	return nq.SQL, values, nil
+}
+
+// isNamedSource 判断参数能否作为命名参数的来源：字符串键的 map，或非 time.Time、非 driver.Valuer 的结构体
+func isNamedSource(arg interface{}) bool {
+	if arg == nil {
+		return false
+	}
+	if _, ok := arg.(driver.Valuer); ok {
+		return false
+	}
+	rv := reflect.ValueOf(arg)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		return rv.Type().Key().Kind() == reflect.String
+	case reflect.Struct:
+		if rv.Type() == reflect.TypeOf(time.Time{}) {
+			return false
+		}
+		if rv.CanAddr() {
+			if _, ok := rv.Addr().Interface().(driver.Valuer); ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// namedValue 从 map 或结构体中按名字取值
+func namedValue(src reflect.Value, name string) (interface{}, bool) {
+	if src.Kind() == reflect.Map {
+		v := src.MapIndex(reflect.ValueOf(name).Convert(src.Type().Key()))
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+
+	if path, ok := structFieldPaths(src.Type())[name]; ok {
+		return src.FieldByIndex(path).Interface(), true
+	}
+	if f, ok := src.Type().FieldByName(name); ok && f.IsExported() {
+		return src.FieldByIndex(f.Index).Interface(), true
+	}
+	return nil, false
+}
+
+// parseNamed 把 :name、@name 改写为 ?，语句中已有 ? 占位符时不改写
+func parseNamed(query string) *namedQuery {
+	var (
+		sb    strings.Builder
+		names []string
+	)
+	sb.Grow(len(query))
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			// 引号内原样保留，连续两个引号转义时相当于结束后重新进入
+			// \ 不作转义处理，SQLite 中 'C:\' 是完整的字符串
+			j := i + 1
+			for j < len(query) && query[j] != c {
+				j++
+			}
+			j = min(j, len(query)-1)
+			sb.WriteString(query[i : j+1])
+			i = j
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			// 行注释原样保留到行尾
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i - 1
+			}
+			sb.WriteString(query[i : i+j+1])
+			i += j
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			// 块注释原样保留到 */
+			j := strings.Index(query[i+2:], "*/")
+			end := len(query)
+			if j >= 0 {
+				end = i + 2 + j + 2
+			}
+			sb.WriteString(query[i:end])
+			i = end - 1
+		case c == '?':
+			return &namedQuery{SQL: query}
+		case (c == ':' || c == '@') && i+1 < len(query) && query[i+1] == c:
+			// :: 类型转换、@@ 系统变量
+			sb.WriteString(query[i : i+2])
+			i++
+		case (c == ':' || c == '@') && i+1 < len(query) && isNameStart(query[i+1]) && (i == 0 || !isNameChar(query[i-1])):
+			j := i + 1
+			for j < len(query) && isNameChar(query[j]) {
+				j++
+			}
+			names = append(names, query[i+1:j])
+			sb.WriteByte('?')
+			i = j - 1
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if len(names) == 0 {
+		return &namedQuery{SQL: query}
+	}
+	return &namedQuery{SQL: sb.String(), Names: names}
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,170 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+// NamingStrategy maps struct names to table names and field names to column names.
+// Explicit names in zorm tags and TableName() methods take precedence.
+type NamingStrategy interface {
+	TableName(structName string) string
+	ColumnName(fieldName string) string
+}
+
+// Tabler is implemented by models that choose their own table name
+type Tabler interface {
+	TableName() string
+}
+
+// DefaultNamingStrategy uses acronym-aware snake case and plural table names,
+// e.g. HTTPServer -> http_server, UserApp -> user_apps, Category -> categories.
+// It changes the names of existing schemas, so it has to be set with SetNamingStrategy.
+type DefaultNamingStrategy struct {
+	SingularTable bool // don't pluralize table names
+}
+
+// TableName implements NamingStrategy
+func (ns DefaultNamingStrategy) TableName(structName string) string {
+	name := toSnakeCase(structName)
+	if ns.SingularTable {
+		return name
+	}
+	return pluralize(name)
+}
+
+// ColumnName implements NamingStrategy
+func (ns DefaultNamingStrategy) ColumnName(fieldName string) string {
+	return toSnakeCase(fieldName)
+}
+
+// LegacyNamingStrategy is the naming used unless SetNamingStrategy picks another one:
+// lowercased struct name plus "s" for tables, and snake case that joins acronyms
+// with the following word, e.g. UserApp -> userapps, HTTPServer -> httpserver
+type LegacyNamingStrategy struct{}
+
+// TableName implements NamingStrategy
+func (LegacyNamingStrategy) TableName(structName string) string {
+	return strings.ToLower(structName) + "s"
+}
+
+// ColumnName implements NamingStrategy
+func (LegacyNamingStrategy) ColumnName(fieldName string) string {
+	return camelToSnake(fieldName)
+}
+
+type namingHolder struct {
+	ns  NamingStrategy
+	gen uint64 // caches of names derived from the strategy are only valid for their generation
+}
+
+var (
+	_naming    atomic.Value
+	_namingGen atomic.Uint64
+)
+
+// SetNamingStrategy sets the naming strategy used for struct fields without explicit names,
+// CreateTables and the DDL manager; nil restores LegacyNamingStrategy.
+// Field mappings cached by existing tables and reused SQL are rebuilt with the new strategy.
+// Set it at startup all the same: queries running meanwhile may use either strategy.
+func SetNamingStrategy(ns NamingStrategy) {
+	if ns == nil {
+		ns = LegacyNamingStrategy{}
+	}
+	_naming.Store(namingHolder{ns: ns, gen: _namingGen.Add(1)})
+
+	// Per-table caches are dropped by generation, the global ones can be freed right away
+	for _, cache := range []*sync.Map{&_fieldMapCache, &_fieldPathCache, &_dataBindingCache} {
+		cache.Range(func(key, _ interface{}) bool {
+			cache.Delete(key)
+			return true
+		})
+	}
+}
+
+func naming() NamingStrategy {
+	if h, ok := _naming.Load().(namingHolder); ok {
+		return h.ns
+	}
+	return LegacyNamingStrategy{}
+}
+
+// namingGen returns the generation of the current naming strategy
+func namingGen() uint64 {
+	if h, ok := _naming.Load().(namingHolder); ok {
+		return h.gen
+	}
+	return 0
+}
+
+// toSnakeCase converts CamelCase to snake_case keeping acronyms together,
+// e.g. HTTPServer -> http_server, UserID -> user_id, OAuth2Token -> o_auth2_token
+func toSnakeCase(s string) string {
+	runes := []rune(s)
+	var sb strings.Builder
+	sb.Grow(len(s) + 4)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// A word starts after a lowercase letter or digit, or at the last capital of an acronym
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				sb.WriteByte('_')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
+
+// pluralize returns the English plural of the last word of a snake_case name
+func pluralize(name string) string {
+	switch {
+	case name == "":
+		return name
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsRune("aeiou", rune(name[len(name)-2])):
+		return name[:len(name)-1] + "ies"
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "z"),
+		strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	}
+	return name + "s"
+}
+
+// getTableName returns the table name of a model: its TableName() method if implemented,
+// otherwise the naming strategy applied to the struct name
+func getTableName(model interface{}) string {
+	if t, ok := model.(Tabler); ok {
+		return t.TableName()
+	}
+
+	rt := reflect.TypeOf(model)
+	if rt == nil {
+		return "table"
+	}
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	// TableName declared on the pointer receiver of a struct passed by value
+	if t, ok := reflect.New(rt).Interface().(Tabler); ok {
+		return t.TableName()
+	}
+
+	name := rt.Name()
+	if name == "" {
+		return "table"
+	}
+	return naming().TableName(name)
+}
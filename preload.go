@@ -0,0 +1,366 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Preload 预加载关联字段：主查询结束后收集父键，每个关联只执行一次 In 查询，再把子记录回填到父记录中
+// 关联字段的标签格式：
+//   - zorm:"-,has_many:user_id"                 Orders []Order 或 []*Order，子表 user_id 列引用父表 id 列
+//   - zorm:"-,has_one:user_id"                  Profile *Profile 或 Profile，多条时取第一条
+//   - zorm:"-,has_many:owner_id,references:uid" 父表被引用的列不是 id 时指定
+//
+// 子表名取子结构体的表名，args 为子表查询的附加参数，如 Fields、Where、OrderBy
+// 父键按每批500个分批查询，Limit 作用于每一批子查询，而不是每个父记录或全部子记录
+// 多级关联用点号分隔，如 Preload("Orders.Items")
+// Example:
+//
+//	Select(&users, Where("age > ?", 18), Preload("Orders", Where("status = ?", 1), OrderBy("id")))
+func Preload(field string, args ...ZormItem) *preloadItem {
+	return &preloadItem{Field: field, Args: args}
+}
+
+type preloadItem struct {
+	Field string
+	Args  []ZormItem
+}
+
+func (p *preloadItem) Type() int {
+	return _preload
+}
+
+// BuildSQL 预加载不参与主查询，只把路径写入复用缓存的形状key
+func (p *preloadItem) BuildSQL(sb *strings.Builder) {
+	sb.WriteString(p.Field)
+}
+
+func (p *preloadItem) BuildArgs(stmtArgs *[]interface{}) {}
+
+// splitPreloads 从查询参数中取出预加载项，不修改调用方传入的切片
+func splitPreloads(args []ZormItem) ([]ZormItem, []*preloadItem) {
+	var rest []ZormItem
+	var preloads []*preloadItem
+	for i, arg := range args {
+		p, ok := arg.(*preloadItem)
+		if !ok {
+			if preloads != nil {
+				rest = append(rest, arg)
+			}
+			continue
+		}
+		if preloads == nil {
+			rest = append(make([]ZormItem, 0, len(args)), args[:i]...)
+		}
+		preloads = append(preloads, p)
+	}
+	if preloads == nil {
+		return args, nil
+	}
+	return rest, preloads
+}
+
+// relation 关联字段的定义
+type relation struct {
+	Index      []int        // 关联字段在父结构体中的下标路径
+	Many       bool         // has_many 或 has_one
+	ForeignKey string       // 子表上的外键列
+	References string       // 父表上被引用的列
+	ElemType   reflect.Type // 子结构体类型
+	ElemPtr    bool         // 子记录是否以指针保存
+}
+
+// parseRelation 解析父结构体 rt 上名为 name 的关联字段
+func parseRelation(rt reflect.Type, name string) (*relation, error) {
+	f, ok := rt.FieldByName(name)
+	if !ok {
+		return nil, fmt.Errorf("preload: %s has no field %s", rt, name)
+	}
+
+	rel := &relation{Index: f.Index, References: "id"}
+	for _, opt := range tagOptions(f.Tag.Get("zorm")) {
+		switch {
+		case strings.HasPrefix(opt, "has_many:"):
+			rel.Many, rel.ForeignKey = true, strings.TrimPrefix(opt, "has_many:")
+		case strings.HasPrefix(opt, "has_one:"):
+			rel.ForeignKey = strings.TrimPrefix(opt, "has_one:")
+		case strings.HasPrefix(opt, "references:"):
+			rel.References = strings.TrimPrefix(opt, "references:")
+		}
+	}
+	if rel.ForeignKey == "" {
+		return nil, fmt.Errorf("preload: field %s.%s needs a zorm:\"-,has_many:<column>\" or has_one tag", rt.Name(), name)
+	}
+
+	ft := f.Type
+	if rel.Many {
+		if ft.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("preload: has_many field %s.%s must be a slice", rt.Name(), name)
+		}
+		ft = ft.Elem()
+	}
+	if ft.Kind() == reflect.Ptr {
+		rel.ElemPtr, ft = true, ft.Elem()
+	}
+	if ft.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("preload: field %s.%s must hold structs", rt.Name(), name)
+	}
+	rel.ElemType = ft
+
+	if _, ok := structFieldPaths(rt)[rel.References]; !ok {
+		return nil, fmt.Errorf("preload: %s has no field for column %s", rt.Name(), rel.References)
+	}
+	if _, ok := structFieldPaths(ft)[rel.ForeignKey]; !ok {
+		return nil, fmt.Errorf("preload: %s has no field for column %s", ft.Name(), rel.ForeignKey)
+	}
+	return rel, nil
+}
+
+// preload 对 Select 的结果 res 执行预加载
+func (t *ZormTable) preload(res interface{}, preloads []*preloadItem) error {
+	rv := reflect.ValueOf(res)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	var parents []reflect.Value
+	switch rv.Kind() {
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			if e := derefStruct(rv.Index(i)); e.IsValid() {
+				parents = append(parents, e)
+			}
+		}
+	case reflect.Struct:
+		parents = append(parents, rv)
+	default:
+		return fmt.Errorf("preload: unsupported result type %s", rv.Type())
+	}
+	return t.preloadLevel(parents, preloads)
+}
+
+// preloadLevel 加载 parents 的一级关联，多级路径按第一段分组后递归加载
+func (t *ZormTable) preloadLevel(parents []reflect.Value, preloads []*preloadItem) error {
+	if len(parents) == 0 {
+		return nil
+	}
+
+	var names []string
+	args := make(map[string][]ZormItem)
+	nested := make(map[string][]*preloadItem)
+	for _, p := range preloads {
+		name, rest, _ := strings.Cut(p.Field, ".")
+		if _, ok := nested[name]; !ok {
+			names = append(names, name)
+			nested[name] = nil
+		}
+		if rest == "" {
+			args[name] = p.Args
+		} else {
+			nested[name] = append(nested[name], &preloadItem{Field: rest, Args: p.Args})
+		}
+	}
+
+	for _, name := range names {
+		rel, err := parseRelation(parents[0].Type(), name)
+		if err != nil {
+			return err
+		}
+		children, err := t.loadRelation(parents, rel, args[name])
+		if err != nil {
+			return err
+		}
+		if err := t.preloadLevel(children, nested[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preloadBatchSize 每次子查询 In 条件中父键的最大个数
+const preloadBatchSize = 500
+
+// loadRelation 批量查询子记录并回填，返回回填后的子记录供下一级使用
+func (t *ZormTable) loadRelation(parents []reflect.Value, rel *relation, args []ZormItem) ([]reflect.Value, error) {
+	refPath := structFieldPaths(parents[0].Type())[rel.References]
+
+	// 收集去重后的父键，同一个键可能对应多条父记录
+	var keys []interface{}
+	byKey := make(map[interface{}][]reflect.Value, len(parents))
+	loaded := make(map[uintptr]bool, len(parents))
+	for _, parent := range parents {
+		if loaded[parent.UnsafeAddr()] {
+			continue // []*T 中重复的指针
+		}
+		loaded[parent.UnsafeAddr()] = true
+		field := parent.FieldByIndex(rel.Index)
+		field.Set(reflect.Zero(field.Type()))
+		if rel.Many {
+			field.Set(reflect.MakeSlice(field.Type(), 0, 0))
+		}
+
+		key, ok := relationKey(parent.FieldByIndex(refPath))
+		if !ok {
+			continue
+		}
+		if _, exists := byKey[key]; !exists {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], parent)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	elemType := rel.ElemType
+	if rel.ElemPtr {
+		elemType = reflect.PtrTo(elemType)
+	}
+	child := t.clone()
+	child.Name = getTableName(reflect.New(rel.ElemType).Interface())
+
+	// 分批查询，避免 In 的参数超过数据库的变量上限
+	// 同一个父键只在一批中，每个父记录的子记录顺序不受影响，Limit 按批生效
+	rows := reflect.MakeSlice(reflect.SliceOf(elemType), 0, 0)
+	for start := 0; start < len(keys); start += preloadBatchSize {
+		end := min(start+preloadBatchSize, len(keys))
+		dest := reflect.New(reflect.SliceOf(elemType))
+		if _, err := child.Select(dest.Interface(), relationArgs(rel.ForeignKey, keys[start:end], args)...); err != nil {
+			return nil, err
+		}
+		rows = reflect.AppendSlice(rows, dest.Elem())
+	}
+
+	fkPath := structFieldPaths(rel.ElemType)[rel.ForeignKey]
+	var children []reflect.Value
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i)
+		key, ok := relationKey(derefStruct(row).FieldByIndex(fkPath))
+		if !ok {
+			continue
+		}
+		for _, parent := range byKey[key] {
+			field := parent.FieldByIndex(rel.Index)
+			switch {
+			case rel.Many:
+				field.Set(reflect.Append(field, row))
+			case field.IsZero():
+				field.Set(row)
+			}
+		}
+	}
+
+	// 回填后再收集子记录，取到的是父记录中的副本，下一级才能写回
+	// 同一个父记录出现多次时按地址去重
+	seen := make(map[uintptr]bool)
+	collect := func(e reflect.Value) {
+		if e.IsValid() && !seen[e.UnsafeAddr()] {
+			seen[e.UnsafeAddr()] = true
+			children = append(children, e)
+		}
+	}
+	for _, parent := range parents {
+		field := parent.FieldByIndex(rel.Index)
+		if !rel.Many {
+			if !(field.Kind() == reflect.Struct && field.IsZero()) {
+				collect(derefStruct(field))
+			}
+			continue
+		}
+		for i := 0; i < field.Len(); i++ {
+			collect(derefStruct(field.Index(i)))
+		}
+	}
+	return children, nil
+}
+
+// relationArgs 在子查询参数中加入 In 条件：与已有的 Where 合并，并确保 Fields 包含外键列
+func relationArgs(foreignKey string, keys []interface{}, args []ZormItem) []ZormItem {
+	var fields *fieldsItem
+	var before, after []ZormItem
+	where := &whereItem{Conds: []interface{}{In(foreignKey, keys...)}}
+	for _, arg := range args {
+		switch arg.Type() {
+		case _fields:
+			fields = arg.(*fieldsItem)
+		case _where:
+			where.Conds = append(where.Conds, arg.(*whereItem).Conds...)
+		case _cond, _andCondEx, _orCondEx:
+			where.Conds = append(where.Conds, arg)
+		case _join, _indexedBy:
+			before = append(before, arg)
+		default:
+			after = append(after, arg)
+		}
+	}
+
+	result := make([]ZormItem, 0, len(args)+2)
+	if fields != nil && len(fields.Fields) > 0 {
+		hasKey := false
+		for _, f := range fields.Fields {
+			if f == foreignKey || f == "*" {
+				hasKey = true
+			}
+		}
+		if !hasKey {
+			fields = Fields(append(append([]string{}, fields.Fields...), foreignKey)...)
+		}
+		result = append(result, fields)
+	}
+	result = append(result, before...)
+	result = append(result, where)
+	return append(result, after...)
+}
+
+// relationKey 归一化关联键，使 int64 主键能匹配 int、*int64 等类型的外键；NULL 返回 false
+func relationKey(v reflect.Value) (interface{}, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	case reflect.String:
+		return v.String(), true
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), true
+		}
+	}
+	if !v.Type().Comparable() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+// derefStruct 解引用指针得到可寻址的结构体，nil 返回无效值
+func derefStruct(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return v
+}
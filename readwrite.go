@@ -0,0 +1,480 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadWriteDB 读写分离数据库
+// 读操作按权重在健康的从库间轮询，没有健康的从库时回退到主库
+type ReadWriteDB struct {
+	Master ZormDBIFace   // 主库（写）
+	Slaves []ZormDBIFace // 从库（读）
+
+	mu       sync.Mutex
+	replicas []*replica
+	hc       HealthCheckConfig
+	stop     chan struct{}
+	done     chan struct{}
+
+	stickyWindow int64 // time.Duration，写入后该时间内的读请求走主库
+	lastWrite    int64 // 最近一次写入的 UnixNano
+}
+
+type useMasterKey struct{}
+
+type rwSessionKey struct{}
+
+// rwSession 读己之写会话，会话内发生写入后读请求固定走主库
+type rwSession struct {
+	wrote int32
+}
+
+// UseMaster 返回强制读主库的上下文
+func UseMaster(ctx context.Context) context.Context {
+	return context.WithValue(ctx, useMasterKey{}, true)
+}
+
+// ReadYourWrites 返回带读己之写会话的上下文：通过该上下文（及其派生上下文）写入或开启事务后，
+// 后续使用该上下文的读请求都走主库
+func ReadYourWrites(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(rwSessionKey{}).(*rwSession); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, rwSessionKey{}, &rwSession{})
+}
+
+// Replica 带权重的从库
+type Replica struct {
+	DB     ZormDBIFace
+	Weight int // 小于等于0时按1处理
+}
+
+// ReplicaStats 从库状态统计
+type ReplicaStats struct {
+	Index               int       // 在 Slaves 中的下标
+	Weight              int       // 权重
+	Healthy             bool      // 是否参与读请求
+	ConsecutiveFailures int       // 连续失败次数
+	Queries             int64     // 分配到的读请求数
+	Failures            int64     // 累计失败次数（探测和连接错误）
+	LastError           string    // 最近一次错误
+	LastCheck           time.Time // 最近一次探测时间
+	NextRetry           time.Time // 摘除后下一次探测时间
+}
+
+// HealthCheckConfig 从库健康检查配置
+type HealthCheckConfig struct {
+	Interval    time.Duration // 探测间隔
+	Timeout     time.Duration // 单次探测超时
+	MaxFailures int           // 连续失败次数达到该值后摘除从库
+	Backoff     time.Duration // 摘除后首次重新探测前的等待时间，之后每次失败翻倍
+	MaxBackoff  time.Duration // 等待时间上限
+}
+
+// DefaultHealthCheckConfig 默认健康检查配置
+func DefaultHealthCheckConfig() *HealthCheckConfig {
+	return &HealthCheckConfig{
+		Interval:    5 * time.Second,
+		Timeout:     time.Second,
+		MaxFailures: 3,
+		Backoff:     5 * time.Second,
+		MaxBackoff:  2 * time.Minute,
+	}
+}
+
+type replica struct {
+	db      ZormDBIFace
+	weight  int
+	current int // 平滑加权轮询的当前权重
+
+	healthy   bool
+	failures  int
+	backoff   time.Duration
+	nextRetry time.Time
+	lastErr   error
+	lastCheck time.Time
+
+	queries      int64
+	totalFailure int64
+}
+
+// NewReadWriteDB 创建读写分离数据库
+func NewReadWriteDB(master ZormDBIFace, slaves ...ZormDBIFace) *ReadWriteDB {
+	replicas := make([]Replica, len(slaves))
+	for i, s := range slaves {
+		replicas[i] = Replica{DB: s, Weight: 1}
+	}
+	return NewWeightedReadWriteDB(master, replicas...)
+}
+
+// NewWeightedReadWriteDB 创建按权重分配读请求的读写分离数据库
+func NewWeightedReadWriteDB(master ZormDBIFace, replicas ...Replica) *ReadWriteDB {
+	rw := &ReadWriteDB{
+		Master: master,
+		hc:     *DefaultHealthCheckConfig(),
+	}
+	for _, r := range replicas {
+		rw.Slaves = append(rw.Slaves, r.DB)
+		rw.replicas = append(rw.replicas, newReplica(r.DB, r.Weight))
+	}
+	return rw
+}
+
+func newReplica(db ZormDBIFace, weight int) *replica {
+	if weight <= 0 {
+		weight = 1
+	}
+	return &replica{db: db, weight: weight, healthy: true}
+}
+
+// syncReplicas 兼容直接构造或修改 Slaves 的用法，需持有锁
+// 按下标逐个比较，未替换的从库保留权重和状态
+func (rw *ReadWriteDB) syncReplicas() {
+	changed := len(rw.replicas) != len(rw.Slaves)
+	for i := 0; !changed && i < len(rw.Slaves); i++ {
+		changed = rw.replicas[i].db != rw.Slaves[i]
+	}
+	if !changed {
+		return
+	}
+	replicas := make([]*replica, len(rw.Slaves))
+	for i, s := range rw.Slaves {
+		if i < len(rw.replicas) && rw.replicas[i].db == s {
+			replicas[i] = rw.replicas[i]
+		} else {
+			replicas[i] = newReplica(s, 1)
+		}
+	}
+	rw.replicas = replicas
+}
+
+func (rw *ReadWriteDB) config() HealthCheckConfig {
+	if rw.hc.MaxFailures <= 0 {
+		return *DefaultHealthCheckConfig()
+	}
+	return rw.hc
+}
+
+// pick 平滑加权轮询选择一个健康的从库，没有时返回nil
+// 已摘除的从库到了重试时间后先试用一次读请求，由调用方上报结果，不依赖后台健康检查
+func (rw *ReadWriteDB) pick() (best *replica) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.syncReplicas()
+
+	now := time.Now()
+	for _, r := range rw.replicas {
+		if !r.healthy && !now.Before(r.nextRetry) {
+			// 推迟下一次重试，结果上报前其他请求不会再试用
+			r.nextRetry = now.Add(r.backoff)
+			r.queries++
+			return r
+		}
+	}
+
+	total := 0
+	for _, r := range rw.replicas {
+		if !r.healthy {
+			continue
+		}
+		r.current += r.weight
+		total += r.weight
+		if best == nil || r.current > best.current {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	best.current -= total
+	best.queries++
+	return best
+}
+
+// reportFailure 记录一次失败，连续失败达到阈值时摘除，已摘除的从库延长等待时间
+func (rw *ReadWriteDB) reportFailure(r *replica, err error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	cfg := rw.config()
+	now := time.Now()
+	r.failures++
+	r.totalFailure++
+	r.lastErr = err
+
+	if r.healthy {
+		if r.failures < cfg.MaxFailures {
+			return
+		}
+		r.healthy = false
+		r.backoff = cfg.Backoff
+	} else {
+		r.backoff *= 2
+	}
+	if cfg.MaxBackoff > 0 && r.backoff > cfg.MaxBackoff {
+		r.backoff = cfg.MaxBackoff
+	}
+	r.nextRetry = now.Add(r.backoff)
+}
+
+// reportSuccess 记录一次成功，清零连续失败次数并恢复已摘除的从库
+func (rw *ReadWriteDB) reportSuccess(r *replica) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	r.healthy = true
+	r.failures = 0
+	r.backoff = 0
+	r.nextRetry = time.Time{}
+}
+
+// isConnError 判断是否为连接层面的错误（SQL本身的错误不影响从库健康状态）
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database is closed") ||
+		strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "unable to open database file")
+}
+
+func pingDB(ctx context.Context, db ZormDBIFace) error {
+	if p, ok := db.(interface {
+		PingContext(context.Context) error
+	}); ok {
+		return p.PingContext(ctx)
+	}
+	var one int
+	return db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+}
+
+// CheckHealth 立即探测一轮从库：已摘除且未到重试时间的从库跳过，探测成功的从库恢复
+func (rw *ReadWriteDB) CheckHealth(ctx context.Context) {
+	rw.mu.Lock()
+	rw.syncReplicas()
+	cfg := rw.config()
+	now := time.Now()
+	var targets []*replica
+	for _, r := range rw.replicas {
+		if !r.healthy && now.Before(r.nextRetry) {
+			continue
+		}
+		targets = append(targets, r)
+	}
+	rw.mu.Unlock()
+
+	for _, r := range targets {
+		pingCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cfg.Timeout > 0 {
+			pingCtx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		}
+		err := pingDB(pingCtx, r.db)
+		cancel()
+
+		rw.mu.Lock()
+		r.lastCheck = time.Now()
+		rw.mu.Unlock()
+
+		if err != nil {
+			rw.reportFailure(r, err)
+		} else {
+			rw.reportSuccess(r)
+		}
+	}
+}
+
+// StartHealthCheck 启动后台健康检查，cfg 为nil时使用默认配置，Interval 小于等于0时使用默认间隔，
+// 重复调用会先停止之前的检查
+func (rw *ReadWriteDB) StartHealthCheck(cfg *HealthCheckConfig) {
+	rw.StopHealthCheck()
+	if cfg == nil {
+		cfg = DefaultHealthCheckConfig()
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = DefaultHealthCheckConfig().Interval
+	}
+
+	rw.mu.Lock()
+	rw.hc = *cfg
+	stop, done := make(chan struct{}), make(chan struct{})
+	rw.stop, rw.done = stop, done
+	rw.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				rw.CheckHealth(context.Background())
+			}
+		}
+	}()
+}
+
+// StopHealthCheck 停止后台健康检查
+func (rw *ReadWriteDB) StopHealthCheck() {
+	rw.mu.Lock()
+	stop, done := rw.stop, rw.done
+	rw.stop, rw.done = nil, nil
+	rw.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// SetHealthCheckConfig 设置摘除阈值和退避参数，不启动后台检查
+func (rw *ReadWriteDB) SetHealthCheckConfig(cfg *HealthCheckConfig) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.hc = *cfg
+}
+
+// SetStickyWindow 设置写入后读主库的时间窗口，0表示关闭
+func (rw *ReadWriteDB) SetStickyWindow(d time.Duration) {
+	atomic.StoreInt64(&rw.stickyWindow, int64(d))
+}
+
+// readFromMaster 判断读请求是否需要走主库
+func (rw *ReadWriteDB) readFromMaster(ctx context.Context) bool {
+	if ctx != nil {
+		if force, _ := ctx.Value(useMasterKey{}).(bool); force {
+			return true
+		}
+		if sess, ok := ctx.Value(rwSessionKey{}).(*rwSession); ok && atomic.LoadInt32(&sess.wrote) != 0 {
+			return true
+		}
+	}
+	if window := atomic.LoadInt64(&rw.stickyWindow); window > 0 {
+		last := atomic.LoadInt64(&rw.lastWrite)
+		return last != 0 && time.Now().UnixNano()-last < window
+	}
+	return false
+}
+
+// markWrite 记录一次写入
+func (rw *ReadWriteDB) markWrite(ctx context.Context) {
+	if ctx != nil {
+		if sess, ok := ctx.Value(rwSessionKey{}).(*rwSession); ok {
+			atomic.StoreInt32(&sess.wrote, 1)
+		}
+	}
+	atomic.StoreInt64(&rw.lastWrite, time.Now().UnixNano())
+}
+
+// Stats 返回各从库的状态统计
+func (rw *ReadWriteDB) Stats() []ReplicaStats {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.syncReplicas()
+	stats := make([]ReplicaStats, len(rw.replicas))
+	for i, r := range rw.replicas {
+		stats[i] = ReplicaStats{
+			Index:               i,
+			Weight:              r.weight,
+			Healthy:             r.healthy,
+			ConsecutiveFailures: r.failures,
+			Queries:             r.queries,
+			Failures:            r.totalFailure,
+			LastCheck:           r.lastCheck,
+			NextRetry:           r.nextRetry,
+		}
+		if r.lastErr != nil {
+			stats[i].LastError = r.lastErr.Error()
+		}
+	}
+	return stats
+}
+
+// QueryRowContext 实现 ZormDBIFace 接口（读操作使用从库，连接错误时记录失败并回退到主库）
+func (rw *ReadWriteDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	var r *replica
+	if !rw.readFromMaster(ctx) {
+		r = rw.pick()
+	}
+	if r == nil {
+		return rw.Master.QueryRowContext(ctx, query, args...)
+	}
+	row := r.db.QueryRowContext(ctx, query, args...)
+	if err := row.Err(); err != nil && isConnError(err) {
+		rw.reportFailure(r, err)
+		return rw.Master.QueryRowContext(ctx, query, args...)
+	}
+	// 从库有响应（含SQL错误）即清零连续失败次数
+	rw.reportSuccess(r)
+	return row
+}
+
+// QueryContext 实现 ZormDBIFace 接口（读操作使用从库，连接错误时记录失败并回退到主库）
+func (rw *ReadWriteDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var r *replica
+	if !rw.readFromMaster(ctx) {
+		r = rw.pick()
+	}
+	if r == nil {
+		return rw.Master.QueryContext(ctx, query, args...)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil && isConnError(err) {
+		rw.reportFailure(r, err)
+		return rw.Master.QueryContext(ctx, query, args...)
+	}
+	rw.reportSuccess(r)
+	return rows, err
+}
+
+// ExecContext 实现 ZormDBIFace 接口（写操作使用主库）
+func (rw *ReadWriteDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	res, err := rw.Master.ExecContext(ctx, query, args...)
+	if err == nil {
+		rw.markWrite(ctx)
+	}
+	return res, err
+}
+
+// BeginTx 在主库上开启事务，使 BeginContext 和 Tx 可用于读写分离数据库
+// 非只读事务开启时即视为一次写入
+func (rw *ReadWriteDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	txDB, ok := rw.Master.(interface {
+		BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
+	})
+	if !ok {
+		return nil, errors.New("database does not support transactions")
+	}
+	tx, err := txDB.BeginTx(ctx, opts)
+	if err == nil && (opts == nil || !opts.ReadOnly) {
+		rw.markWrite(ctx)
+	}
+	return tx, err
+}
+
+// Begin 在主库上开启事务
+func (rw *ReadWriteDB) Begin() (*sql.Tx, error) {
+	return rw.BeginTx(context.Background(), nil)
+}
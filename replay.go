@@ -0,0 +1,485 @@
+/*
+   zorm is a better orm library for Go.
+
+  Copyright (c) 2019 <http://ez8.co> <orca.zhang@yahoo.com>
+
+  This library is released under the MIT License.
+  Please see LICENSE file or visit https://github.com/IceWhaleTech/zorm for details.
+*/
+
+package zorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+录制回放相关
+
+Recorder 包装真实数据库，记录SQL、参数和结果并保存为JSON文件；
+Replayer 读取该文件，不连接数据库直接返回记录的结果，遇到未记录的SQL时报错。
+*/
+
+// GoldenEntry 一条录制的调用
+type GoldenEntry struct {
+	Kind         string          `json:"kind"` // query 或 exec
+	SQL          string          `json:"sql"`
+	Args         []GoldenValue   `json:"args,omitempty"`
+	Columns      []string        `json:"columns,omitempty"`
+	Rows         [][]GoldenValue `json:"rows,omitempty"`
+	LastInsertID int64           `json:"last_insert_id,omitempty"`
+	RowsAffected int64           `json:"rows_affected,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// GoldenFile 录制文件内容
+type GoldenFile struct {
+	Entries []*GoldenEntry `json:"entries"`
+}
+
+// GoldenValue 带类型的值，保证回放时和驱动返回的类型一致
+type GoldenValue struct {
+	V driver.Value
+}
+
+type goldenValueJSON struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// MarshalJSON .
+func (g GoldenValue) MarshalJSON() ([]byte, error) {
+	var (
+		typ string
+		val interface{}
+	)
+	switch v := g.V.(type) {
+	case nil:
+		return json.Marshal(goldenValueJSON{Type: "null"})
+	case int64:
+		typ, val = "int64", v
+	case float64:
+		typ, val = "float64", v
+	case bool:
+		typ, val = "bool", v
+	case string:
+		typ, val = "string", v
+	case []byte:
+		typ, val = "bytes", v
+	case time.Time:
+		typ, val = "time", v.Format(time.RFC3339Nano)
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", g.V)
+	}
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(goldenValueJSON{Type: typ, Value: raw})
+}
+
+// UnmarshalJSON .
+func (g *GoldenValue) UnmarshalJSON(data []byte) error {
+	var j goldenValueJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	switch j.Type {
+	case "null":
+		g.V = nil
+	case "int64":
+		var v int64
+		err = json.Unmarshal(j.Value, &v)
+		g.V = v
+	case "float64":
+		var v float64
+		err = json.Unmarshal(j.Value, &v)
+		g.V = v
+	case "bool":
+		var v bool
+		err = json.Unmarshal(j.Value, &v)
+		g.V = v
+	case "string":
+		var v string
+		err = json.Unmarshal(j.Value, &v)
+		g.V = v
+	case "bytes":
+		var v []byte
+		err = json.Unmarshal(j.Value, &v)
+		g.V = v
+	case "time":
+		var s string
+		if err = json.Unmarshal(j.Value, &s); err == nil {
+			g.V, err = time.Parse(time.RFC3339Nano, s)
+		}
+	default:
+		err = fmt.Errorf("unsupported value type %q", j.Type)
+	}
+	return err
+}
+
+// toGoldenArgs 将参数转换为驱动值，保证录制和回放时的比较一致
+func toGoldenArgs(args []interface{}) ([]GoldenValue, error) {
+	res := make([]GoldenValue, len(args))
+	for i, arg := range args {
+		if valuer, ok := arg.(driver.Valuer); ok {
+			v, err := valuer.Value()
+			if err != nil {
+				return nil, err
+			}
+			arg = v
+		}
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("arg %d: %w", i, err)
+		}
+		res[i] = GoldenValue{V: v}
+	}
+	return res, nil
+}
+
+func (e *GoldenEntry) matches(kind, query string, args []GoldenValue) bool {
+	if e.Kind != kind || e.SQL != query || len(e.Args) != len(args) {
+		return false
+	}
+	for i := range args {
+		if !goldenValueEqual(e.Args[i].V, args[i].V) {
+			return false
+		}
+	}
+	return true
+}
+
+func goldenValueEqual(a, b driver.Value) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (e *GoldenEntry) err() error {
+	if e.Error == "" {
+		return nil
+	}
+	return errors.New(e.Error)
+}
+
+/*
+内存驱动：*sql.Rows 和 *sql.Row 只能由 database/sql 生成，
+因此录制和回放的结果都通过该驱动返回，查询语句为结果的编号
+*/
+
+type goldenResult struct {
+	columns []string
+	rows    [][]GoldenValue
+	err     error
+}
+
+var (
+	_goldenResults sync.Map // string -> *goldenResult
+	_goldenSeq     int64
+	_goldenDB      *sql.DB
+	_goldenDBOnce  sync.Once
+)
+
+func goldenDB() *sql.DB {
+	_goldenDBOnce.Do(func() {
+		_goldenDB = sql.OpenDB(goldenConnector{})
+	})
+	return _goldenDB
+}
+
+// serveRows 通过内存驱动返回记录的结果
+func serveRows(ctx context.Context, res *goldenResult) (*sql.Rows, error) {
+	if res.err != nil {
+		return nil, res.err
+	}
+	key := strconv.FormatInt(atomic.AddInt64(&_goldenSeq, 1), 10)
+	_goldenResults.Store(key, res)
+	defer _goldenResults.Delete(key)
+	return goldenDB().QueryContext(ctx, key)
+}
+
+func serveRow(ctx context.Context, res *goldenResult) *sql.Row {
+	key := strconv.FormatInt(atomic.AddInt64(&_goldenSeq, 1), 10)
+	_goldenResults.Store(key, res)
+	defer _goldenResults.Delete(key)
+	return goldenDB().QueryRowContext(ctx, key)
+}
+
+type goldenConnector struct{}
+
+func (goldenConnector) Connect(context.Context) (driver.Conn, error) { return goldenConn{}, nil }
+func (goldenConnector) Driver() driver.Driver                        { return goldenDriver{} }
+
+type goldenDriver struct{}
+
+func (goldenDriver) Open(string) (driver.Conn, error) { return goldenConn{}, nil }
+
+type goldenConn struct{}
+
+func (goldenConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("zorm replay: prepare not supported")
+}
+
+func (goldenConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("zorm replay: transactions not supported")
+}
+
+func (goldenConn) Close() error { return nil }
+
+func (goldenConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	v, ok := _goldenResults.LoadAndDelete(query)
+	if !ok {
+		return nil, fmt.Errorf("zorm replay: unknown result %s", query)
+	}
+	res := v.(*goldenResult)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &goldenRows{res: res}, nil
+}
+
+type goldenRows struct {
+	res *goldenResult
+	pos int
+}
+
+func (r *goldenRows) Columns() []string { return r.res.columns }
+func (r *goldenRows) Close() error      { return nil }
+
+func (r *goldenRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.res.rows) {
+		return io.EOF
+	}
+	for i, v := range r.res.rows[r.pos] {
+		dest[i] = v.V
+	}
+	r.pos++
+	return nil
+}
+
+type goldenExecResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r goldenExecResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r goldenExecResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// Recorder 录制真实数据库的调用，实现 ZormDBIFace
+type Recorder struct {
+	DB   ZormDBIFace
+	Path string
+
+	mu      sync.Mutex
+	entries []*GoldenEntry
+}
+
+// NewRecorder 创建录制器，调用 Save 写入 path
+func NewRecorder(db ZormDBIFace, path string) *Recorder {
+	return &Recorder{DB: db, Path: path}
+}
+
+func (r *Recorder) add(e *GoldenEntry) {
+	r.mu.Lock()
+	r.entries = append(r.entries, e)
+	r.mu.Unlock()
+}
+
+// query 执行真实查询并读出全部结果
+func (r *Recorder) query(ctx context.Context, query string, args []interface{}) *goldenResult {
+	e := &GoldenEntry{Kind: "query", SQL: query}
+	res := &goldenResult{}
+	defer func() {
+		if res.err != nil {
+			e.Error = res.err.Error()
+		}
+		e.Columns, e.Rows = res.columns, res.rows
+		r.add(e)
+	}()
+
+	if e.Args, res.err = toGoldenArgs(args); res.err != nil {
+		return res
+	}
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer rows.Close()
+
+	if res.columns, res.err = rows.Columns(); res.err != nil {
+		return res
+	}
+	vals := make([]interface{}, len(res.columns))
+	ptrs := make([]interface{}, len(res.columns))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if res.err = rows.Scan(ptrs...); res.err != nil {
+			return res
+		}
+		row := make([]GoldenValue, len(vals))
+		for i, v := range vals {
+			if b, ok := v.([]byte); ok {
+				v = append([]byte(nil), b...)
+			}
+			row[i] = GoldenValue{V: v}
+		}
+		res.rows = append(res.rows, row)
+	}
+	res.err = rows.Err()
+	return res
+}
+
+// QueryRowContext 实现 ZormDBIFace 接口
+func (r *Recorder) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return serveRow(ctx, r.query(ctx, query, args))
+}
+
+// QueryContext 实现 ZormDBIFace 接口
+func (r *Recorder) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return serveRows(ctx, r.query(ctx, query, args))
+}
+
+// ExecContext 实现 ZormDBIFace 接口
+func (r *Recorder) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e := &GoldenEntry{Kind: "exec", SQL: query}
+	goldenArgs, err := toGoldenArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	e.Args = goldenArgs
+
+	res, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		e.Error = err.Error()
+		r.add(e)
+		return nil, err
+	}
+	e.LastInsertID, _ = res.LastInsertId()
+	e.RowsAffected, _ = res.RowsAffected()
+	r.add(e)
+	return res, nil
+}
+
+// Entries 返回已录制的调用
+func (r *Recorder) Entries() []*GoldenEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*GoldenEntry(nil), r.entries...)
+}
+
+// Save 将录制结果写入 Path
+func (r *Recorder) Save() error {
+	data, err := json.MarshalIndent(&GoldenFile{Entries: r.Entries()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.Path, append(data, '\n'), 0o644)
+}
+
+// Replayer 回放录制的调用，实现 ZormDBIFace
+// 每条记录只回放一次，按SQL和参数匹配第一条未使用的记录，找不到时返回错误
+type Replayer struct {
+	mu         sync.Mutex
+	entries    []*GoldenEntry
+	used       []bool
+	unexpected []string
+}
+
+// NewReplayer 读取录制文件
+func NewReplayer(path string) (*Replayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f GoldenFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("zorm replay: %s: %w", path, err)
+	}
+	return &Replayer{entries: f.Entries, used: make([]bool, len(f.Entries))}, nil
+}
+
+func (r *Replayer) next(kind, query string, args []interface{}) (*GoldenEntry, error) {
+	goldenArgs, err := toGoldenArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, e := range r.entries {
+		if !r.used[i] && e.matches(kind, query, goldenArgs) {
+			r.used[i] = true
+			return e, nil
+		}
+	}
+	msg := fmt.Sprintf("%s %q %v", kind, query, args)
+	r.unexpected = append(r.unexpected, msg)
+	return nil, fmt.Errorf("zorm replay: unexpected %s", msg)
+}
+
+func (r *Replayer) query(query string, args []interface{}) *goldenResult {
+	e, err := r.next("query", query, args)
+	if err != nil {
+		return &goldenResult{err: err}
+	}
+	return &goldenResult{columns: e.Columns, rows: e.Rows, err: e.err()}
+}
+
+// QueryRowContext 实现 ZormDBIFace 接口
+func (r *Replayer) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return serveRow(ctx, r.query(query, args))
+}
+
+// QueryContext 实现 ZormDBIFace 接口
+func (r *Replayer) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return serveRows(ctx, r.query(query, args))
+}
+
+// ExecContext 实现 ZormDBIFace 接口
+func (r *Replayer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e, err := r.next("exec", query, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.err(); err != nil {
+		return nil, err
+	}
+	return goldenExecResult{lastInsertID: e.LastInsertID, rowsAffected: e.RowsAffected}, nil
+}
+
+// Finish 检查是否有未预期的调用或未使用的记录，可用于发现生成的SQL发生了变化
+func (r *Replayer) Finish() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []error
+	for _, msg := range r.unexpected {
+		errs = append(errs, fmt.Errorf("unexpected %s", msg))
+	}
+	for i, e := range r.entries {
+		if !r.used[i] {
+			errs = append(errs, fmt.Errorf("unused %s %q", e.Kind, e.SQL))
+		}
+	}
+	return errors.Join(errs...)
+}
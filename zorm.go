@@ -12,6 +12,7 @@ package zorm
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"log"
@@ -40,6 +41,10 @@ const (
 	_orderBy
 	_limit
 	_onConflictDoUpdateSet
+	_compound
+	_returning
+	_preload
+	_keyBy
 
 	_cond = iota
 	_andCondEx
@@ -54,7 +59,7 @@ const (
 )
 
 var config struct {
-	Mock bool
+	Mock int32 // 开启mock的数量：全局 ZormMock 计1，每个 MockScope 计1，原子访问
 }
 
 // V - an alias object value type
@@ -75,27 +80,55 @@ type Config struct {
 func Table(db ZormDBIFace, name string, ctx ...context.Context) *ZormTable {
 	if len(ctx) > 0 {
 		return &ZormTable{
-			DB:   db,
-			Name: name,
-			ctx:  ctx[0],
-			Cfg:  Config{Reuse: true}, // 默认开启Reuse（内建形状感知）
+			DB:            db,
+			Name:          name,
+			ctx:           ctx[0],
+			Cfg:           Config{Reuse: true}, // 默认开启Reuse（内建形状感知）
+			fieldMapCache: &sync.Map{},
 		}
 	}
 	return &ZormTable{
-		DB:   db,
-		Name: name,
-		ctx:  context.Background(),
-		Cfg:  Config{Reuse: true}, // 默认开启Reuse（内建形状感知）
+		DB:            db,
+		Name:          name,
+		ctx:           context.Background(),
+		Cfg:           Config{Reuse: true}, // 默认开启Reuse（内建形状感知）
+		fieldMapCache: &sync.Map{},
 	}
 }
 
 // TableContext 创建带Context的Table，参数顺序：context, db, name
 func TableContext(ctx context.Context, db ZormDBIFace, name string) *ZormTable {
 	return &ZormTable{
-		DB:   db,
-		Name: name,
-		ctx:  ctx,
-		Cfg:  Config{Reuse: true}, // 默认开启Reuse（内建形状感知）
+		DB:            db,
+		Name:          name,
+		ctx:           ctx,
+		Cfg:           Config{Reuse: true}, // 默认开启Reuse（内建形状感知）
+		fieldMapCache: &sync.Map{},
+	}
+}
+
+// WithContext 返回使用 ctx 的浅拷贝，原 Table 保持不变
+// 拷贝复制当前配置并共享字段映射缓存，适合在包级 Table 变量上按请求传入 ctx
+func (t *ZormTable) WithContext(ctx context.Context) *ZormTable {
+	c := t.clone()
+	c.ctx = ctx
+	return c
+}
+
+// WithTx 返回绑定到事务 tx 的浅拷贝，原 Table 保持不变
+func (t *ZormTable) WithTx(tx ZormDBIFace) *ZormTable {
+	c := t.clone()
+	c.DB = tx
+	return c
+}
+
+func (t *ZormTable) clone() *ZormTable {
+	return &ZormTable{
+		DB:            t.DB,
+		Name:          t.Name,
+		Cfg:           t.Cfg,
+		ctx:           t.ctx,
+		fieldMapCache: t.fieldMaps(),
 	}
 }
 
@@ -157,6 +190,9 @@ func buildShapeKey(baseKey string, op string, args []ZormItem) string {
 	b.WriteString(baseKey)
 	b.WriteString("|")
 	b.WriteString(op)
+	// 命名策略变化后列名不同，缓存的SQL随之失效
+	b.WriteString("|")
+	b.WriteString(strconv.FormatUint(namingGen(), 10))
 	for _, a := range args {
 		b.WriteString("|")
 		b.WriteString(strconv.Itoa(a.Type()))
@@ -218,10 +254,12 @@ func FullJoin(table string, on ...interface{}) *joinItem {
 func Where(conds ...interface{}) *whereItem {
 	if l := len(conds); l > 0 {
 		if s, ok := conds[0].(string); ok {
+			op, args, err := namedCond(s, conds[1:l])
 			return &whereItem{Conds: []interface{}{
 				&ormCond{
-					Op:   s,
-					Args: conds[1:l],
+					Op:   op,
+					Args: args,
+					err:  err,
 				},
 			}}
 		}
@@ -248,10 +286,12 @@ func GroupBy(fields ...string) *groupByItem {
 func Having(conds ...interface{}) *havingItem {
 	if l := len(conds); l > 0 {
 		if s, ok := conds[0].(string); ok {
+			op, args, err := namedCond(s, conds[1:l])
 			return &havingItem{Conds: []interface{}{
 				&ormCond{
-					Op:   s,
-					Args: conds[1:l],
+					Op:   op,
+					Args: args,
+					err:  err,
 				},
 			}}
 		}
@@ -319,14 +359,88 @@ func OnConflictDoUpdateSet(conflictFields []string, updateFields []string) *onCo
 	return res
 }
 
+// OnConflict 构建 SQLite/PostgreSQL 的 on conflict 子句，配合 DoNothing/DoUpdate 使用
+// 未调用 DoUpdate 时等价于 do nothing；conflictFields 为空时不指定冲突目标（仅 do nothing 可用）
+// 示例：
+//
+//	OnConflict("device_id", "metric").
+//		DoUpdate(V{"count": U("count+excluded.count"), "last_seen": U("excluded.last_seen")}).
+//		Where("last_seen < excluded.last_seen")
+func OnConflict(conflictFields ...string) *onConflictItem {
+	return &onConflictItem{Fields: conflictFields}
+}
+
+// OnConflictDoNothing 冲突时忽略本行
+func OnConflictDoNothing(conflictFields ...string) *onConflictItem {
+	return OnConflict(conflictFields...)
+}
+
+// Returning 为 Insert/Update/Delete 追加 returning 子句（SQLite 3.35+、PostgreSQL）
+// 返回的行按列名回填到传入的对象中，Delete 或需要额外目标时使用 Into 指定
+// 未指定字段时使用 returning *
+func Returning(fields ...string) *returningItem {
+	return &returningItem{Fields: fields}
+}
+
 // IndexedBy .
 func IndexedBy(idx string) *indexedByItem {
 	return &indexedByItem{idx: idx}
 }
 
+// Query 定义一个子查询，用于 Union/UnionAll/Intersect/Except 组合查询
+// 未指定 Fields 时沿用主查询的字段列表
+func Query(table string, args ...ZormItem) *queryItem {
+	return &queryItem{Table: table, Args: args}
+}
+
+// Union 组合查询（去重）
+func Union(queries ...*queryItem) *compoundItem {
+	return &compoundItem{Op: "union", Queries: queries}
+}
+
+// UnionAll 组合查询（不去重）
+func UnionAll(queries ...*queryItem) *compoundItem {
+	return &compoundItem{Op: "union all", Queries: queries}
+}
+
+// Intersect 取交集
+func Intersect(queries ...*queryItem) *compoundItem {
+	return &compoundItem{Op: "intersect", Queries: queries}
+}
+
+// Except 取差集
+func Except(queries ...*queryItem) *compoundItem {
+	return &compoundItem{Op: "except", Queries: queries}
+}
+
 // Select .
-func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
+func (t *ZormTable) Select(res interface{}, args ...ZormItem) (n int, err error) {
 	// Support unconditional queries (no args required)
+	if err := namedErr(args); err != nil {
+		return 0, err
+	}
+
+	// 生成语句后、执行前检查mock
+	if mockEnabled() && t.mock == nil {
+		t = t.withMock("Select", res)
+		defer func() {
+			hit, ok := err.(*mockHit)
+			if !ok {
+				return
+			}
+			// 调用顺序错误或只返回错误的mock没有数据
+			if hit.err == nil && hit.data != nil {
+				setMockData(res, hit.data)
+			}
+			n, err = hit.n, hit.err
+		}()
+	}
+
+	// 组合查询时 OrderBy/Limit 作用于整个结果集，需要放到最后
+	args = reorderCompoundArgs(args)
+	// 预加载在主查询之后执行，不参与SQL构建
+	args, preloads := splitPreloads(args)
+	args, keyBy := splitKeyBy(args)
 
 	var (
 		rt         = reflect2.TypeOf(res)
@@ -374,12 +488,18 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 		return 0, errors.New("argument 2 should be map or ptr")
 	}
 
-	if config.Mock {
-		pc, fileName, _, _ := runtime.Caller(1)
-		if ok, data, n, e := checkMock(t.Name, "Select", runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
-			rt.UnsafeSet(reflect2.PtrOf(res), reflect2.PtrOf(data))
-			return n, e
-		}
+	// []map[...] 是多行结果，只有 map 本身才能按键组织
+	keyed := !isArray && rtElem.Kind() == reflect.Map && isKeyedMap(rtElem.Type1(), keyBy)
+	if keyBy != "" && !keyed {
+		return 0, errors.New("KeyBy needs a map result")
+	}
+	if isArray && rtElem.Kind() == reflect.Map && rtElem.Type1().Key().Kind() != reflect.String {
+		return 0, errors.New("maps in a slice result are keyed by column name and need string keys")
+	}
+
+	// map[int64]User、map[int64]string、map[int64][]Order 等按键组织的结果
+	if keyed {
+		return t.selectKeyed(res, keyBy, args, preloads)
 	}
 
 	if t.Cfg.Reuse {
@@ -454,7 +574,7 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 									ft := f.Tag().Get("zorm")
 
 									// 忽略zorm tag为"-"的字段
-									if ft == "-" {
+									if isIgnoredTag(ft) {
 										continue
 									}
 
@@ -463,6 +583,12 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 										continue
 									}
 
+									// zorm:"orders.*" 或带 prefix: 的结构体字段：列名加表名限定或前缀，扫描到嵌套结构体中
+									if table, prefix, ok := nestedTable(f); ok {
+										item.collectNested(sb, f, table, prefix)
+										continue
+									}
+
 									// 处理embedded struct：直接递归展开，不使用结构体名称
 									if f.Anonymous() && f.Type().Kind() == reflect.Struct {
 										embeddedStruct := f.Type().(reflect2.StructType)
@@ -519,7 +645,7 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 						ft := f.Tag().Get("zorm")
 
 						// 忽略zorm tag为"-"的字段
-						if ft == "-" {
+						if isIgnoredTag(ft) {
 							continue
 						}
 
@@ -528,6 +654,12 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 							continue
 						}
 
+						// zorm:"orders.*" 或带 prefix: 的结构体字段：列名加表名限定或前缀，扫描到嵌套结构体中
+						if table, prefix, ok := nestedTable(f); ok {
+							item.collectNested(sb, f, table, prefix)
+							continue
+						}
+
 						// 处理embedded struct：直接递归展开，不使用结构体名称
 						if f.Anonymous() && f.Type().Kind() == reflect.Struct {
 							embeddedStruct := f.Type().(reflect2.StructType)
@@ -611,26 +743,23 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 			args = args[1:]
 		}
 
+		// 记录主查询的字段列表，供组合查询中未指定 Fields 的子查询沿用
+		selectFields := sb.String()[len("select "):]
+
 		sb.WriteString(" from ")
 
 		fieldEscape(sb, t.Name)
 
 		// 处理 args，自动将 ormCond 和 ormCondEx 包装为 whereItem
 		for _, arg := range args {
-			// 如果 arg 是 ormCondEx，自动包装为 whereItem
-			if condEx, ok := arg.(*ormCondEx); ok {
-				whereItem := &whereItem{Conds: []interface{}{condEx}}
-				whereItem.BuildSQL(sb)
-				whereItem.BuildArgs(&stmtArgs)
-			} else if cond, ok := arg.(*ormCond); ok {
-				// 如果 arg 是 ormCond，自动包装为 whereItem
-				whereItem := &whereItem{Conds: []interface{}{cond}}
-				whereItem.BuildSQL(sb)
-				whereItem.BuildArgs(&stmtArgs)
-			} else {
-				arg.BuildSQL(sb)
-				arg.BuildArgs(&stmtArgs)
+			if c, ok := arg.(*compoundItem); ok {
+				c.buildSQL(sb, selectFields)
+				c.BuildArgs(&stmtArgs)
+				continue
 			}
+			arg = wrapCond(arg)
+			arg.BuildSQL(sb)
+			arg.BuildArgs(&stmtArgs)
 		}
 
 		item.SQL = sb.String()
@@ -643,6 +772,10 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 		}
 	}
 
+	if err := t.checkStmtMock(item.SQL, stmtArgs); err != nil {
+		return 0, err
+	}
+
 	if t.Cfg.Debug {
 		log.Println(item.SQL, stmtArgs)
 	}
@@ -734,6 +867,7 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 				}
 				return 0, err
 			}
+			item.finishRow()
 
 			// 如果是指针的指针（如 **User），需要创建对象并设置
 			if isPtrPtr && rtElem.Kind() == reflect.Struct {
@@ -756,6 +890,9 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 				}
 			}
 
+			if len(preloads) > 0 {
+				err = t.preload(res, preloads)
+			}
 			return 1, err
 		}
 	}
@@ -781,26 +918,12 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 	for rows.Next() {
 		if rtElem.Kind() == reflect.Map {
 			// Map类型需要特殊处理
-			values := make([]interface{}, len(item.Fields))
-			for i := range values {
-				values[i] = &values[i]
-			}
-
-			err = rows.Scan(values...)
+			var mapVal reflect.Value
+			mapVal, err = scanMapRow(rows, item.Fields, rtElem.Type1())
 			if err != nil {
 				break
 			}
 
-			// 构建map
-			mapVal := reflect.MakeMap(rtElem.(reflect2.MapType).Type1())
-			for i, field := range item.Fields {
-				if ptr, ok := values[i].(*interface{}); ok {
-					mapVal.SetMapIndex(reflect.ValueOf(field), reflect.ValueOf(*ptr))
-				} else {
-					mapVal.SetMapIndex(reflect.ValueOf(field), reflect.ValueOf(values[i]))
-				}
-			}
-
 			// 添加到slice
 			if isPtrArray {
 				rt.(reflect2.SliceType).UnsafeAppend(reflect2.PtrOf(res), unsafe.Pointer(&mapVal))
@@ -813,6 +936,7 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 			if err != nil {
 				break
 			}
+			item.finishRow()
 
 			if isPtrArray {
 				copyElem := rtElem.UnsafeNew()
@@ -825,16 +949,19 @@ func (t *ZormTable) Select(res interface{}, args ...ZormItem) (int, error) {
 		count++
 	}
 	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil && count > 0 && len(preloads) > 0 {
+		err = t.preload(res, preloads)
+	}
 	return count, err
 }
 
 // InsertIgnore .
 func (t *ZormTable) InsertIgnore(objs interface{}, args ...ZormItem) (int, error) {
-	if config.Mock {
-		pc, fileName, _, _ := runtime.Caller(1)
-		if ok, _, n, e := checkMock(t.Name, "InsertIgnore", runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
-			return n, e
-		}
+	if mockEnabled() {
+		return mockResult(t.withMock("InsertIgnore", objs).insert("insert or ignore into ", objs, args))
 	}
 
 	return t.insert("insert or ignore into ", objs, args)
@@ -842,11 +969,8 @@ func (t *ZormTable) InsertIgnore(objs interface{}, args ...ZormItem) (int, error
 
 // ReplaceInto .
 func (t *ZormTable) ReplaceInto(objs interface{}, args ...ZormItem) (int, error) {
-	if config.Mock {
-		pc, fileName, _, _ := runtime.Caller(1)
-		if ok, _, n, e := checkMock(t.Name, "ReplaceInto", runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
-			return n, e
-		}
+	if mockEnabled() {
+		return mockResult(t.withMock("ReplaceInto", objs).insert("replace into ", objs, args))
 	}
 
 	return t.insert("replace into ", objs, args)
@@ -854,11 +978,8 @@ func (t *ZormTable) ReplaceInto(objs interface{}, args ...ZormItem) (int, error)
 
 // Insert .
 func (t *ZormTable) Insert(objs interface{}, args ...ZormItem) (int, error) {
-	if config.Mock {
-		pc, fileName, _, _ := runtime.Caller(1)
-		if ok, _, n, e := checkMock(t.Name, "Insert", runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
-			return n, e
-		}
+	if mockEnabled() {
+		return mockResult(t.withMock("Insert", objs).insert("insert into ", objs, args))
 	}
 
 	return t.insert("insert into ", objs, args)
@@ -876,6 +997,10 @@ func (t *ZormTable) insert(prefix string, objs interface{}, args []ZormItem) (in
 		return 0, errors.New("cannot insert nil pointer")
 	}
 
+	// returning 结果默认回填到插入的对象中
+	args, ret := moveReturningLast(args)
+	retDest := objs
+
 	var (
 		rt         = reflect2.TypeOf(objs)
 		rtOrig     = rt          // 保存原始类型，用于判断 objs 是否已经是指针
@@ -1061,8 +1186,13 @@ func (t *ZormTable) insert(prefix string, objs interface{}, args []ZormItem) (in
 					args[i].BuildArgs(&stmtArgs)
 				}
 
+				if ret != nil {
+					defer putSQLBuilder(sb)
+					return t.execReturning(sb.String(), stmtArgs, ret, retDest)
+				}
+
 				// 执行SQL
-				result, err := t.DB.ExecContext(t.ctx, sb.String(), stmtArgs...)
+				result, err := t.execStmt(sb.String(), stmtArgs)
 				if err != nil {
 					return 0, err
 				}
@@ -1086,7 +1216,8 @@ func (t *ZormTable) insert(prefix string, objs interface{}, args []ZormItem) (in
 			objs = reflect2.PtrOf(objs)
 			rt = reflect2.TypeOf(objs)
 		case reflect.Map:
-			// 处理map类型
+			// 处理map类型，各分支都直接返回
+			defer putSQLBuilder(sb)
 			mapType := rt.(reflect2.MapType)
 			keyType := mapType.Key()
 
@@ -1170,8 +1301,12 @@ func (t *ZormTable) insert(prefix string, objs interface{}, args []ZormItem) (in
 				}
 			}
 
+			if ret != nil {
+				return t.execReturning(sb.String(), stmtArgs, ret, retDest)
+			}
+
 			// 执行SQL
-			result, err := t.DB.ExecContext(t.ctx, sb.String(), stmtArgs...)
+			result, err := t.execStmt(sb.String(), stmtArgs)
 			if err != nil {
 				return 0, err
 			}
@@ -1267,7 +1402,12 @@ func (t *ZormTable) insert(prefix string, objs interface{}, args []ZormItem) (in
 		log.Println(item.SQL, stmtArgs)
 	}
 
-	res, err := t.DB.ExecContext(t.ctx, item.SQL, stmtArgs...)
+	// 使用 returning 回填时不再依赖 LastInsertId 推算自增ID
+	if ret != nil {
+		return t.execReturning(item.SQL, stmtArgs, ret, retDest)
+	}
+
+	res, err := t.execStmt(item.SQL, stmtArgs)
 	if err != nil {
 		return 0, err
 	}
@@ -1383,18 +1523,22 @@ func (t *ZormTable) insert(prefix string, objs interface{}, args []ZormItem) (in
 }
 
 // Update .
-func (t *ZormTable) Update(obj interface{}, args ...ZormItem) (int, error) {
-	if config.Mock {
-		pc, fileName, _, _ := runtime.Caller(1)
-		if ok, _, n, e := checkMock(t.Name, "Update", runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
-			return n, e
-		}
+func (t *ZormTable) Update(obj interface{}, args ...ZormItem) (n int, err error) {
+	if err := namedErr(args); err != nil {
+		return 0, err
+	}
+
+	if mockEnabled() {
+		t = t.withMock("Update", obj)
+		defer func() { n, err = mockResult(n, err) }()
 	}
 
 	if len(args) <= 0 {
 		return 0, errors.New("argument 2 cannot be omitted")
 	}
 
+	args, ret := moveReturningLast(args)
+
 	var (
 		stmtArgs []interface{}
 		item     *DataBindingItem
@@ -1562,7 +1706,7 @@ func (t *ZormTable) Update(obj interface{}, args ...ZormItem) (int, error) {
 						if !t.Cfg.UseNameWhenTagEmpty && ft == "" {
 							continue
 						}
-						if ft == "-" {
+						if isIgnoredTag(ft) {
 							continue
 						}
 						// 忽略 ZormLastId 字段（向后兼容字段，不是数据库列）
@@ -1597,7 +1741,7 @@ func (t *ZormTable) Update(obj interface{}, args ...ZormItem) (int, error) {
 						if !t.Cfg.UseNameWhenTagEmpty && ft == "" {
 							continue
 						}
-						if ft == "-" {
+						if isIgnoredTag(ft) {
 							continue
 						}
 						// 忽略 ZormLastId 字段
@@ -1659,7 +1803,11 @@ func (t *ZormTable) Update(obj interface{}, args ...ZormItem) (int, error) {
 		log.Println(item.SQL, stmtArgs)
 	}
 
-	res, err := t.DB.ExecContext(t.ctx, item.SQL, stmtArgs...)
+	if ret != nil {
+		return t.execReturning(item.SQL, stmtArgs, ret, obj)
+	}
+
+	res, err := t.execStmt(item.SQL, stmtArgs)
 	if err != nil {
 		return 0, err
 	}
@@ -1669,18 +1817,21 @@ func (t *ZormTable) Update(obj interface{}, args ...ZormItem) (int, error) {
 }
 
 // Delete .
-func (t *ZormTable) Delete(args ...ZormItem) (int, error) {
+func (t *ZormTable) Delete(args ...ZormItem) (n int, err error) {
 	if len(args) <= 0 {
 		return 0, errors.New("argument 1 cannot be omitted")
 	}
+	if err := namedErr(args); err != nil {
+		return 0, err
+	}
 
-	if config.Mock {
-		pc, fileName, _, _ := runtime.Caller(1)
-		if ok, _, n, e := checkMock(t.Name, "Delete", runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
-			return n, e
-		}
+	if mockEnabled() {
+		t = t.withMock("Delete", nil)
+		defer func() { n, err = mockResult(n, err) }()
 	}
 
+	args, ret := moveReturningLast(args)
+
 	var (
 		stmtArgs []interface{}
 		item     *DataBindingItem
@@ -1755,7 +1906,11 @@ func (t *ZormTable) Delete(args ...ZormItem) (int, error) {
 		log.Println(item.SQL, stmtArgs)
 	}
 
-	res, err := t.DB.ExecContext(t.ctx, item.SQL, stmtArgs...)
+	if ret != nil {
+		return t.execReturning(item.SQL, stmtArgs, ret, nil)
+	}
+
+	res, err := t.execStmt(item.SQL, stmtArgs)
 	if err != nil {
 		return 0, err
 	}
@@ -1765,11 +1920,17 @@ func (t *ZormTable) Delete(args ...ZormItem) (int, error) {
 }
 
 // Exec executes a raw SQL statement with optional parameters
+// A single V, map or struct argument binds :name/@name parameters, e.g. Exec("update t set a = :a where id = :id", &obj)
 // Returns the number of affected rows and any error
 func (t *ZormTable) Exec(query string, args ...interface{}) (int, error) {
-	if config.Mock {
+	query, args, err := bindNamed(query, args)
+	if err != nil {
+		return 0, err
+	}
+
+	if mockEnabled() {
 		pc, fileName, _, _ := runtime.Caller(1)
-		if ok, _, n, e := checkMock(t.Name, "Exec", runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
+		if ok, _, n, e := checkMock(t.ctx, &MockCall{Table: t.Name, Func: "Exec", SQL: query, Args: args}, runtime.FuncForPC(pc).Name(), fileName, path.Dir(fileName)); ok {
 			return n, e
 		}
 	}
@@ -1794,6 +1955,226 @@ func (t *ZormTable) Exec(query string, args ...interface{}) (int, error) {
 	return int(rowsAffected), nil
 }
 
+// execReturning 执行带 returning 子句的语句，并将返回的行扫描到目标中
+// 优先使用 Into 指定的目标，否则使用 def（传入 Insert/Update 的结构体或结构体切片）
+// 传入的 map 是要写入的列，不回填返回的行，需要用 Into 指定目标
+func (t *ZormTable) execReturning(query string, stmtArgs []interface{}, ret *returningItem, def interface{}) (int, error) {
+	dest := ret.Dest
+	if dest == nil {
+		if isMapObject(def) {
+			return 0, errors.New("returning with a map object requires a destination, use Returning(...).Into(&dest)")
+		}
+		dest = def
+	}
+	if dest == nil {
+		return 0, errors.New("returning requires a destination, use Returning(...).Into(&dest)")
+	}
+
+	rv := reflect.ValueOf(dest)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return 0, errors.New("returning destination is a nil pointer")
+		}
+	case reflect.Slice, reflect.Map:
+		// 非指针的切片/map 与调用方共享底层数据，包装成指针后原地回填
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		dest = ptr.Interface()
+	default:
+		return 0, errors.New("returning destination should be a pointer, use Returning(...).Into(&dest)")
+	}
+
+	if err := t.checkStmtMock(query, stmtArgs); err != nil {
+		return 0, err
+	}
+	rows, err := t.DB.QueryContext(t.ctx, query, stmtArgs...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	return t.scanRowsInto(rows, dest)
+}
+
+// isMapObject 判断 Insert/Update 的对象是否为 map 或 map 切片
+func isMapObject(obj interface{}) bool {
+	if obj == nil {
+		return false
+	}
+	rt := reflect.TypeOf(obj)
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt.Kind() == reflect.Slice {
+		rt = rt.Elem()
+		for rt.Kind() == reflect.Ptr {
+			rt = rt.Elem()
+		}
+	}
+	return rt.Kind() == reflect.Map
+}
+
+// scanRowsInto 按列名将结果集扫描到 dest 中，与 Select 使用相同的字段映射和 scanner
+// dest 支持 *struct、*[]struct、*[]*struct、*map[string]T、*[]map[string]T、*基础类型、*[]基础类型
+// 切片中已有的元素按顺序回填，多出的行追加到末尾；非切片目标只接收第一行
+func (t *ZormTable) scanRowsInto(rows *sql.Rows, dest interface{}) (int, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	target := reflect.ValueOf(dest).Elem()
+	isSlice := target.Kind() == reflect.Slice && target.Type().Elem().Kind() != reflect.Uint8
+
+	count := 0
+	for rows.Next() {
+		elem := target
+		if isSlice {
+			if count >= target.Len() {
+				target.Set(reflect.Append(target, reflect.Zero(target.Type().Elem())))
+			}
+			elem = target.Index(count)
+		} else if count > 0 {
+			count++
+			continue
+		}
+
+		if elem.Kind() == reflect.Ptr && elem.Type().Elem().Kind() == reflect.Struct {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			elem = elem.Elem()
+		}
+
+		if err := t.scanRowInto(rows, columns, elem); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// scanRowInto 将当前行扫描到可寻址的 elem 中
+func (t *ZormTable) scanRowInto(rows *sql.Rows, columns []string, elem reflect.Value) error {
+	switch {
+	case elem.Kind() == reflect.Map:
+		m, err := scanMapRow(rows, columns, elem.Type())
+		if err != nil {
+			return err
+		}
+		if elem.IsNil() {
+			elem.Set(m)
+			return nil
+		}
+		iter := m.MapRange()
+		for iter.Next() {
+			elem.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return nil
+	case elem.Kind() == reflect.Struct && elem.Type() != reflect.TypeOf(time.Time{}):
+		// 与 Select 的 Fields 相同，按列名取结构体字段，没有对应字段的列丢弃
+		vals := make([]interface{}, len(columns))
+		fields := t.getStructFieldMap(reflect2.Type2(elem.Type()).(reflect2.StructType))
+		for i, col := range columns {
+			if f := fields[col]; f != nil {
+				vals[i] = &scanner{Type: f.Type(), Val: f.UnsafeGet(unsafe.Pointer(elem.UnsafeAddr()))}
+			} else {
+				vals[i] = new(interface{})
+			}
+		}
+		return rows.Scan(vals...)
+	default:
+		vals := make([]interface{}, len(columns))
+		for i := range vals {
+			vals[i] = new(interface{})
+		}
+		if len(vals) > 0 {
+			vals[0] = &scanner{Type: reflect2.Type2(elem.Type()), Val: unsafe.Pointer(elem.UnsafeAddr())}
+		}
+		return rows.Scan(vals...)
+	}
+}
+
+// scanMapRow 将当前行扫描为以列名为键的 map
+// 值类型为 interface{} 时保留驱动返回的值，NULL 的列不写入；其他值类型经 scanner 转换，NULL 为零值
+func scanMapRow(rows *sql.Rows, columns []string, mapType reflect.Type) (reflect.Value, error) {
+	if mapType.Key().Kind() != reflect.String {
+		return reflect.Value{}, errors.New("map key must be string type")
+	}
+	values := make([]interface{}, len(columns))
+	var typed []reflect.Value
+	if valType := mapType.Elem(); valType.Kind() == reflect.Interface {
+		for i := range values {
+			values[i] = &values[i]
+		}
+	} else {
+		typed = make([]reflect.Value, len(values))
+		for i := range values {
+			typed[i] = reflect.New(valType)
+			values[i] = &scanner{Type: reflect2.Type2(valType), Val: unsafe.Pointer(typed[i].Pointer())}
+		}
+	}
+	if err := rows.Scan(values...); err != nil {
+		return reflect.Value{}, err
+	}
+
+	m := reflect.MakeMap(mapType)
+	for i, col := range columns {
+		key := reflect.ValueOf(col).Convert(mapType.Key())
+		if typed != nil {
+			m.SetMapIndex(key, typed[i].Elem())
+		} else if ptr, ok := values[i].(*interface{}); ok {
+			m.SetMapIndex(key, reflect.ValueOf(*ptr))
+		} else {
+			m.SetMapIndex(key, reflect.ValueOf(values[i]))
+		}
+	}
+	return m, nil
+}
+
+var _fieldPathCache sync.Map // map[reflect.Type]fieldPathEntry
+
+// fieldPathEntry 记录生成映射时的命名策略代数
+type fieldPathEntry struct {
+	gen   uint64
+	paths map[string][]int
+}
+
+// structFieldPaths 返回数据库列名到结构体字段索引路径的映射（支持嵌入结构体）
+func structFieldPaths(rt reflect.Type) map[string][]int {
+	gen := namingGen()
+	if cached, ok := _fieldPathCache.Load(rt); ok && cached.(fieldPathEntry).gen == gen {
+		return cached.(fieldPathEntry).paths
+	}
+
+	m := make(map[string][]int)
+	var collect func(s reflect2.StructType, index []int)
+	collect = func(s reflect2.StructType, index []int) {
+		for i := 0; i < s.NumField(); i++ {
+			f := s.Field(i)
+			if isIgnoredTag(f.Tag().Get("zorm")) || f.Name() == "ZormLastId" {
+				continue
+			}
+
+			path := append(append([]int{}, index...), i)
+			if f.Anonymous() && f.Type().Kind() == reflect.Struct {
+				collect(f.Type().(reflect2.StructType), path)
+				continue
+			}
+
+			if name := getFieldName(f); name != "" {
+				if _, exists := m[name]; !exists {
+					m[name] = path
+				}
+			}
+		}
+	}
+	collect(reflect2.Type2(rt).(reflect2.StructType), nil)
+
+	_fieldPathCache.Store(rt, fieldPathEntry{gen: gen, paths: m})
+	return m
+}
+
 func (t *ZormTable) inputArgs(stmtArgs *[]interface{}, cols []reflect2.StructField, rtPtr, s reflect2.Type, ptr bool, x unsafe.Pointer) {
 	// 使用 reflect 包获取结构体值，以便正确处理嵌入结构体
 	var rv reflect.Value
@@ -1922,7 +2303,9 @@ type ZormTable struct {
 	ctx  context.Context
 
 	// 字段映射缓存，避免重复计算
-	fieldMapCache sync.Map
+	fieldMapCache *sync.Map
+	// 开启mock时记录调用信息，生成语句后检查mock
+	mock *pendingMock
 }
 
 // camelToSnake converts camelCase to snake_case
@@ -1949,22 +2332,22 @@ func camelToSnake(s string) string {
 
 // getFieldName returns the database field name for a struct field
 // Supported formats:
-// - zorm:"-" - ignore field
+// - zorm:"-" - ignore field, options may follow as in zorm:"-,has_many:user_id"
 // - zorm:"field_name,auto_incr" - use field_name as DB column, auto_incr as supplement
 // - zorm:"field_name" - use field_name as DB column
-// - zorm:"auto_incr" - use field name converted to snake_case, auto_incr as supplement
-// - empty tag - convert field name from camelCase to snake_case
+// - zorm:"auto_incr" - use field name converted by the naming strategy, auto_incr as supplement
+// - empty tag - convert field name by the naming strategy, snake_case by default
 func getFieldName(f reflect2.StructField) string {
 	ft := f.Tag().Get("zorm")
 
 	// If tag is "-", skip this field
-	if ft == "-" {
+	if isIgnoredTag(ft) {
 		return ""
 	}
 
 	// If tag is empty, convert field name to snake_case
 	if ft == "" {
-		return camelToSnake(f.Name())
+		return naming().ColumnName(f.Name())
 	}
 
 	// Parse tag: "field_name,auto_incr" or "field_name" or "auto_incr"
@@ -1973,13 +2356,18 @@ func getFieldName(f reflect2.StructField) string {
 
 	// If field name is empty or just "auto_incr", use converted field name
 	if fieldName == "" || fieldName == "auto_incr" {
-		return camelToSnake(f.Name())
+		return naming().ColumnName(f.Name())
 	}
 
 	// Use the specified field name
 	return fieldName
 }
 
+// isIgnoredTag 判断字段是否不对应数据库列，即 zorm:"-" 或带选项的 zorm:"-,has_many:user_id"
+func isIgnoredTag(ft string) bool {
+	return ft == "-" || strings.HasPrefix(ft, "-,")
+}
+
 func fieldEscape(sb *strings.Builder, field string) {
 	if field == "" {
 		return
@@ -1993,13 +2381,24 @@ func fieldEscape(sb *strings.Builder, field string) {
 	}
 }
 
+// _fieldMapCache 供未通过 Table/TableContext 创建的 ZormTable 使用
+var _fieldMapCache sync.Map
+
+func (t *ZormTable) fieldMaps() *sync.Map {
+	if t.fieldMapCache == nil {
+		return &_fieldMapCache
+	}
+	return t.fieldMapCache
+}
+
 func (t *ZormTable) getStructFieldMap(s reflect2.StructType) map[string]reflect2.StructField {
 	// 使用结构体类型作为缓存key
 	typeKey := s.String()
 
-	// 尝试从缓存中获取
-	if cached, ok := t.fieldMapCache.Load(typeKey); ok {
-		return cached.(map[string]reflect2.StructField)
+	// 尝试从缓存中获取，命名策略变化后的旧映射视为未命中
+	gen := namingGen()
+	if cached, ok := t.fieldMaps().Load(typeKey); ok && cached.(fieldMapEntry).gen == gen {
+		return cached.(fieldMapEntry).fields
 	}
 
 	// 缓存未命中，构建字段映射
@@ -2007,10 +2406,16 @@ func (t *ZormTable) getStructFieldMap(s reflect2.StructType) map[string]reflect2
 	t.collectStructFields(s, m, "")
 
 	// 存储到缓存中
-	t.fieldMapCache.Store(typeKey, m)
+	t.fieldMaps().Store(typeKey, fieldMapEntry{gen: gen, fields: m})
 	return m
 }
 
+// fieldMapEntry 记录生成映射时的命名策略代数
+type fieldMapEntry struct {
+	gen    uint64
+	fields map[string]reflect2.StructField
+}
+
 // collectStructFields 递归收集结构体字段，支持embedded struct
 // 只支持 zorm:"auto_incr" 和 zorm:"-" 标签
 // 自动将驼峰命名转换为蛇形命名
@@ -2020,7 +2425,7 @@ func (t *ZormTable) collectStructFields(s reflect2.StructType, m map[string]refl
 		ft := f.Tag().Get("zorm")
 
 		// 忽略zorm tag为"-"的字段
-		if ft == "-" {
+		if isIgnoredTag(ft) {
 			continue
 		}
 
@@ -2061,16 +2466,18 @@ func isAutoIncrementField(f reflect2.StructField) bool {
 	ft := f.Tag().Get("zorm")
 
 	// If tag is empty or "-", not auto increment
-	if ft == "" || ft == "-" {
+	if ft == "" || isIgnoredTag(ft) {
 		return false
 	}
 
 	// Parse tag: "field_name,auto_incr" or "field_name" or "auto_incr"
-	tags := strings.Split(ft, ",")
+	if name, _, _ := strings.Cut(ft, ","); strings.TrimSpace(name) == "auto_incr" {
+		return true
+	}
 
-	// Check if any tag contains "auto_incr"
-	for _, tag := range tags {
-		if strings.TrimSpace(tag) == "auto_incr" {
+	// Check if any option is "auto_incr"
+	for _, tag := range tagOptions(ft) {
+		if tag == "auto_incr" {
 			return true
 		}
 	}
@@ -2113,7 +2520,7 @@ func (t *ZormTable) collectFieldsForInsertWithPrefix(s reflect2.StructType, sb *
 		ft := f.Tag().Get("zorm")
 
 		// 忽略zorm tag为"-"的字段
-		if ft == "-" {
+		if isIgnoredTag(ft) {
 			continue
 		}
 
@@ -2176,7 +2583,7 @@ func (t *ZormTable) collectStructFieldsGeneric(s reflect2.StructType, sb *string
 		ft := f.Tag().Get("zorm")
 
 		// 忽略zorm tag为"-"的字段
-		if ft == "-" {
+		if isIgnoredTag(ft) {
 			continue
 		}
 
@@ -2361,46 +2768,193 @@ func (w *onConflictDoUpdateSetItem) BuildArgs(stmtArgs *[]interface{}) {
 	// OnConflictDoUpdateSet 使用 excluded. 语法，不需要额外的参数
 }
 
-type joinItem struct {
-	Stmt     string        // 原始语句（向后兼容）
-	JoinType string        // 连接类型：LEFT JOIN, RIGHT JOIN, INNER JOIN, FULL OUTER JOIN
-	Table    string        // 表名
-	On       []interface{} // ON 条件
+type onConflictItem struct {
+	Fields []string
+	Target *whereItem // 部分索引的冲突目标条件
+	Set    V
+	Cond   *whereItem // do update 的 where 条件
 }
 
-func (w *joinItem) Type() int {
-	return _join
+// TargetWhere 指定部分索引（partial index）的冲突目标条件
+// SQLite 要求该条件与索引定义一致，不能使用参数占位符
+func (c *onConflictItem) TargetWhere(conds ...interface{}) *onConflictItem {
+	c.Target = Where(conds...)
+	return c
 }
 
-func (w *joinItem) BuildSQL(sb *strings.Builder) {
-	sb.WriteString(" ")
+// DoNothing 冲突时忽略本行
+func (c *onConflictItem) DoNothing() *onConflictItem {
+	c.Set = nil
+	c.Cond = nil
+	return c
+}
 
-	// 如果有原始语句，直接使用（向后兼容）
-	if w.Stmt != "" {
-		sb.WriteString(w.Stmt)
+// DoUpdate 冲突时更新，值为 U 时作为表达式写入（可引用 excluded.列名），否则作为参数绑定
+func (c *onConflictItem) DoUpdate(set V) *onConflictItem {
+	c.Set = set
+	return c
+}
+
+// Where 指定 do update 的条件，不满足时保留原行
+func (c *onConflictItem) Where(conds ...interface{}) *onConflictItem {
+	c.Cond = Where(conds...)
+	return c
+}
+
+func (c *onConflictItem) Type() int {
+	return _onConflictDoUpdateSet
+}
+
+// setKeys 排序后的更新字段，保证SQL形状稳定
+func (c *onConflictItem) setKeys() []string {
+	keys := make([]string, 0, len(c.Set))
+	for k := range c.Set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *onConflictItem) BuildSQL(sb *strings.Builder) {
+	sb.WriteString(" on conflict")
+	if len(c.Fields) > 0 {
+		sb.WriteString("(")
+		for i, field := range c.Fields {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			fieldEscape(sb, field)
+		}
+		sb.WriteString(")")
+		if c.Target != nil {
+			c.Target.BuildSQL(sb)
+		}
+	}
+
+	if len(c.Set) == 0 {
+		sb.WriteString(" do nothing")
 		return
 	}
 
-	// 构建新的联表查询语句
-	if w.JoinType != "" && w.Table != "" {
-		sb.WriteString(w.JoinType)
-		sb.WriteString(" ")
-		fieldEscape(sb, w.Table)
+	sb.WriteString(" do update set ")
+	for i, k := range c.setKeys() {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fieldEscape(sb, k)
+		if u, ok := c.Set[k].(U); ok {
+			sb.WriteString("=")
+			sb.WriteString(string(u))
+		} else {
+			sb.WriteString("=?")
+		}
+	}
+	if c.Cond != nil {
+		c.Cond.BuildSQL(sb)
+	}
+}
 
-		if len(w.On) > 0 {
-			sb.WriteString(" ON ")
-			// 构建 ON 条件，类似 Where 的处理方式
-			if s, ok := w.On[0].(string); ok {
-				// 字符串格式：直接使用
-				sb.WriteString(s)
-			} else if whereItem, ok := w.On[0].(*whereItem); ok {
-				// 如果是 whereItem，提取其条件并构建 ON 子句
-				for i, c := range whereItem.Conds {
-					if i > 0 {
-						sb.WriteString(" AND ")
-					}
-					if cond, ok := c.(*ormCond); ok {
-						cond.BuildSQL(sb)
+func (c *onConflictItem) BuildArgs(stmtArgs *[]interface{}) {
+	if len(c.Fields) > 0 && c.Target != nil {
+		c.Target.BuildArgs(stmtArgs)
+	}
+	if len(c.Set) == 0 {
+		return
+	}
+	for _, k := range c.setKeys() {
+		if _, ok := c.Set[k].(U); !ok {
+			*stmtArgs = append(*stmtArgs, c.Set[k])
+		}
+	}
+	if c.Cond != nil {
+		c.Cond.BuildArgs(stmtArgs)
+	}
+}
+
+type returningItem struct {
+	Fields []string
+	Dest   interface{}
+}
+
+// Into 指定 returning 结果的扫描目标，与 Select 支持的目标类型一致
+func (r *returningItem) Into(dest interface{}) *returningItem {
+	r.Dest = dest
+	return r
+}
+
+func (r *returningItem) Type() int {
+	return _returning
+}
+
+func (r *returningItem) BuildSQL(sb *strings.Builder) {
+	sb.WriteString(" returning ")
+	if len(r.Fields) == 0 {
+		sb.WriteString("*")
+		return
+	}
+	for i, field := range r.Fields {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fieldEscape(sb, field)
+	}
+}
+
+func (r *returningItem) BuildArgs(stmtArgs *[]interface{}) {}
+
+// moveReturningLast 将 returning 子句移到参数最后，保证其位于 on conflict/where 之后
+func moveReturningLast(args []ZormItem) ([]ZormItem, *returningItem) {
+	for i, arg := range args {
+		if r, ok := arg.(*returningItem); ok {
+			res := make([]ZormItem, 0, len(args))
+			res = append(res, args[:i]...)
+			res = append(res, args[i+1:]...)
+			return append(res, r), r
+		}
+	}
+	return args, nil
+}
+
+type joinItem struct {
+	Stmt     string        // 原始语句（向后兼容）
+	JoinType string        // 连接类型：LEFT JOIN, RIGHT JOIN, INNER JOIN, FULL OUTER JOIN
+	Table    string        // 表名
+	On       []interface{} // ON 条件
+}
+
+func (w *joinItem) Type() int {
+	return _join
+}
+
+func (w *joinItem) BuildSQL(sb *strings.Builder) {
+	sb.WriteString(" ")
+
+	// 如果有原始语句，直接使用（向后兼容）
+	if w.Stmt != "" {
+		sb.WriteString(w.Stmt)
+		return
+	}
+
+	// 构建新的联表查询语句
+	if w.JoinType != "" && w.Table != "" {
+		sb.WriteString(w.JoinType)
+		sb.WriteString(" ")
+		fieldEscape(sb, w.Table)
+
+		if len(w.On) > 0 {
+			sb.WriteString(" ON ")
+			// 构建 ON 条件，类似 Where 的处理方式
+			if s, ok := w.On[0].(string); ok {
+				// 字符串格式：直接使用
+				sb.WriteString(s)
+			} else if whereItem, ok := w.On[0].(*whereItem); ok {
+				// 如果是 whereItem，提取其条件并构建 ON 子句
+				for i, c := range whereItem.Conds {
+					if i > 0 {
+						sb.WriteString(" AND ")
+					}
+					if cond, ok := c.(*ormCond); ok {
+						cond.BuildSQL(sb)
 					} else if expr, ok := c.(*ormExpr); ok {
 						expr.BuildSQL(sb)
 					} else if condEx, ok := c.(*ormCondEx); ok {
@@ -2472,6 +3026,100 @@ func (w *joinItem) BuildArgs(stmtArgs *[]interface{}) {
 	}
 }
 
+// wrapCond 将单独传入的 ormCond 和 ormCondEx 包装为 whereItem
+func wrapCond(arg ZormItem) ZormItem {
+	switch c := arg.(type) {
+	case *ormCondEx:
+		return &whereItem{Conds: []interface{}{c}}
+	case *ormCond:
+		return &whereItem{Conds: []interface{}{c}}
+	}
+	return arg
+}
+
+type queryItem struct {
+	Table string
+	Args  []ZormItem
+}
+
+func (q *queryItem) buildSQL(sb *strings.Builder, fields string) {
+	args := q.Args
+	sb.WriteString("select ")
+	if len(args) > 0 && args[0].Type() == _fields {
+		args[0].BuildSQL(sb)
+		args = args[1:]
+	} else if fields != "" {
+		sb.WriteString(fields)
+	} else {
+		sb.WriteString("*")
+	}
+	sb.WriteString(" from ")
+	fieldEscape(sb, q.Table)
+	for _, arg := range args {
+		wrapCond(arg).BuildSQL(sb)
+	}
+}
+
+func (q *queryItem) BuildArgs(stmtArgs *[]interface{}) {
+	for _, arg := range q.Args {
+		wrapCond(arg).BuildArgs(stmtArgs)
+	}
+}
+
+type compoundItem struct {
+	Op      string // union, union all, intersect, except
+	Queries []*queryItem
+}
+
+func (c *compoundItem) Type() int {
+	return _compound
+}
+
+func (c *compoundItem) BuildSQL(sb *strings.Builder) {
+	c.buildSQL(sb, "")
+}
+
+// buildSQL fields 为主查询的字段列表
+func (c *compoundItem) buildSQL(sb *strings.Builder, fields string) {
+	for _, q := range c.Queries {
+		sb.WriteString(" ")
+		sb.WriteString(c.Op)
+		sb.WriteString(" ")
+		q.buildSQL(sb, fields)
+	}
+}
+
+func (c *compoundItem) BuildArgs(stmtArgs *[]interface{}) {
+	for _, q := range c.Queries {
+		q.BuildArgs(stmtArgs)
+	}
+}
+
+// reorderCompoundArgs 存在组合查询时，将 OrderBy/Limit 移到组合查询之后
+func reorderCompoundArgs(args []ZormItem) []ZormItem {
+	hasCompound := false
+	for _, arg := range args {
+		if arg.Type() == _compound {
+			hasCompound = true
+			break
+		}
+	}
+	if !hasCompound {
+		return args
+	}
+
+	res := make([]ZormItem, 0, len(args))
+	var tail []ZormItem
+	for _, arg := range args {
+		if ty := arg.Type(); ty == _orderBy || ty == _limit {
+			tail = append(tail, arg)
+		} else {
+			res = append(res, arg)
+		}
+	}
+	return append(res, tail...)
+}
+
 type indexedByItem struct {
 	idx string
 }
@@ -2993,6 +3641,7 @@ type ormCond struct {
 	Field string
 	Op    string
 	Args  []interface{}
+	err   error // 命名参数绑定失败，执行时返回
 }
 
 func (c *ormCond) Type() int {
@@ -3015,6 +3664,7 @@ func (c *ormCond) BuildArgs(stmtArgs *[]interface{}) {
 type ormExpr struct {
 	SQL  string
 	Args []interface{}
+	err  error // 命名参数绑定失败，执行时返回
 }
 
 func (e *ormExpr) BuildSQL(sb *strings.Builder) { sb.WriteString(e.SQL) }
@@ -3085,14 +3735,17 @@ func Or(conds ...interface{}) *ormCondEx {
 
 // Cond .
 func Cond(c string, args ...interface{}) *ormCond {
-	return &ormCond{Op: c, Args: args}
+	c, args, err := namedCond(c, args)
+	return &ormCond{Op: c, Args: args, err: err}
 }
 
 // Expr allows building a custom SQL condition fragment with args, and is usable inside And/Or.
 // Example:
 //   Where(And(Eq("a", 1), Expr("json_extract(data,'$.x') = ?", 2)))
+//   Where(Or(Expr("age > :min", V{"min": 18}), IsNull("age")))
 func Expr(sql string, args ...interface{}) *ormExpr {
-	return &ormExpr{SQL: sql, Args: args}
+	sql, args, err := namedCond(sql, args)
+	return &ormExpr{SQL: sql, Args: args, err: err}
 }
 
 // Eq .
@@ -3213,7 +3866,117 @@ type DataBindingItem struct {
 	Cols   []interface{}
 	Type   reflect2.Type
 	Elem   interface{}
-	Fields []string // 用于Map类型的字段名
+	Fields []string       // 用于Map类型的字段名
+	Nested []*nestedGroup // 指针类型的嵌套结构体
+}
+
+// nestedGroup 指针类型嵌套结构体的一组列，整组为NULL时（如LEFT JOIN未匹配）字段为nil
+type nestedGroup struct {
+	Type  reflect2.StructType
+	Slot  unsafe.Pointer // 外层结构体中 *T 字段的地址
+	Tmp   unsafe.Pointer // 扫描用的临时结构体
+	Valid bool           // 本行是否有非NULL列
+}
+
+// nullTracker 扫描时记录所在组是否有非NULL列
+type nullTracker struct {
+	*scanner
+	Group *nestedGroup
+}
+
+func (n *nullTracker) Scan(src interface{}) error {
+	if src != nil {
+		n.Group.Valid = true
+	}
+	return n.scanner.Scan(src)
+}
+
+// nestedTable 返回 zorm:"orders.*" 标签中的表名（或别名）和 prefix: 选项的列名前缀，字段须为结构体或结构体指针
+// 只有 prefix: 时列名不加表名限定，如 zorm:",prefix:order_" 选择 order_id、order_total
+func nestedTable(f reflect2.StructField) (table, prefix string, ok bool) {
+	ft := f.Tag().Get("zorm")
+	name := strings.TrimSpace(strings.Split(ft, ",")[0])
+	for _, opt := range tagOptions(ft) {
+		if strings.HasPrefix(opt, "prefix:") {
+			prefix, ok = strings.TrimSpace(strings.TrimPrefix(opt, "prefix:")), true
+		}
+	}
+	if strings.HasSuffix(name, ".*") {
+		table, ok = strings.TrimSuffix(name, ".*"), true
+	}
+	if !ok {
+		return "", "", false
+	}
+	t := f.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.(reflect2.PtrType).Elem()
+	}
+	if t.Kind() != reflect.Struct || t.Type1() == reflect.TypeOf(time.Time{}) {
+		return "", "", false
+	}
+	return table, prefix, true
+}
+
+// collectNested 收集嵌套结构体的列，列名写成 table.prefix+column
+// 指针类型的嵌套结构体先扫描到临时结构体，由 finishRow 决定分配还是置nil
+func (item *DataBindingItem) collectNested(sb *strings.Builder, f reflect2.StructField, table, prefix string) {
+	ft := f.Type()
+	base := f.UnsafeGet(reflect2.PtrOf(item.Elem))
+	var group *nestedGroup
+	if ft.Kind() == reflect.Ptr {
+		st := ft.(reflect2.PtrType).Elem().(reflect2.StructType)
+		group = &nestedGroup{Type: st, Slot: base, Tmp: st.UnsafeNew()}
+		item.Nested = append(item.Nested, group)
+		ft, base = st, group.Tmp
+	}
+
+	var collect func(s reflect2.StructType, base unsafe.Pointer)
+	collect = func(s reflect2.StructType, base unsafe.Pointer) {
+		for i := 0; i < s.NumField(); i++ {
+			f := s.Field(i)
+			if isIgnoredTag(f.Tag().Get("zorm")) || f.Name() == "ZormLastId" {
+				continue
+			}
+			if f.Anonymous() && f.Type().Kind() == reflect.Struct {
+				collect(f.Type().(reflect2.StructType), f.UnsafeGet(base))
+				continue
+			}
+			name := getFieldName(f)
+			if name == "" {
+				continue
+			}
+
+			if len(item.Cols) > 0 {
+				sb.WriteString(",")
+			}
+			if table != "" {
+				sb.WriteString("`" + table + "`.")
+			}
+			sb.WriteString("`" + prefix + name + "`")
+
+			sc := &scanner{Type: f.Type(), Val: f.UnsafeGet(base)}
+			if group != nil {
+				item.Cols = append(item.Cols, &nullTracker{scanner: sc, Group: group})
+			} else {
+				item.Cols = append(item.Cols, sc)
+			}
+		}
+	}
+	collect(ft.(reflect2.StructType), base)
+}
+
+// finishRow 扫描完一行后为有值的指针嵌套结构体分配对象，全为NULL的置nil
+func (item *DataBindingItem) finishRow() {
+	for _, g := range item.Nested {
+		if g.Valid {
+			obj := g.Type.UnsafeNew()
+			g.Type.UnsafeSet(obj, g.Tmp)
+			*(*unsafe.Pointer)(g.Slot) = obj
+		} else {
+			*(*unsafe.Pointer)(g.Slot) = nil
+		}
+		g.Valid = false
+	}
 }
 
 var _dataBindingCache sync.Map
@@ -3222,8 +3985,10 @@ var _dataBindingCache sync.Map
 Mock相关
 */
 var (
-	_mockData []*MockMatcher
-	_mutex    sync.Mutex
+	_mockData   []*MockMatcher
+	_mockErrors []error // 顺序错误等，在 ZormMockFinish 时返回
+	_globalMock bool    // 是否有未结束的全局mock
+	_mutex      sync.Mutex
 )
 
 func matchString(src string, matcher string, caseSens bool) bool {
@@ -3287,23 +4052,275 @@ type MockMatcher struct {
 	Data   interface{}
 	Ret    int
 	Err    error
+
+	sql     string
+	args    []interface{}
+	hasArgs bool
+	matchFn func(*MockCall) bool
+	times   int // 剩余次数，-1 表示不限
+	ordered bool
+	calls   []*MockCall
+}
+
+// MockCall 一次被mock命中的调用
+type MockCall struct {
+	Table string
+	Func  string
+	SQL   string        // 真实调用会执行的完整语句，如 "update `users` set `name`=? where `id`=?"
+	Args  []interface{} // SQL 中的参数，包括 Update 的 set 值
+	Obj   interface{}   // Select 的结果参数、Insert/Update 的对象，Delete/Exec 为nil
+}
+
+// ArgMatcher 自定义参数匹配函数，用于 MockMatcher.WithArgs
+type ArgMatcher func(arg interface{}) bool
+
+// AnyArg 匹配任意参数
+func AnyArg() ArgMatcher {
+	return func(interface{}) bool { return true }
+}
+
+// WithSQL 要求生成的SQL匹配，支持通配符'?'和'*'，大小写不敏感
+func (m *MockMatcher) WithSQL(sql string) *MockMatcher {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	m.sql = sql
+	return m
+}
+
+// WithArgs 要求SQL参数完全一致，参数可以是 ArgMatcher 或 func(interface{}) bool
+func (m *MockMatcher) WithArgs(args ...interface{}) *MockMatcher {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	m.args, m.hasArgs = args, true
+	return m
+}
+
+// Match 自定义匹配函数
+func (m *MockMatcher) Match(fn func(call *MockCall) bool) *MockMatcher {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	m.matchFn = fn
+	return m
+}
+
+// Times 命中n次后才算完成，默认1次；0表示不应被调用，调用时返回错误
+func (m *MockMatcher) Times(n int) *MockMatcher {
+	if n < 0 {
+		panic("zorm: mock Times needs n >= 0, use AnyTimes for any number of calls")
+	}
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	m.times = n
+	return m
 }
 
-func checkMock(tbl, fun, caller, file, pkg string) (mocked bool, data interface{}, ret int, err error) {
+// AnyTimes 可以命中任意次（包括0次）
+func (m *MockMatcher) AnyTimes() *MockMatcher {
 	_mutex.Lock()
 	defer _mutex.Unlock()
+	m.times = -1
+	return m
+}
 
-	for i := 0; i < len(_mockData); i++ {
-		data := _mockData[i]
-		if matchString(tbl, data.Tbl, false) &&
-			matchString(fun, data.Func, false) &&
-			matchString(caller, data.Caller, false) &&
-			matchString(file, data.File, false) &&
-			matchString(pkg, data.Pkg, false) {
-			_mockData = append(_mockData[0:i], _mockData[i+1:]...)
-			return true, data.Data, data.Ret, data.Err
+// InOrder 要求按注册顺序命中：在它之前注册的 InOrder mock 未完成时调用会返回错误
+func (m *MockMatcher) InOrder() *MockMatcher {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	m.ordered = true
+	return m
+}
+
+// Calls 返回已命中的调用，可用于检查传入的对象
+func (m *MockMatcher) Calls() []*MockCall {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	return append([]*MockCall(nil), m.calls...)
+}
+
+func (m *MockMatcher) String() string {
+	return fmt.Sprintf("{Tbl:%s Func:%s Caller:%s File:%s Pkg:%s SQL:%s Args:%v Remaining:%d}",
+		m.Tbl, m.Func, m.Caller, m.File, m.Pkg, m.sql, m.args, m.times)
+}
+
+func (m *MockMatcher) matches(call *MockCall, caller, file, pkg string) bool {
+	if !matchString(call.Table, m.Tbl, false) ||
+		!matchString(call.Func, m.Func, false) ||
+		!matchString(caller, m.Caller, false) ||
+		!matchString(file, m.File, false) ||
+		!matchString(pkg, m.Pkg, false) ||
+		!matchString(call.SQL, m.sql, false) {
+		return false
+	}
+	if m.hasArgs {
+		if len(m.args) != len(call.Args) {
+			return false
+		}
+		for i, arg := range m.args {
+			switch fn := arg.(type) {
+			case ArgMatcher:
+				if !fn(call.Args[i]) {
+					return false
+				}
+			case func(interface{}) bool:
+				if !fn(call.Args[i]) {
+					return false
+				}
+			default:
+				if !reflect.DeepEqual(arg, call.Args[i]) {
+					return false
+				}
+			}
 		}
 	}
+	return m.matchFn == nil || m.matchFn(call)
+}
+
+// pendingMock 开启mock时的调用信息，语句生成后再匹配mock
+type pendingMock struct {
+	fun, caller, file string
+	obj               interface{}
+}
+
+// mockHit 命中mock时代替执行结果沿调用链返回，由发起调用的方法转换为mock的返回值
+type mockHit struct {
+	data interface{}
+	n    int
+	err  error
+}
+
+func (h *mockHit) Error() string {
+	return "zorm: mock hit"
+}
+
+// withMock 返回记录了调用方信息的拷贝，须在 Select/Insert 等方法中直接调用
+func (t *ZormTable) withMock(fun string, obj interface{}) *ZormTable {
+	pc, fileName, _, _ := runtime.Caller(2)
+	c := t.clone()
+	c.mock = &pendingMock{fun: fun, caller: runtime.FuncForPC(pc).Name(), file: fileName, obj: obj}
+	return c
+}
+
+// checkStmtMock 用将要执行的语句和参数匹配mock，命中时返回 *mockHit
+func (t *ZormTable) checkStmtMock(query string, args []interface{}) error {
+	if t.mock == nil {
+		return nil
+	}
+	call := &MockCall{Table: t.Name, Func: t.mock.fun, SQL: query, Args: mockArgs(args), Obj: t.mock.obj}
+	if ok, data, n, err := checkMock(t.ctx, call, t.mock.caller, t.mock.file, path.Dir(t.mock.file)); ok {
+		return &mockHit{data: data, n: n, err: err}
+	}
+	return nil
+}
+
+// mockArgs 拷贝参数，绑定字段时传入的指针换成指向的值，与驱动收到的值一致
+func mockArgs(args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, arg := range args {
+		if _, ok := arg.(driver.Valuer); !ok {
+			if v := reflect.ValueOf(arg); v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					arg = nil
+				} else {
+					arg = v.Elem().Interface()
+				}
+			}
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+// setMockData 将mock数据写入 Select 的结果参数，数据可以是结果的值或指向它的指针
+func setMockData(res, data interface{}) {
+	dv := reflect.ValueOf(data)
+	assign := func(rv reflect.Value) bool {
+		if dv.Type().AssignableTo(rv.Type()) {
+			rv.Set(dv)
+			return true
+		}
+		if dv.Kind() == reflect.Ptr && !dv.IsNil() && dv.Elem().Type().AssignableTo(rv.Type()) {
+			rv.Set(dv.Elem())
+			return true
+		}
+		return false
+	}
+	rv := reflect.ValueOf(res)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		if rv = rv.Elem(); assign(rv) {
+			return
+		}
+	}
+	// 非指针的 map 结果原地写入
+	if dv.Kind() == reflect.Ptr && !dv.IsNil() {
+		dv = dv.Elem()
+	}
+	if rv.Kind() == reflect.Map && !rv.IsNil() && dv.Type().AssignableTo(rv.Type()) {
+		for it := dv.MapRange(); it.Next(); {
+			rv.SetMapIndex(it.Key(), it.Value())
+		}
+	}
+}
+
+// execStmt 执行写语句，执行前检查mock
+func (t *ZormTable) execStmt(query string, args []interface{}) (sql.Result, error) {
+	if err := t.checkStmtMock(query, args); err != nil {
+		return nil, err
+	}
+	return t.DB.ExecContext(t.ctx, query, args...)
+}
+
+// mockResult 将命中的mock转换为返回值
+func mockResult(n int, err error) (int, error) {
+	if hit, ok := err.(*mockHit); ok {
+		return hit.n, hit.err
+	}
+	return n, err
+}
+
+func mockEnabled() bool {
+	return atomic.LoadInt32(&config.Mock) > 0
+}
+
+func checkMock(ctx context.Context, call *MockCall, caller, file, pkg string) (mocked bool, data interface{}, ret int, err error) {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+
+	// 绑定了 MockScope 的上下文只匹配该 scope 中的mock
+	mockData, mockErrors := &_mockData, &_mockErrors
+	if ctx != nil {
+		if scope, ok := ctx.Value(mockScopeKey{}).(*MockScope); ok {
+			mockData, mockErrors = &scope.mocks, &scope.errs
+		}
+	}
+
+	for i := 0; i < len(*mockData); i++ {
+		data := (*mockData)[i]
+		if !data.matches(call, caller, file, pkg) {
+			continue
+		}
+		if data.ordered {
+			for _, prev := range (*mockData)[:i] {
+				if prev.ordered && prev.times > 0 {
+					err := fmt.Errorf("mock %s called out of order, expecting %s first", data, prev)
+					*mockErrors = append(*mockErrors, err)
+					return true, nil, 0, err
+				}
+			}
+		}
+		data.calls = append(data.calls, call)
+		if data.times == 0 {
+			err := fmt.Errorf("mock %s should not be called", data)
+			*mockErrors = append(*mockErrors, err)
+			return true, nil, 0, err
+		}
+		if data.times > 0 {
+			data.times--
+			if data.times == 0 {
+				*mockData = append((*mockData)[0:i], (*mockData)[i+1:]...)
+			}
+		}
+		return true, data.Data, data.Ret, data.Err
+	}
 	return false, nil, 0, nil
 }
 
@@ -3313,17 +4330,23 @@ func checkInTestFile(fileName string) {
 	}
 }
 
-// ZormMock .
-func ZormMock(tbl, fun, caller, file, pkg string, data interface{}, ret int, err error) {
+// ZormMock 返回的 MockMatcher 可以继续设置SQL、参数、次数和顺序
+func ZormMock(tbl, fun, caller, file, pkg string, data interface{}, ret int, err error) *MockMatcher {
 	_, fileName, _, _ := runtime.Caller(1)
 	checkInTestFile(fileName)
 
-	config.Mock = true
-
 	_mutex.Lock()
 	defer _mutex.Unlock()
 
-	_mockData = append(_mockData, &MockMatcher{
+	if !_globalMock {
+		_globalMock = true
+		atomic.AddInt32(&config.Mock, 1)
+	}
+	return addMock(&_mockData, tbl, fun, caller, file, pkg, data, ret, err)
+}
+
+func addMock(mockData *[]*MockMatcher, tbl, fun, caller, file, pkg string, data interface{}, ret int, err error) *MockMatcher {
+	m := &MockMatcher{
 		Tbl:    tbl,
 		Func:   fun,
 		Caller: caller,
@@ -3332,22 +4355,100 @@ func ZormMock(tbl, fun, caller, file, pkg string, data interface{}, ret int, err
 		Data:   data,
 		Ret:    ret,
 		Err:    err,
-	})
+		times:  1,
+	}
+	*mockData = append(*mockData, m)
+	return m
 }
 
-// ZormMockFinish .
+// ZormMockFinish 检查全局mock是否全部命中，并关闭全局mock
 func ZormMockFinish() error {
 	_mutex.Lock()
 	defer _mutex.Unlock()
 
-	mockData := _mockData
-	_mockData = make([]*MockMatcher, 0)
-	if len(mockData) > 0 {
-		return fmt.Errorf("Some of the mock data left behind: %+v", mockData)
+	if _globalMock {
+		_globalMock = false
+		atomic.AddInt32(&config.Mock, -1)
+	}
+	return finishMocks(&_mockData, &_mockErrors)
+}
+
+func finishMocks(mockData *[]*MockMatcher, mockErrors *[]error) error {
+	data, errs := *mockData, *mockErrors
+	*mockData, *mockErrors = make([]*MockMatcher, 0), nil
+
+	var left []*MockMatcher
+	for _, m := range data {
+		if m.times > 0 {
+			left = append(left, m)
+		}
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("Some of the mock data left behind: %+v", left)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
 	}
 	return nil
 }
 
+// TestingT MockScope 需要的测试接口，testing.TB 满足该接口
+type TestingT interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...interface{})
+}
+
+type mockScopeKey struct{}
+
+// MockScope 单个测试内的mock，只对绑定了该 scope 的上下文或表生效，互不干扰，可用于 t.Parallel()
+type MockScope struct {
+	t     TestingT
+	mocks []*MockMatcher
+	errs  []error
+}
+
+// NewMockScope 创建mock scope，测试结束时自动检查是否全部命中，所有 scope 结束后关闭mock
+func NewMockScope(t TestingT) *MockScope {
+	t.Helper()
+	s := &MockScope{t: t}
+	atomic.AddInt32(&config.Mock, 1)
+	t.Cleanup(func() {
+		defer atomic.AddInt32(&config.Mock, -1)
+		if err := s.Finish(); err != nil {
+			t.Errorf("zorm mock: %v", err)
+		}
+	})
+	return s
+}
+
+// Mock 同 ZormMock，但只对绑定了该 scope 的调用生效
+func (s *MockScope) Mock(tbl, fun, caller, file, pkg string, data interface{}, ret int, err error) *MockMatcher {
+	_, fileName, _, _ := runtime.Caller(1)
+	checkInTestFile(fileName)
+
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	return addMock(&s.mocks, tbl, fun, caller, file, pkg, data, ret, err)
+}
+
+// Context 返回绑定该 scope 的上下文，通过 TableContext 使用
+func (s *MockScope) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mockScopeKey{}, s)
+}
+
+// Table 创建绑定该 scope 的表
+func (s *MockScope) Table(db ZormDBIFace, name string) *ZormTable {
+	return TableContext(s.Context(context.Background()), db, name)
+}
+
+// Finish 检查该 scope 的mock是否全部命中并清空，测试结束时会自动调用
+func (s *MockScope) Finish() error {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+	return finishMocks(&s.mocks, &s.errs)
+}
+
 // 优化后的缓存操作函数
 func buildCacheKey(file string, line int) string {
 	builder := _cacheKeyPool.Get().(*strings.Builder)
@@ -3446,10 +4547,14 @@ func Begin(db ZormDBIFace) (ZormTxIFace, error) {
 
 // BeginContext 带上下文开始事务
 func BeginContext(ctx context.Context, db ZormDBIFace) (ZormTxIFace, error) {
+	return beginTx(ctx, db, nil)
+}
+
+func beginTx(ctx context.Context, db ZormDBIFace, opts *sql.TxOptions) (*ZormTx, error) {
 	if txDB, ok := db.(interface {
 		BeginTx(context.Context, *sql.TxOptions) (*sql.Tx, error)
 	}); ok {
-		tx, err := txDB.BeginTx(ctx, nil)
+		tx, err := txDB.BeginTx(ctx, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -3458,9 +4563,146 @@ func BeginContext(ctx context.Context, db ZormDBIFace) (ZormTxIFace, error) {
 	return nil, errors.New("database does not support transactions")
 }
 
+// TxConfig Tx 的可选配置
+type TxConfig struct {
+	Options *sql.TxOptions // 隔离级别、只读等，嵌套事务时忽略
+	Retry   *RetryPolicy   // 为nil时不重试，嵌套事务时忽略
+}
+
+// RetryPolicy 事务重试策略，重试时会重新执行整个 fn
+type RetryPolicy struct {
+	MaxAttempts int              // 最大尝试次数（包含第一次）
+	Backoff     time.Duration    // 第一次重试前的等待时间，之后每次翻倍
+	Retryable   func(error) bool // 判断错误是否可重试，为nil时使用 IsRetryableError
+}
+
+// DefaultRetryPolicy 默认重试策略
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     10 * time.Millisecond,
+	}
+}
+
+// IsRetryableError 判断是否为可重试的并发冲突错误
+// 包括 SQLITE_BUSY/SQLITE_LOCKED、PostgreSQL 序列化失败（40001）和死锁、MySQL 死锁/锁等待超时
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"database is locked",
+		"database table is locked",
+		"sqlite_busy",
+		"sqlite_locked",
+		"could not serialize access",
+		"40001",
+		"deadlock",
+		"lock wait timeout",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tx 在事务中执行 fn：fn 返回nil时提交，返回错误或 panic 时回滚（panic 会继续抛出）
+// db 本身是 Tx 创建的事务时，使用 SAVEPOINT 实现嵌套，内层失败只回滚到保存点
+// fn 中不要调用 Commit/Rollback
+func Tx(ctx context.Context, db ZormDBIFace, fn func(tx ZormTxIFace) error, cfg ...*TxConfig) error {
+	if parent, ok := db.(*ZormTx); ok {
+		return parent.savepoint(ctx, fn)
+	}
+
+	var c TxConfig
+	if len(cfg) > 0 && cfg[0] != nil {
+		c = *cfg[0]
+	}
+	if c.Retry == nil || c.Retry.MaxAttempts <= 1 {
+		return runTx(ctx, db, c.Options, fn)
+	}
+
+	retryable := c.Retry.Retryable
+	if retryable == nil {
+		retryable = IsRetryableError
+	}
+	backoff := c.Retry.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runTx(ctx, db, c.Options, fn)
+		if err == nil || attempt >= c.Retry.MaxAttempts || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func runTx(ctx context.Context, db ZormDBIFace, opts *sql.TxOptions, fn func(tx ZormTxIFace) error) (err error) {
+	tx, err := beginTx(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// savepoint 在当前事务中以保存点执行 fn
+func (tx *ZormTx) savepoint(ctx context.Context, fn func(tx ZormTxIFace) error) (err error) {
+	inner := &ZormTx{tx: tx.tx, depth: tx.depth + 1}
+	name := "zorm_sp_" + strconv.Itoa(inner.depth)
+	if _, err = tx.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+
+	rollback := func() error {
+		if _, err := tx.tx.ExecContext(ctx, "ROLLBACK TO "+name); err != nil {
+			return err
+		}
+		_, err := tx.tx.ExecContext(ctx, "RELEASE "+name)
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			rollback()
+			panic(r)
+		}
+	}()
+
+	if err = fn(inner); err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	_, err = tx.tx.ExecContext(ctx, "RELEASE "+name)
+	return err
+}
+
 // ZormTx 事务实现
 type ZormTx struct {
-	tx *sql.Tx
+	tx    *sql.Tx
+	depth int // 嵌套层级，用于生成保存点名称
 }
 
 // QueryRowContext 实现 ZormDBIFace 接口
@@ -3522,13 +4764,6 @@ func DefaultConnectionPool() *ConnectionPool {
 	}
 }
 
-// ReadWriteDB 读写分离数据库
-type ReadWriteDB struct {
-	Master ZormDBIFace   // 主库（写）
-	Slaves []ZormDBIFace // 从库（读）
-	index  int64         // 轮询索引
-}
-
 // DDLConfig DDL configuration
 type DDLConfig struct {
 	SchemaManagement bool // Whether to enable schema management
@@ -3541,44 +4776,6 @@ func DefaultDDLConfig() *DDLConfig {
 	}
 }
 
-// NewReadWriteDB 创建读写分离数据库
-func NewReadWriteDB(master ZormDBIFace, slaves ...ZormDBIFace) *ReadWriteDB {
-	return &ReadWriteDB{
-		Master: master,
-		Slaves: slaves,
-		index:  0,
-	}
-}
-
-// QueryRowContext 实现 ZormDBIFace 接口（读操作使用从库）
-func (rw *ReadWriteDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
-	if len(rw.Slaves) == 0 {
-		return rw.Master.QueryRowContext(ctx, query, args...)
-	}
-
-	// 轮询选择从库
-	slave := rw.Slaves[rw.index%int64(len(rw.Slaves))]
-	atomic.AddInt64(&rw.index, 1)
-	return slave.QueryRowContext(ctx, query, args...)
-}
-
-// QueryContext 实现 ZormDBIFace 接口（读操作使用从库）
-func (rw *ReadWriteDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
-	if len(rw.Slaves) == 0 {
-		return rw.Master.QueryContext(ctx, query, args...)
-	}
-
-	// 轮询选择从库
-	slave := rw.Slaves[rw.index%int64(len(rw.Slaves))]
-	atomic.AddInt64(&rw.index, 1)
-	return slave.QueryContext(ctx, query, args...)
-}
-
-// ExecContext 实现 ZormDBIFace 接口（写操作使用主库）
-func (rw *ReadWriteDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
-	return rw.Master.ExecContext(ctx, query, args...)
-}
-
 // CreateTable creates a table from struct definition
 func CreateTable(db ZormDBIFace, tableName string, model interface{}, config *DDLConfig) error {
 	if config == nil {
@@ -3593,8 +4790,23 @@ func CreateTable(db ZormDBIFace, tableName string, model interface{}, config *DD
 	// Debug: print generated SQL (remove in production)
 	// fmt.Printf("Generated SQL: %s\n", sql)
 
-	_, err = db.ExecContext(context.Background(), sql)
-	return err
+	if _, err = db.ExecContext(context.Background(), sql); err != nil {
+		return err
+	}
+
+	// 创建标签中声明的索引
+	indexes, err := getModelIndexes(tableName, model)
+	if err != nil {
+		return err
+	}
+	for _, idx := range indexes {
+		cmd := createIndexCommand(tableName, idx)
+		cmd.IfNotExists = true
+		if err := cmd.Execute(context.Background(), db); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // CreateTables automatically creates table schemas
@@ -3658,13 +4870,13 @@ func generateCreateTableSQL(tableName string, model interface{}, config *DDLConf
 	sb.WriteString(tableName)
 	sb.WriteString("` (")
 
-	first := true
+	first, hasAutoIncr := true, false
 	for i := 0; i < s.NumField(); i++ {
 		f := s.Field(i)
 		ft := f.Tag().Get("zorm")
 
 		// Skip fields with zorm tag "-"
-		if ft == "-" {
+		if isIgnoredTag(ft) {
 			continue
 		}
 
@@ -3674,20 +4886,18 @@ func generateCreateTableSQL(tableName string, model interface{}, config *DDLConf
 		first = false
 
 		// Field name
-		fieldName := f.Name()
-		if ft != "" {
-			fieldName = strings.Split(ft, ",")[0]
-		}
+		fieldName := getFieldName(f)
 		sb.WriteString("\n  `")
 		sb.WriteString(fieldName)
 		sb.WriteString("` ")
 
 		// Auto-increment primary key needs special handling
 		if isAutoIncrementField(f) {
+			hasAutoIncr = true
 			sb.WriteString("INTEGER PRIMARY KEY AUTOINCREMENT")
 		} else {
 			// Field type
-			fieldType := getSQLType(f.Type())
+			fieldType := getColumnType(f)
 			sb.WriteString(fieldType)
 		}
 
@@ -3705,32 +4915,24 @@ func generateCreateTableSQL(tableName string, model interface{}, config *DDLConf
 		}
 	}
 
-	sb.WriteString("\n)")
-
-	return sb.String(), nil
-}
-
-// getTableName extracts table name from struct type
-func getTableName(model interface{}) string {
-	rt := reflect2.TypeOf(model)
-	if rt.Kind() == reflect.Ptr {
-		rt = rt.(reflect2.PtrType).Elem()
+	// Primary key and foreign key constraints from pk and fk tags
+	primaryKey, foreignKeys, err := getModelConstraints(model)
+	if err != nil {
+		return "", err
 	}
-
-	// Try to get table name from struct name
-	name := rt.String()
-	if name == "" {
-		return "table"
+	if len(primaryKey) > 0 && !hasAutoIncr {
+		sb.WriteString(",\n  PRIMARY KEY (`")
+		sb.WriteString(strings.Join(primaryKey, "`, `"))
+		sb.WriteString("`)")
 	}
-
-	// Extract struct name from full type name
-	parts := strings.Split(name, ".")
-	if len(parts) > 0 {
-		name = parts[len(parts)-1]
+	for _, fk := range foreignKeys {
+		sb.WriteString(",\n  ")
+		sb.WriteString(fk.SQL())
 	}
 
-	// Simple pluralization (can be extended)
-	return strings.ToLower(name) + "s"
+	sb.WriteString("\n)")
+
+	return sb.String(), nil
 }
 
 // createTableFromModel automatically creates a single table from model
@@ -3752,10 +4954,22 @@ func createTableFromModel(db ZormDBIFace, tableName string, model interface{}) e
 	return nil
 }
 
+// getColumnType gets the SQL type of a field from its type: option, or from its Go type
+func getColumnType(f reflect2.StructField) string {
+	for _, tag := range tagOptions(f.Tag().Get("zorm")) {
+		if strings.HasPrefix(tag, "type:") {
+			return strings.TrimSpace(strings.TrimPrefix(tag, "type:"))
+		}
+	}
+	return getSQLType(f.Type())
+}
+
 // getSQLType maps Go types to SQL types
 // Optimized for SQLite, compatible with MySQL/PostgreSQL
 func getSQLType(rt reflect2.Type) string {
 	switch rt.Kind() {
+	case reflect.Ptr:
+		return getSQLType(rt.(reflect2.PtrType).Elem())
 	case reflect.Bool:
 		return "BOOLEAN"
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
@@ -3792,9 +5006,7 @@ func isNullable(f reflect2.StructField) bool {
 		return true
 	}
 
-	tags := strings.Split(ft, ",")
-	for _, tag := range tags {
-		tag = strings.TrimSpace(tag)
+	for _, tag := range tagOptions(ft) {
 		if tag == "not_null" || tag == "notnull" {
 			return false
 		}
@@ -3809,6 +5021,16 @@ func isNullable(f reflect2.StructField) bool {
 	}
 }
 
+// hasDefaultTag reports whether a field declares its default with a default: tag
+func hasDefaultTag(f reflect2.StructField) bool {
+	for _, tag := range tagOptions(f.Tag().Get("zorm")) {
+		if strings.HasPrefix(tag, "default:") {
+			return true
+		}
+	}
+	return false
+}
+
 // getDefaultValue gets the default value for a field
 func getDefaultValue(f reflect2.StructField) string {
 	ft := f.Tag().Get("zorm")
@@ -3816,9 +5038,7 @@ func getDefaultValue(f reflect2.StructField) string {
 		return ""
 	}
 
-	tags := strings.Split(ft, ",")
-	for _, tag := range tags {
-		tag = strings.TrimSpace(tag)
+	for _, tag := range tagOptions(ft) {
 		if strings.HasPrefix(tag, "default:") {
 			return strings.TrimPrefix(tag, "default:")
 		}
@@ -3841,3 +5061,28 @@ func getDefaultValue(f reflect2.StructField) string {
 		return ""
 	}
 }
+
+// tagOptions returns the trimmed options of a zorm tag after the column name. The comment:
+// option comes last and may contain commas, so the options end there.
+func tagOptions(ft string) []string {
+	parts := strings.Split(ft, ",")
+	opts := make([]string, 0, len(parts)-1)
+	for _, opt := range parts[1:] {
+		opt = strings.TrimSpace(opt)
+		if strings.HasPrefix(opt, "comment:") {
+			break
+		}
+		opts = append(opts, opt)
+	}
+	return opts
+}
+
+// getComment gets the column comment of a field from its comment: option,
+// which must come last and may contain commas
+func getComment(f reflect2.StructField) string {
+	ft := f.Tag().Get("zorm")
+	if i := strings.Index(ft, "comment:"); i >= 0 && (i == 0 || ft[i-1] == ',' || ft[i-1] == ' ') {
+		return strings.TrimSpace(ft[i+len("comment:"):])
+	}
+	return ""
+}
@@ -1,14 +1,17 @@
 package zorm_test
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/IceWhaleTech/zorm"
@@ -10175,3 +10178,2910 @@ func TestInsertWithAutoIncrementIDInNonPointerSlice(t *testing.T) {
 		}
 	})
 }
+
+// ========== Compound Select ==========
+func TestSelectWithCompound(t *testing.T) {
+	Convey("Select with union/intersect/except", t, func() {
+		setupTestTables(t)
+		db.Exec(`CREATE TABLE IF NOT EXISTS test_users_archive (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			email TEXT,
+			age INTEGER,
+			created_at DATETIME
+		)`)
+		db.Exec("DELETE FROM test_users_archive")
+
+		tbl := zorm.Table(db, "test_users")
+		archive := zorm.Table(db, "test_users_archive")
+		tbl.Insert(&[]User{
+			{Name: "Live 1", Email: "live1@example.com", Age: 20, CreatedAt: time.Now()},
+			{Name: "Live 2", Email: "live2@example.com", Age: 30, CreatedAt: time.Now()},
+		})
+		archive.Insert(&[]User{
+			{Name: "Archived 1", Email: "archived1@example.com", Age: 40, CreatedAt: time.Now()},
+			{Name: "Live 2", Email: "live2@example.com", Age: 30, CreatedAt: time.Now()},
+		})
+
+		Convey("UnionAll into structs with OrderBy and Limit on the whole result", func() {
+			var users []User
+			n, err := tbl.Select(&users,
+				zorm.Fields("name", "email", "age"),
+				zorm.OrderBy("age desc"),
+				zorm.Limit(3),
+				zorm.UnionAll(zorm.Query("test_users_archive", zorm.Gt("age", 0))))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(users[0].Name, ShouldEqual, "Archived 1")
+			So(users[1].Age, ShouldEqual, 30)
+			So(users[2].Age, ShouldEqual, 30)
+		})
+
+		Convey("Union removes duplicates", func() {
+			var names []string
+			n, err := tbl.Select(&names, zorm.Fields("name"),
+				zorm.Union(zorm.Query("test_users_archive")),
+				zorm.OrderBy("name"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(names, ShouldResemble, []string{"Archived 1", "Live 1", "Live 2"})
+		})
+
+		Convey("Intersect and Except into basic slices", func() {
+			var names []string
+			n, err := tbl.Select(&names, zorm.Fields("name"), zorm.Intersect(zorm.Query("test_users_archive")))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(names, ShouldResemble, []string{"Live 2"})
+
+			names = nil
+			n, err = tbl.Select(&names, zorm.Fields("name"), zorm.Except(zorm.Query("test_users_archive")))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(names, ShouldResemble, []string{"Live 1"})
+		})
+
+		Convey("Union into maps with args in every part", func() {
+			var ms []map[string]interface{}
+			n, err := tbl.Select(&ms, zorm.Fields("name", "age"),
+				zorm.Where(zorm.Eq("age", 20)),
+				zorm.UnionAll(
+					zorm.Query("test_users_archive", zorm.Fields("name", "age"), zorm.Where(zorm.Eq("age", 40))),
+				),
+				zorm.OrderBy("age"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			So(ms[0]["name"], ShouldEqual, "Live 1")
+			So(ms[1]["name"], ShouldEqual, "Archived 1")
+		})
+
+		Convey("Reuse keeps args order for repeated calls", func() {
+			for _, age := range []int{20, 30} {
+				var users []User
+				n, err := tbl.Select(&users,
+					zorm.Where(zorm.Eq("age", age)),
+					zorm.UnionAll(zorm.Query("test_users_archive", zorm.Eq("age", age))),
+					zorm.Limit(10))
+				So(err, ShouldBeNil)
+				if age == 20 {
+					So(n, ShouldEqual, 1)
+				} else {
+					So(n, ShouldEqual, 2)
+				}
+			}
+		})
+	})
+}
+
+// ========== Returning ==========
+func TestReturning(t *testing.T) {
+	Convey("Returning clause", t, func() {
+		setupTestTables(t)
+		tbl := zorm.Table(db, "test_users")
+
+		Convey("Insert struct scans generated id back", func() {
+			user := User{Name: "Ret 1", Email: "ret1@example.com", Age: 21, CreatedAt: time.Now()}
+			n, err := tbl.Insert(&user, zorm.Returning("id"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(user.ID, ShouldBeGreaterThan, 0)
+
+			var result User
+			tbl.Select(&result, zorm.Where(zorm.Eq("id", user.ID)))
+			So(result.Name, ShouldEqual, "Ret 1")
+		})
+
+		Convey("Insert slice fills ids row by row", func() {
+			users := []*User{
+				{Name: "Ret 2", Email: "ret2@example.com", Age: 22, CreatedAt: time.Now()},
+				{Name: "Ret 3", Email: "ret3@example.com", Age: 23, CreatedAt: time.Now()},
+			}
+			n, err := tbl.Insert(&users, zorm.Returning("id", "name"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			So(users[0].ID, ShouldBeGreaterThan, 0)
+			So(users[1].ID, ShouldEqual, users[0].ID+1)
+		})
+
+		Convey("InsertIgnore with conflict returns no rows", func() {
+			db.Exec(`CREATE TABLE IF NOT EXISTS test_returning (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				code TEXT UNIQUE,
+				cnt INTEGER DEFAULT 0
+			)`)
+			db.Exec("DELETE FROM test_returning")
+			rt := zorm.Table(db, "test_returning")
+
+			type item struct {
+				ID   int64  `zorm:"id,auto_incr"`
+				Code string `zorm:"code"`
+				Cnt  int    `zorm:"cnt"`
+			}
+			first := item{Code: "a", Cnt: 1}
+			n, err := rt.InsertIgnore(&first, zorm.Returning("id"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(first.ID, ShouldBeGreaterThan, 0)
+
+			dup := item{Code: "a", Cnt: 2}
+			n, err = rt.InsertIgnore(&dup, zorm.Returning("id"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+			So(dup.ID, ShouldEqual, 0)
+
+			Convey("Upsert returns the updated row", func() {
+				up := item{Code: "a", Cnt: 5}
+				n, err := rt.Insert(&up, zorm.Returning(),
+					zorm.OnConflictDoUpdateSet([]string{"code"}, []string{"cnt"}))
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 1)
+				So(up.ID, ShouldEqual, first.ID)
+				So(up.Cnt, ShouldEqual, 5)
+			})
+		})
+
+		Convey("Insert map returns into an extra destination", func() {
+			// 传入的map不回填，需要用Into指定目标
+			m := zorm.V{"name": "Ret Map", "email": "retmap@example.com", "age": 30}
+			_, err := tbl.Insert(m, zorm.Returning("id"))
+			So(err, ShouldNotBeNil)
+			So(m, ShouldNotContainKey, "id")
+
+			var ret zorm.V
+			n, err := tbl.Insert(zorm.V{"name": "Ret Map", "email": "retmap2@example.com", "age": 30}, zorm.Returning("id", "name").Into(&ret))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(ret["id"], ShouldNotBeNil)
+			So(ret["name"], ShouldEqual, "Ret Map")
+
+			// 值类型不是interface{}的map按类型转换
+			var typed map[string]string
+			_, err = tbl.Insert(map[string]string{"name": "Ret Str", "email": "retstr@example.com"}, zorm.Returning("id", "name").Into(&typed))
+			So(err, ShouldBeNil)
+			So(typed["id"], ShouldNotBeEmpty)
+			So(typed["name"], ShouldEqual, "Ret Str")
+		})
+
+		Convey("Update scans into the passed struct", func() {
+			user := User{Name: "Ret Upd", Email: "retupd@example.com", Age: 40, CreatedAt: time.Now()}
+			tbl.Insert(&user)
+
+			upd := User{Name: "Ret Upd 2"}
+			n, err := tbl.Update(&upd, zorm.Fields("name"), zorm.Where(zorm.Eq("id", user.ID)), zorm.Returning("id", "email", "age"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(upd.ID, ShouldEqual, user.ID)
+			So(upd.Email, ShouldEqual, "retupd@example.com")
+			So(upd.Age, ShouldEqual, 40)
+		})
+
+		Convey("Update map into extra destination", func() {
+			tbl.Insert(&[]User{
+				{Name: "Ret Bulk", Email: "retbulk1@example.com", Age: 50, CreatedAt: time.Now()},
+				{Name: "Ret Bulk", Email: "retbulk2@example.com", Age: 51, CreatedAt: time.Now()},
+			})
+			var ages []int
+			n, err := tbl.Update(zorm.V{"age": zorm.U("age+1")}, zorm.Where(zorm.Eq("name", "Ret Bulk")),
+				zorm.Returning("age").Into(&ages))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			So(len(ages), ShouldEqual, 2)
+			So(ages[0]+ages[1], ShouldEqual, 103)
+		})
+
+		Convey("Update map leaves the passed map untouched", func() {
+			user := User{Name: "Ret V", Email: "retv@example.com", Age: 70, CreatedAt: time.Now()}
+			tbl.Insert(&user)
+
+			m := zorm.V{"age": zorm.U("age+1")}
+			_, err := tbl.Update(m, zorm.Where(zorm.Eq("id", user.ID)), zorm.Returning("id", "age"))
+			So(err, ShouldNotBeNil)
+			So(m, ShouldResemble, zorm.V{"age": zorm.U("age+1")})
+
+			var ret []map[string]int64
+			n, err := tbl.Update(m, zorm.Where(zorm.Eq("id", user.ID)), zorm.Returning("id", "age").Into(&ret))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(ret, ShouldResemble, []map[string]int64{{"id": user.ID, "age": 71}})
+			So(m, ShouldResemble, zorm.V{"age": zorm.U("age+1")})
+		})
+
+		Convey("Delete requires Into", func() {
+			user := User{Name: "Ret Del", Email: "retdel@example.com", Age: 60, CreatedAt: time.Now()}
+			tbl.Insert(&user)
+
+			_, err := tbl.Delete(zorm.Where(zorm.Eq("id", user.ID)), zorm.Returning("id"))
+			So(err, ShouldNotBeNil)
+
+			var deleted []User
+			n, err := tbl.Delete(zorm.Returning().Into(&deleted), zorm.Where(zorm.Eq("id", user.ID)))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(deleted[0].Email, ShouldEqual, "retdel@example.com")
+		})
+	})
+}
+
+// ========== OnConflict ==========
+func TestOnConflictUpsert(t *testing.T) {
+	Convey("OnConflict upsert", t, func() {
+		db.Exec("DROP TABLE IF EXISTS test_counters")
+		db.Exec(`CREATE TABLE test_counters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device TEXT,
+			metric TEXT,
+			cnt INTEGER DEFAULT 0,
+			last_seen INTEGER DEFAULT 0,
+			deleted INTEGER DEFAULT 0
+		)`)
+		db.Exec("CREATE UNIQUE INDEX idx_counters_live ON test_counters(device, metric) WHERE deleted = 0")
+
+		type counter struct {
+			ID       int64  `zorm:"id,auto_incr"`
+			Device   string `zorm:"device"`
+			Metric   string `zorm:"metric"`
+			Cnt      int    `zorm:"cnt"`
+			LastSeen int64  `zorm:"last_seen"`
+		}
+		tbl := zorm.Table(db, "test_counters")
+		upsert := func(c counter) (int, error) {
+			return tbl.Insert(&c, zorm.OnConflict("device", "metric").
+				TargetWhere("deleted = 0").
+				DoUpdate(zorm.V{
+					"cnt":       zorm.U("cnt+excluded.cnt"),
+					"last_seen": zorm.U("excluded.last_seen"),
+				}).
+				Where("last_seen < excluded.last_seen"))
+		}
+
+		n, err := upsert(counter{Device: "d1", Metric: "m", Cnt: 2, LastSeen: 10})
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		_, err = upsert(counter{Device: "d1", Metric: "m", Cnt: 3, LastSeen: 20})
+		So(err, ShouldBeNil)
+
+		var got counter
+		tbl.Select(&got, zorm.Where(zorm.Eq("device", "d1")))
+		So(got.Cnt, ShouldEqual, 5)
+		So(got.LastSeen, ShouldEqual, 20)
+
+		Convey("Where on DO UPDATE keeps newer rows", func() {
+			n, err := upsert(counter{Device: "d1", Metric: "m", Cnt: 100, LastSeen: 5})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			var got counter
+			tbl.Select(&got, zorm.Where(zorm.Eq("device", "d1")))
+			So(got.Cnt, ShouldEqual, 5)
+		})
+
+		Convey("Bound values and conditions with args", func() {
+			_, err := tbl.Insert(&counter{Device: "d1", Metric: "m", Cnt: 1, LastSeen: 30},
+				zorm.OnConflict("device", "metric").TargetWhere("deleted = 0").
+					DoUpdate(zorm.V{"cnt": 42}).
+					Where(zorm.Lt("last_seen", 100)))
+			So(err, ShouldBeNil)
+
+			var got counter
+			tbl.Select(&got, zorm.Where(zorm.Eq("device", "d1")))
+			So(got.Cnt, ShouldEqual, 42)
+		})
+
+		Convey("OnConflictDoNothing", func() {
+			n, err := tbl.Insert(&counter{Device: "d1", Metric: "m", Cnt: 9},
+				zorm.OnConflictDoNothing("device", "metric").TargetWhere("deleted = 0"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			n, err = tbl.Insert(&counter{Device: "d1", Metric: "m", Cnt: 9}, zorm.OnConflictDoNothing())
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			var cnt int64
+			tbl.Select(&cnt, zorm.Fields("count(1)"))
+			So(cnt, ShouldEqual, 1)
+		})
+	})
+}
+
+// ========== Tx helper ==========
+func TestTxHelper(t *testing.T) {
+	Convey("Tx helper", t, func() {
+		setupTestTables(t)
+		ctx := context.Background()
+		count := func(name string) int64 {
+			var cnt int64
+			zorm.Table(db, "test_users").Select(&cnt, zorm.Fields("count(1)"), zorm.Where(zorm.Eq("name", name)))
+			return cnt
+		}
+
+		Convey("Commits on success", func() {
+			err := zorm.Tx(ctx, db, func(tx zorm.ZormTxIFace) error {
+				_, err := zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Commit", CreatedAt: time.Now()})
+				return err
+			})
+			So(err, ShouldBeNil)
+			So(count("Tx Commit"), ShouldEqual, 1)
+		})
+
+		Convey("Rolls back on error", func() {
+			boom := errors.New("boom")
+			err := zorm.Tx(ctx, db, func(tx zorm.ZormTxIFace) error {
+				zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Error", CreatedAt: time.Now()})
+				return boom
+			})
+			So(errors.Is(err, boom), ShouldBeTrue)
+			So(count("Tx Error"), ShouldEqual, 0)
+		})
+
+		Convey("Rolls back on panic and re-panics", func() {
+			So(func() {
+				zorm.Tx(ctx, db, func(tx zorm.ZormTxIFace) error {
+					zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Panic", CreatedAt: time.Now()})
+					panic("oops")
+				})
+			}, ShouldPanicWith, "oops")
+			So(count("Tx Panic"), ShouldEqual, 0)
+		})
+
+		Convey("Nested calls use savepoints", func() {
+			err := zorm.Tx(ctx, db, func(tx zorm.ZormTxIFace) error {
+				tbl := zorm.Table(tx, "test_users")
+				tbl.Insert(&User{Name: "Tx Outer", CreatedAt: time.Now()})
+
+				innerErr := zorm.Tx(ctx, tx, func(tx zorm.ZormTxIFace) error {
+					zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Inner Failed", CreatedAt: time.Now()})
+					return errors.New("inner")
+				})
+				So(innerErr, ShouldNotBeNil)
+
+				return zorm.Tx(ctx, tx, func(tx zorm.ZormTxIFace) error {
+					_, err := zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Inner Ok", CreatedAt: time.Now()})
+					if err != nil {
+						return err
+					}
+					// 第二层嵌套
+					return zorm.Tx(ctx, tx, func(tx zorm.ZormTxIFace) error {
+						_, err := zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Inner Ok", CreatedAt: time.Now()})
+						return err
+					})
+				})
+			})
+			So(err, ShouldBeNil)
+			So(count("Tx Outer"), ShouldEqual, 1)
+			So(count("Tx Inner Failed"), ShouldEqual, 0)
+			So(count("Tx Inner Ok"), ShouldEqual, 2)
+		})
+
+		Convey("Retries retryable errors", func() {
+			attempts := 0
+			err := zorm.Tx(ctx, db, func(tx zorm.ZormTxIFace) error {
+				attempts++
+				if attempts < 3 {
+					return errors.New("database is locked")
+				}
+				_, err := zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Retry", CreatedAt: time.Now()})
+				return err
+			}, &zorm.TxConfig{Retry: &zorm.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}})
+			So(err, ShouldBeNil)
+			So(attempts, ShouldEqual, 3)
+			So(count("Tx Retry"), ShouldEqual, 1)
+
+			attempts = 0
+			err = zorm.Tx(ctx, db, func(tx zorm.ZormTxIFace) error {
+				attempts++
+				return errors.New("not retryable")
+			}, &zorm.TxConfig{Retry: zorm.DefaultRetryPolicy()})
+			So(err, ShouldNotBeNil)
+			So(attempts, ShouldEqual, 1)
+		})
+
+		Convey("Accepts sql.TxOptions", func() {
+			err := zorm.Tx(ctx, db, func(tx zorm.ZormTxIFace) error {
+				_, err := zorm.Table(tx, "test_users").Insert(&User{Name: "Tx Opts", CreatedAt: time.Now()})
+				return err
+			}, &zorm.TxConfig{Options: &sql.TxOptions{Isolation: sql.LevelSerializable}})
+			So(err, ShouldBeNil)
+			So(count("Tx Opts"), ShouldEqual, 1)
+		})
+
+		Convey("IsRetryableError", func() {
+			So(zorm.IsRetryableError(nil), ShouldBeFalse)
+			So(zorm.IsRetryableError(errors.New("database is locked")), ShouldBeTrue)
+			So(zorm.IsRetryableError(errors.New("pq: could not serialize access due to concurrent update")), ShouldBeTrue)
+			So(zorm.IsRetryableError(errors.New("no such table")), ShouldBeFalse)
+		})
+	})
+}
+
+// ========== WithTx / WithContext ==========
+func TestTableWithTxAndContext(t *testing.T) {
+	Convey("WithTx and WithContext", t, func() {
+		setupTestTables(t)
+		tbl := zorm.Table(db, "test_users")
+
+		Convey("WithContext does not change the original table", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			var users []User
+			_, err := tbl.WithContext(ctx).Select(&users)
+			So(err, ShouldNotBeNil)
+
+			_, err = tbl.Select(&users)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("WithTx binds the copy to the transaction", func() {
+			err := zorm.Tx(context.Background(), db, func(tx zorm.ZormTxIFace) error {
+				n, err := tbl.WithTx(tx).Insert(&User{Name: "WithTx", CreatedAt: time.Now()})
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 1)
+				return errors.New("rollback")
+			})
+			So(err, ShouldNotBeNil)
+
+			var cnt int64
+			tbl.Select(&cnt, zorm.Fields("count(1)"), zorm.Where(zorm.Eq("name", "WithTx")))
+			So(cnt, ShouldEqual, 0)
+			So(tbl.DB, ShouldEqual, db)
+		})
+
+		Convey("Options on the copy do not leak", func() {
+			c := tbl.WithContext(context.Background()).Debug().NoReuse()
+			So(c.Cfg.Debug, ShouldBeTrue)
+			So(tbl.Cfg.Debug, ShouldBeFalse)
+			So(tbl.Cfg.Reuse, ShouldBeTrue)
+			So(c.Name, ShouldEqual, tbl.Name)
+		})
+
+		Convey("Tables built without constructor still work", func() {
+			lit := &zorm.ZormTable{DB: db, Name: "test_users"}
+			n, err := lit.WithContext(context.Background()).Update(&User{Name: "Lit"}, zorm.Fields("name"), zorm.Where("1=0"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+	})
+}
+
+// ========== ReadWriteDB Health Check Tests ==========
+
+// flakyDB 可切换可用状态的从库，不可用时转发到已关闭的连接
+type flakyDB struct {
+	mu     sync.Mutex
+	live   *sql.DB
+	closed *sql.DB
+	down   bool
+}
+
+func (f *flakyDB) target() *sql.DB {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return f.closed
+	}
+	return f.live
+}
+
+func (f *flakyDB) setDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+func (f *flakyDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return f.target().QueryRowContext(ctx, query, args...)
+}
+
+func (f *flakyDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return f.target().QueryContext(ctx, query, args...)
+}
+
+func (f *flakyDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return f.target().ExecContext(ctx, query, args...)
+}
+
+func (f *flakyDB) PingContext(ctx context.Context) error {
+	return f.target().PingContext(ctx)
+}
+
+func openReplicaDB(t *testing.T, file, name string) *sql.DB {
+	os.Remove(file)
+	d, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.Close()
+		os.Remove(file)
+	})
+	if _, err := d.Exec("CREATE TABLE node (name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Exec("INSERT INTO node VALUES (?)", name); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func newFlakyDB(t *testing.T, file, name string) *flakyDB {
+	closed, _ := sql.Open("sqlite3", ":memory:")
+	closed.Close()
+	return &flakyDB{live: openReplicaDB(t, file, name), closed: closed}
+}
+
+func TestReadWriteDBHealthCheck(t *testing.T) {
+	Convey("ReadWriteDB health checks and failover", t, func() {
+		ctx := context.Background()
+		master := openReplicaDB(t, "test_rw_master.db", "master")
+		queryNode := func(rw *zorm.ReadWriteDB) string {
+			var n string
+			tbl := zorm.Table(rw, "node")
+			_, err := tbl.Select(&n, zorm.Fields("name"))
+			So(err, ShouldBeNil)
+			return n
+		}
+
+		Convey("weighted selection", func() {
+			r1 := openReplicaDB(t, "test_rw_r1.db", "r1")
+			r2 := openReplicaDB(t, "test_rw_r2.db", "r2")
+			rw := zorm.NewWeightedReadWriteDB(master,
+				zorm.Replica{DB: r1, Weight: 3},
+				zorm.Replica{DB: r2, Weight: 1},
+			)
+			So(len(rw.Slaves), ShouldEqual, 2)
+
+			counts := map[string]int{}
+			for i := 0; i < 8; i++ {
+				counts[queryNode(rw)]++
+			}
+			So(counts["r1"], ShouldEqual, 6)
+			So(counts["r2"], ShouldEqual, 2)
+			So(counts["master"], ShouldEqual, 0)
+
+			stats := rw.Stats()
+			So(stats[0].Weight, ShouldEqual, 3)
+			So(stats[0].Queries, ShouldEqual, 6)
+			So(stats[1].Queries, ShouldEqual, 2)
+
+			// 写操作始终走主库
+			_, err := rw.ExecContext(ctx, "INSERT INTO node VALUES ('written')")
+			So(err, ShouldBeNil)
+			var cnt int
+			So(master.QueryRow("SELECT COUNT(*) FROM node").Scan(&cnt), ShouldBeNil)
+			So(cnt, ShouldEqual, 2)
+		})
+
+		Convey("dead replica is ejected and reads fall back", func() {
+			good := openReplicaDB(t, "test_rw_good.db", "good")
+			bad := newFlakyDB(t, "test_rw_bad.db", "bad")
+			bad.setDown(true)
+			rw := zorm.NewReadWriteDB(master, good, bad)
+			rw.SetHealthCheckConfig(&zorm.HealthCheckConfig{
+				Timeout:     time.Second,
+				MaxFailures: 2,
+				Backoff:     time.Hour,
+				MaxBackoff:  time.Hour,
+			})
+
+			// 失败的读请求回退到主库，不会返回错误
+			for i := 0; i < 10; i++ {
+				So(queryNode(rw), ShouldNotEqual, "bad")
+			}
+			stats := rw.Stats()
+			So(stats[0].Healthy, ShouldBeTrue)
+			So(stats[1].Healthy, ShouldBeFalse)
+			So(stats[1].ConsecutiveFailures, ShouldEqual, 2)
+			So(stats[1].LastError, ShouldContainSubstring, "closed")
+			So(stats[1].NextRetry.After(time.Now()), ShouldBeTrue)
+
+			// 摘除后不再分配读请求
+			before := stats[1].Queries
+			for i := 0; i < 5; i++ {
+				So(queryNode(rw), ShouldEqual, "good")
+			}
+			So(rw.Stats()[1].Queries, ShouldEqual, before)
+
+			// 退避期内即使恢复也不探测
+			bad.setDown(false)
+			rw.CheckHealth(ctx)
+			So(rw.Stats()[1].Healthy, ShouldBeFalse)
+		})
+
+		Convey("successful reads reset consecutive failures", func() {
+			bad := newFlakyDB(t, "test_rw_bad.db", "bad")
+			rw := zorm.NewReadWriteDB(master, bad)
+			rw.SetHealthCheckConfig(&zorm.HealthCheckConfig{MaxFailures: 2, Backoff: time.Hour})
+
+			// 间断的失败不会累计到摘除阈值
+			for i := 0; i < 3; i++ {
+				bad.setDown(true)
+				So(queryNode(rw), ShouldEqual, "master")
+				bad.setDown(false)
+				So(queryNode(rw), ShouldEqual, "bad")
+				So(rw.Stats()[0].ConsecutiveFailures, ShouldEqual, 0)
+			}
+			st := rw.Stats()[0]
+			So(st.Healthy, ShouldBeTrue)
+			So(st.Failures, ShouldEqual, 3)
+		})
+
+		Convey("health check without interval uses the default", func() {
+			r1 := openReplicaDB(t, "test_rw_r1.db", "r1")
+			rw := zorm.NewReadWriteDB(master, r1)
+			So(func() { rw.StartHealthCheck(&zorm.HealthCheckConfig{MaxFailures: 1}) }, ShouldNotPanic)
+			rw.StopHealthCheck()
+		})
+
+		Convey("no healthy replica falls back to master", func() {
+			bad := newFlakyDB(t, "test_rw_bad.db", "bad")
+			bad.setDown(true)
+			rw := zorm.NewReadWriteDB(master, bad)
+			rw.SetHealthCheckConfig(&zorm.HealthCheckConfig{MaxFailures: 1, Backoff: time.Hour})
+
+			rw.CheckHealth(ctx)
+			So(rw.Stats()[0].Healthy, ShouldBeFalse)
+			So(queryNode(rw), ShouldEqual, "master")
+
+			var n string
+			So(rw.QueryRowContext(ctx, "SELECT name FROM node").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, "master")
+		})
+
+		Convey("background check restores replica after backoff", func() {
+			bad := newFlakyDB(t, "test_rw_bad.db", "bad")
+			bad.setDown(true)
+			rw := zorm.NewReadWriteDB(master, bad)
+			rw.StartHealthCheck(&zorm.HealthCheckConfig{
+				Interval:    5 * time.Millisecond,
+				Timeout:     time.Second,
+				MaxFailures: 1,
+				Backoff:     10 * time.Millisecond,
+				MaxBackoff:  20 * time.Millisecond,
+			})
+			defer rw.StopHealthCheck()
+
+			waitFor := func(healthy bool) bool {
+				deadline := time.Now().Add(2 * time.Second)
+				for time.Now().Before(deadline) {
+					if rw.Stats()[0].Healthy == healthy {
+						return true
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+				return false
+			}
+			So(waitFor(false), ShouldBeTrue)
+			So(queryNode(rw), ShouldEqual, "master")
+
+			bad.setDown(false)
+			So(waitFor(true), ShouldBeTrue)
+			st := rw.Stats()[0]
+			So(st.ConsecutiveFailures, ShouldEqual, 0)
+			So(st.Failures, ShouldBeGreaterThan, 0)
+			So(st.LastCheck.IsZero(), ShouldBeFalse)
+			So(queryNode(rw), ShouldEqual, "bad")
+		})
+
+		Convey("ejected replica is retried by reads after backoff", func() {
+			bad := newFlakyDB(t, "test_rw_bad.db", "bad")
+			bad.setDown(true)
+			rw := zorm.NewReadWriteDB(master, bad)
+			rw.SetHealthCheckConfig(&zorm.HealthCheckConfig{MaxFailures: 1, Backoff: 20 * time.Millisecond})
+
+			So(queryNode(rw), ShouldEqual, "master")
+			So(rw.Stats()[0].Healthy, ShouldBeFalse)
+			So(queryNode(rw), ShouldEqual, "master")
+
+			// 不启动后台检查，退避结束后的读请求试用从库，成功后恢复
+			bad.setDown(false)
+			time.Sleep(50 * time.Millisecond)
+			So(queryNode(rw), ShouldEqual, "bad")
+			So(rw.Stats()[0].Healthy, ShouldBeTrue)
+			So(queryNode(rw), ShouldEqual, "bad")
+
+			// 试用失败时读请求回退到主库，继续退避
+			bad.setDown(true)
+			So(queryNode(rw), ShouldEqual, "master")
+			time.Sleep(50 * time.Millisecond)
+			before := rw.Stats()[0]
+			So(queryNode(rw), ShouldEqual, "master")
+			after := rw.Stats()[0]
+			So(after.Healthy, ShouldBeFalse)
+			So(after.Queries, ShouldEqual, before.Queries+1)
+			So(after.ConsecutiveFailures, ShouldEqual, before.ConsecutiveFailures+1)
+			So(after.NextRetry.After(before.NextRetry), ShouldBeTrue)
+		})
+
+		Convey("literal struct keeps working", func() {
+			r1 := openReplicaDB(t, "test_rw_r1.db", "r1")
+			rw := &zorm.ReadWriteDB{Master: master, Slaves: []zorm.ZormDBIFace{r1}}
+			So(queryNode(rw), ShouldEqual, "r1")
+			So(len(rw.Stats()), ShouldEqual, 1)
+
+			// 替换 Slaves 中的从库后读请求走新的从库
+			r2 := openReplicaDB(t, "test_rw_r2.db", "r2")
+			rw.Slaves[0] = r2
+			So(queryNode(rw), ShouldEqual, "r2")
+			So(rw.Stats()[0].Queries, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestReadWriteDBReadYourWrites(t *testing.T) {
+	Convey("ReadWriteDB read-your-writes and transactions", t, func() {
+		master := openReplicaDB(t, "test_rw_master.db", "master")
+		slave := openReplicaDB(t, "test_rw_r1.db", "r1")
+		rw := zorm.NewReadWriteDB(master, slave)
+
+		count := func(ctx context.Context) int64 {
+			var n int64
+			_, err := zorm.TableContext(ctx, rw, "node").Select(&n, zorm.Fields("count(1)"))
+			So(err, ShouldBeNil)
+			return n
+		}
+
+		Convey("UseMaster forces master reads", func() {
+			_, err := master.Exec("INSERT INTO node VALUES ('m2')")
+			So(err, ShouldBeNil)
+			So(count(context.Background()), ShouldEqual, 1)
+			So(count(zorm.UseMaster(context.Background())), ShouldEqual, 2)
+		})
+
+		Convey("writes pin the session to master", func() {
+			ctx := zorm.ReadYourWrites(context.Background())
+			So(count(ctx), ShouldEqual, 1)
+			So(rw.Stats()[0].Queries, ShouldEqual, 1)
+
+			_, err := zorm.TableContext(ctx, rw, "node").Insert(zorm.V{"name": "new"})
+			So(err, ShouldBeNil)
+			So(count(ctx), ShouldEqual, 2)
+			So(count(context.WithValue(ctx, "k", "v")), ShouldEqual, 2)
+			So(rw.Stats()[0].Queries, ShouldEqual, 1)
+
+			// 其他上下文不受影响
+			So(count(context.Background()), ShouldEqual, 1)
+			So(count(zorm.ReadYourWrites(context.Background())), ShouldEqual, 1)
+		})
+
+		Convey("sticky window pins all reads after a write", func() {
+			rw.SetStickyWindow(50 * time.Millisecond)
+			_, err := rw.ExecContext(context.Background(), "INSERT INTO node VALUES ('new')")
+			So(err, ShouldBeNil)
+			So(count(context.Background()), ShouldEqual, 2)
+
+			time.Sleep(60 * time.Millisecond)
+			So(count(context.Background()), ShouldEqual, 1)
+
+			// 失败的写入不计入
+			_, err = rw.ExecContext(context.Background(), "INSERT INTO missing VALUES (1)")
+			So(err, ShouldNotBeNil)
+			So(count(context.Background()), ShouldEqual, 1)
+		})
+
+		Convey("transactions run on master", func() {
+			ctx := zorm.ReadYourWrites(context.Background())
+			tx, err := zorm.BeginContext(ctx, rw)
+			So(err, ShouldBeNil)
+			_, err = zorm.Table(tx, "node").Insert(zorm.V{"name": "tx"})
+			So(err, ShouldBeNil)
+			So(tx.Commit(), ShouldBeNil)
+			So(count(ctx), ShouldEqual, 2)
+
+			err = zorm.Tx(context.Background(), rw, func(tx zorm.ZormTxIFace) error {
+				_, err := zorm.Table(tx, "node").Insert(zorm.V{"name": "tx2"})
+				return err
+			})
+			So(err, ShouldBeNil)
+			So(count(zorm.UseMaster(context.Background())), ShouldEqual, 3)
+			So(count(context.Background()), ShouldEqual, 1)
+
+			tx2, err := zorm.Begin(rw)
+			So(err, ShouldBeNil)
+			So(tx2.Rollback(), ShouldBeNil)
+		})
+	})
+}
+
+// ========== Mock Matcher Tests ==========
+func TestZormMockMatchers(t *testing.T) {
+	Convey("ZormMock with SQL/args matchers, counts and order", t, func() {
+		setupTestTables(t)
+		defer zorm.ZormMockFinish()
+		tbl := zorm.Table(db, "test_users")
+
+		Convey("match on args and capture object", func() {
+			m := zorm.ZormMock("test_users", "Update", "", "", "", nil, 1, nil).
+				WithSQL("update `test_users` set * where `id`=?").
+				WithArgs("Bob", int64(7))
+
+			// 参数不匹配时不命中，走真实数据库
+			n, err := tbl.Update(&User{Name: "x"}, zorm.Fields("name"), zorm.Where(zorm.Eq("id", int64(8))))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+			So(len(m.Calls()), ShouldEqual, 0)
+
+			n, err = tbl.Update(&User{Name: "Bob"}, zorm.Fields("name"), zorm.Where(zorm.Eq("id", int64(7))))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+
+			// 记录完整语句，参数包括 set 的值
+			calls := m.Calls()
+			So(len(calls), ShouldEqual, 1)
+			So(calls[0].Func, ShouldEqual, "Update")
+			So(calls[0].SQL, ShouldEqual, "update `test_users` set `name`=? where `id`=?")
+			So(calls[0].Args, ShouldResemble, []interface{}{"Bob", int64(7)})
+			So(calls[0].Obj.(*User).Name, ShouldEqual, "Bob")
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+		})
+
+		Convey("arg matcher funcs and Exec", func() {
+			zorm.ZormMock("", "Exec", "", "", "", nil, 3, nil).
+				WithSQL("update test_users set age=?*").
+				WithArgs(func(a interface{}) bool { return a.(int) > 18 }, zorm.AnyArg())
+
+			n, err := tbl.Exec("update test_users set age=? where name=?", 30, "x")
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+
+			zorm.ZormMock("", "Exec", "", "", "", nil, 3, nil).
+				WithArgs(zorm.ArgMatcher(func(a interface{}) bool { return a.(int) > 18 }), zorm.AnyArg())
+			n, err = tbl.Exec("update test_users set age=? where name=?", 30, "x")
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+		})
+
+		Convey("custom match func", func() {
+			zorm.ZormMock("test_users", "Insert", "", "", "", nil, 1, nil).
+				Match(func(c *zorm.MockCall) bool { return c.Obj.(*User).Age == 42 })
+			n, err := tbl.Insert(&User{Name: "n", Age: 42})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+		})
+
+		Convey("Times and AnyTimes", func() {
+			zorm.ZormMock("test_users", "Delete", "", "", "", nil, 5, nil).Times(2)
+			for i := 0; i < 2; i++ {
+				n, err := tbl.Delete(zorm.Where(zorm.Eq("id", 1)))
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 5)
+			}
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+
+			zorm.ZormMock("test_users", "Delete", "", "", "", nil, 5, nil).Times(2)
+			tbl.Delete(zorm.Where(zorm.Eq("id", 1)))
+			err := zorm.ZormMockFinish()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Remaining:1")
+
+			zorm.ZormMock("test_users", "Select", "", "", "", nil, 0, nil).AnyTimes()
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+
+			// Times(0) 表示不应被调用
+			zorm.ZormMock("test_users", "Delete", "", "", "", nil, 5, nil).Times(0)
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+			zorm.ZormMock("test_users", "Delete", "", "", "", nil, 5, nil).Times(0)
+			_, err = tbl.Delete(zorm.Where(zorm.Eq("id", 1)))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "should not be called")
+			err = zorm.ZormMockFinish()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "should not be called")
+
+			So(func() { zorm.ZormMock("test_users", "Delete", "", "", "", nil, 5, nil).Times(-1) }, ShouldPanic)
+			zorm.ZormMockFinish()
+		})
+
+		Convey("Select and Insert see the full statement", func() {
+			sel := zorm.ZormMock("test_users", "Select", "", "", "", map[int64]User{1: {Name: "mocked"}}, 1, nil)
+			ins := zorm.ZormMock("test_users", "Insert", "", "", "", nil, 1, nil)
+
+			users := map[int64]User{}
+			n, err := tbl.Select(&users, zorm.KeyBy("id"), zorm.Where(zorm.Gt("age", 18)), zorm.Limit(5))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(users[1].Name, ShouldEqual, "mocked")
+			So(sel.Calls()[0].SQL, ShouldEqual, "select `id`,`name`,`email`,`age`,`created_at` from `test_users` where `age`>? limit ?")
+			So(sel.Calls()[0].Args, ShouldResemble, []interface{}{18, 5})
+
+			_, err = tbl.Insert(&User{Name: "n", Age: 3}, zorm.Fields("name", "age"))
+			So(err, ShouldBeNil)
+			So(ins.Calls()[0].SQL, ShouldStartWith, "insert into `test_users` (`name`,`age`")
+			So(ins.Calls()[0].Args[:2], ShouldResemble, []interface{}{"n", 3})
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+		})
+
+		Convey("InOrder", func() {
+			first := zorm.ZormMock("test_users", "Insert", "", "", "", nil, 1, nil).InOrder()
+			zorm.ZormMock("test_users", "Update", "", "", "", nil, 1, nil).InOrder()
+
+			_, err := tbl.Update(zorm.V{"name": "x"}, zorm.Where(zorm.Eq("id", 1)))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "out of order")
+
+			_, err = tbl.Insert(&User{Name: "a"})
+			So(err, ShouldBeNil)
+			So(len(first.Calls()), ShouldEqual, 1)
+			_, err = tbl.Update(zorm.V{"name": "x"}, zorm.Where(zorm.Eq("id", 1)))
+			So(err, ShouldBeNil)
+
+			err = zorm.ZormMockFinish()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "out of order")
+		})
+
+		Convey("InOrder Select out of order leaves the result untouched", func() {
+			zorm.ZormMock("test_users", "Select", "", "", "", User{Name: "first"}, 1, nil).WithArgs(1).InOrder()
+			zorm.ZormMock("test_users", "Select", "", "", "", []User{{Name: "second"}}, 1, nil).WithArgs(2).InOrder()
+
+			users := []User{{Name: "keep"}}
+			n, err := tbl.Select(&users, zorm.Where(zorm.Eq("id", 2)))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "out of order")
+			So(n, ShouldEqual, 0)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].Name, ShouldEqual, "keep")
+
+			var user User
+			n, err = tbl.Select(&user, zorm.Where(zorm.Eq("id", 1)))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(user.Name, ShouldEqual, "first")
+
+			_, err = tbl.Select(&users, zorm.Where(zorm.Eq("id", 2)))
+			So(err, ShouldBeNil)
+			So(users[0].Name, ShouldEqual, "second")
+			So(zorm.ZormMockFinish(), ShouldNotBeNil)
+		})
+	})
+}
+
+// ========== Mock Scope Tests ==========
+
+// fakeT 记录 Cleanup 和 Errorf，用于检查 MockScope 的自动校验
+type fakeT struct {
+	cleanups []func()
+	errs     []string
+}
+
+func (f *fakeT) Helper()           {}
+func (f *fakeT) Cleanup(fn func()) { f.cleanups = append(f.cleanups, fn) }
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errs = append(f.errs, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func TestMockScopeParallel(t *testing.T) {
+	setupTestTables(t)
+	for _, age := range []int{1, 2, 3, 4} {
+		age := age
+		t.Run(fmt.Sprintf("scope-%d", age), func(t *testing.T) {
+			t.Parallel()
+			scope := zorm.NewMockScope(t)
+			tbl := scope.Table(db, "test_users")
+
+			ret := User{Name: "mocked", Age: age}
+			m := scope.Mock("test_users", "Select", "", "", "", &ret, 1, nil).
+				WithArgs(age).
+				Times(20)
+
+			for i := 0; i < 20; i++ {
+				var u User
+				n, err := tbl.Select(&u, zorm.Where(zorm.Eq("age", age)))
+				if err != nil || n != 1 || u.Age != age {
+					t.Fatalf("scope %d got n=%d err=%v age=%d", age, n, err, u.Age)
+				}
+			}
+			if len(m.Calls()) != 20 {
+				t.Fatalf("expected 20 calls, got %d", len(m.Calls()))
+			}
+		})
+	}
+}
+
+func TestMockScope(t *testing.T) {
+	Convey("MockScope", t, func() {
+		setupTestTables(t)
+
+		Convey("scoped mocks do not leak into other tables", func() {
+			ft := &fakeT{}
+			scope := zorm.NewMockScope(ft)
+			scope.Mock("test_users", "Insert", "", "", "", nil, 99, nil)
+
+			// 未绑定 scope 的表走真实数据库
+			n, err := zorm.Table(db, "test_users").Insert(&User{Name: "real"})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+
+			n, err = zorm.TableContext(scope.Context(context.Background()), db, "test_users").Insert(&User{Name: "fake"})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 99)
+
+			ft.finish()
+			So(ft.errs, ShouldBeEmpty)
+		})
+
+		Convey("cleanup reports unmet mocks", func() {
+			ft := &fakeT{}
+			scope := zorm.NewMockScope(ft)
+			scope.Mock("test_users", "Delete", "", "", "", nil, 1, nil)
+			So(len(ft.cleanups), ShouldEqual, 1)
+
+			ft.finish()
+			So(len(ft.errs), ShouldEqual, 1)
+			So(ft.errs[0], ShouldContainSubstring, "left behind")
+		})
+
+		Convey("mocking is off after scopes and global mocks finish", func() {
+			ft := &fakeT{}
+			scope := zorm.NewMockScope(ft)
+			zorm.ZormMock("test_users", "Insert", "", "", "", nil, 99, nil).AnyTimes()
+			So(zorm.ZormMockFinish(), ShouldBeNil)
+			ft.finish()
+
+			n, err := scope.Table(db, "test_users").Insert(&User{Name: "real"})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+		})
+	})
+}
+
+// ========== Record/Replay Tests ==========
+func TestRecordReplay(t *testing.T) {
+	Convey("Recorder and Replayer", t, func() {
+		setupTestTables(t)
+		golden := t.TempDir() + "/users.golden.json"
+
+		type result struct {
+			ID    int64
+			Users []User
+			Maps  []zorm.V
+			Count int64
+			N     int
+		}
+		run := func(d zorm.ZormDBIFace) (res result, err error) {
+			tbl := zorm.Table(d, "test_users")
+			u := User{Name: "Alice", Email: "a@x.com", Age: 30, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+			if _, err = tbl.Insert(&u); err != nil {
+				return
+			}
+			res.ID = u.ID
+			if _, err = tbl.Insert(&User{Name: "Bob", Age: 20}); err != nil {
+				return
+			}
+			if _, err = tbl.Select(&res.Users, zorm.Where(zorm.Gte("age", 18)), zorm.OrderBy("id")); err != nil {
+				return
+			}
+			if _, err = tbl.Select(&res.Maps, zorm.Fields("name", "age"), zorm.OrderBy("id")); err != nil {
+				return
+			}
+			if _, err = tbl.Select(&res.Count, zorm.Fields("count(1)")); err != nil {
+				return
+			}
+			res.N, err = tbl.Update(zorm.V{"age": 31}, zorm.Where(zorm.Eq("name", "Alice")))
+			return
+		}
+
+		rec := zorm.NewRecorder(db, golden)
+		recorded, err := run(rec)
+		So(err, ShouldBeNil)
+		So(len(recorded.Users), ShouldEqual, 2)
+		So(recorded.Count, ShouldEqual, 2)
+		So(recorded.N, ShouldEqual, 1)
+		So(len(rec.Entries()), ShouldEqual, 6)
+		So(rec.Save(), ShouldBeNil)
+
+		Convey("replay serves recorded results without a database", func() {
+			rp, err := zorm.NewReplayer(golden)
+			So(err, ShouldBeNil)
+
+			replayed, err := run(rp)
+			So(err, ShouldBeNil)
+			So(replayed.ID, ShouldEqual, recorded.ID)
+			So(replayed.Users, ShouldResemble, recorded.Users)
+			So(replayed.Users[0].CreatedAt.Equal(recorded.Users[0].CreatedAt), ShouldBeTrue)
+			So(replayed.Maps, ShouldResemble, recorded.Maps)
+			So(replayed.Count, ShouldEqual, 2)
+			So(replayed.N, ShouldEqual, 1)
+			So(rp.Finish(), ShouldBeNil)
+		})
+
+		Convey("replay fails on SQL drift", func() {
+			rp, err := zorm.NewReplayer(golden)
+			So(err, ShouldBeNil)
+
+			var users []User
+			_, err = zorm.Table(rp, "test_users").Select(&users, zorm.Where(zorm.Gte("age", 21)), zorm.OrderBy("id"))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unexpected query")
+
+			var name string
+			err = rp.QueryRowContext(context.Background(), "select name from test_users").Scan(&name)
+			So(err, ShouldNotBeNil)
+
+			err = rp.Finish()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unused exec")
+		})
+	})
+}
+
+// ========== Migration Tests ==========
+func TestMigrator(t *testing.T) {
+	Convey("Migrator", t, func() {
+		ctx := context.Background()
+		mdb, err := sql.Open("sqlite3", t.TempDir()+"/migrate.db")
+		So(err, ShouldBeNil)
+		defer mdb.Close()
+
+		fsys := fstest.MapFS{
+			"migrations/0001_create_devices.up.sql":   {Data: []byte("CREATE TABLE devices (id INTEGER PRIMARY KEY, name TEXT);")},
+			"migrations/0001_create_devices.down.sql": {Data: []byte("DROP TABLE devices;")},
+			"migrations/0003_add_model.up.sql":        {Data: []byte("ALTER TABLE devices ADD COLUMN model TEXT;\nCREATE INDEX idx_devices_model ON devices(model);")},
+			"migrations/0003_add_model.down.sql":      {Data: []byte("DROP INDEX idx_devices_model;\nALTER TABLE devices DROP COLUMN model;")},
+			"migrations/README.md":                    {Data: []byte("ignored")},
+		}
+		backfill := func(ctx context.Context, db zorm.ZormDBIFace) error {
+			_, err := zorm.Table(db, "devices").Insert(zorm.V{"id": 1, "name": "nas"})
+			return err
+		}
+		unfill := func(ctx context.Context, db zorm.ZormDBIFace) error {
+			_, err := zorm.Table(db, "devices").Delete(zorm.Where(zorm.Eq("id", 1)))
+			return err
+		}
+		newMigrator := func() *zorm.Migrator {
+			m := zorm.NewMigrator(mdb)
+			So(m.RegisterFS(fsys, "migrations"), ShouldBeNil)
+			So(m.RegisterFunc(2, "backfill", backfill, unfill), ShouldBeNil)
+			return m
+		}
+		count := func(table string) int64 {
+			var n int64
+			_, err := zorm.Table(mdb, table).Select(&n, zorm.Fields("count(1)"))
+			So(err, ShouldBeNil)
+			return n
+		}
+
+		m := newMigrator()
+		So(len(m.Migrations()), ShouldEqual, 3)
+		So(m.RegisterFunc(2, "dup", backfill, nil), ShouldNotBeNil)
+
+		applied, err := m.Up(ctx)
+		So(err, ShouldBeNil)
+		So(len(applied), ShouldEqual, 3)
+		So(applied[1].Name, ShouldEqual, "backfill")
+		So(count("devices"), ShouldEqual, 1)
+		So(count(zorm.DefaultMigrationTable), ShouldEqual, 3)
+
+		Convey("re-running is a no-op", func() {
+			applied, err := newMigrator().Up(ctx)
+			So(err, ShouldBeNil)
+			So(len(applied), ShouldEqual, 0)
+
+			st, err := m.Status(ctx)
+			So(err, ShouldBeNil)
+			So(len(st), ShouldEqual, 3)
+			for _, s := range st {
+				So(s.Applied, ShouldBeTrue)
+				So(s.Changed, ShouldBeFalse)
+				So(s.AppliedAt.IsZero(), ShouldBeFalse)
+			}
+		})
+
+		Convey("edited migrations are detected", func() {
+			fsys["migrations/0001_create_devices.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE devices (id INTEGER PRIMARY KEY, name TEXT, extra TEXT);")}
+			defer func() {
+				fsys["migrations/0001_create_devices.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE devices (id INTEGER PRIMARY KEY, name TEXT);")}
+			}()
+			m2 := newMigrator()
+			So(m2.RegisterFunc(4, "more", backfill, nil), ShouldBeNil)
+
+			err := m2.Verify(ctx)
+			So(errors.Is(err, zorm.ErrMigrationChanged), ShouldBeTrue)
+			_, err = m2.Up(ctx)
+			So(errors.Is(err, zorm.ErrMigrationChanged), ShouldBeTrue)
+
+			st, err := m2.Status(ctx)
+			So(err, ShouldBeNil)
+			So(st[0].Changed, ShouldBeTrue)
+			So(st[3].Applied, ShouldBeFalse)
+		})
+
+		Convey("edited down scripts block rollback", func() {
+			fsys["migrations/0003_add_model.down.sql"] = &fstest.MapFile{Data: []byte("DROP INDEX idx_devices_model;")}
+			defer func() {
+				fsys["migrations/0003_add_model.down.sql"] = &fstest.MapFile{Data: []byte("DROP INDEX idx_devices_model;\nALTER TABLE devices DROP COLUMN model;")}
+			}()
+
+			done, err := newMigrator().Down(ctx, 1)
+			So(errors.Is(err, zorm.ErrMigrationChanged), ShouldBeTrue)
+			So(done, ShouldBeEmpty)
+			So(count(zorm.DefaultMigrationTable), ShouldEqual, 3)
+		})
+
+		Convey("Go func migrations are checksummed by name unless Checksum is set", func() {
+			m2 := zorm.NewMigrator(mdb)
+			So(m2.RegisterFS(fsys, "migrations"), ShouldBeNil)
+			So(m2.RegisterFunc(2, "backfill", unfill, backfill), ShouldBeNil)
+			So(m2.Verify(ctx), ShouldBeNil)
+
+			m3 := zorm.NewMigrator(mdb)
+			So(m3.RegisterFS(fsys, "migrations"), ShouldBeNil)
+			So(m3.Register(&zorm.Migration{Version: 2, Name: "backfill", Up: backfill, Down: unfill, Checksum: "v2"}), ShouldBeNil)
+			So(errors.Is(m3.Verify(ctx), zorm.ErrMigrationChanged), ShouldBeTrue)
+		})
+
+		Convey("failed migration is rolled back", func() {
+			m2 := newMigrator()
+			So(m2.Register(&zorm.Migration{
+				Version: 4,
+				Name:    "broken",
+				UpSQL:   "CREATE TABLE t4 (id INTEGER); INSERT INTO missing VALUES (1);",
+			}), ShouldBeNil)
+			applied, err := m2.Up(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "4_broken")
+			So(len(applied), ShouldEqual, 0)
+			So(count(zorm.DefaultMigrationTable), ShouldEqual, 3)
+			_, err = mdb.Exec("SELECT * FROM t4")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("down migrations roll back newest first", func() {
+			rolled, err := m.Down(ctx, 2)
+			So(err, ShouldBeNil)
+			So(len(rolled), ShouldEqual, 2)
+			So(rolled[0].Version, ShouldEqual, 3)
+			So(rolled[1].Version, ShouldEqual, 2)
+			So(count("devices"), ShouldEqual, 0)
+			So(count(zorm.DefaultMigrationTable), ShouldEqual, 1)
+
+			applied, err := m.UpTo(ctx, 2)
+			So(err, ShouldBeNil)
+			So(len(applied), ShouldEqual, 1)
+			So(count("devices"), ShouldEqual, 1)
+
+			m2 := zorm.NewMigrator(mdb)
+			So(m2.Register(&zorm.Migration{Version: 9, Name: "no_down", UpSQL: "SELECT 1"}), ShouldBeNil)
+			_, err = m2.Up(ctx)
+			So(err, ShouldBeNil)
+			_, err = m2.Down(ctx, 1)
+			So(errors.Is(err, zorm.ErrNoDownMigration), ShouldBeTrue)
+
+			st, err := m2.Status(ctx)
+			So(err, ShouldBeNil)
+			So(st[0].Missing, ShouldBeTrue)
+		})
+	})
+}
+
+// ========== SQLite Table Rebuild Tests ==========
+type rebuildItemV2 struct {
+	Name  string  `zorm:"name"`
+	Qty   string  `zorm:"qty,default:'0'"`
+	Price float64 `zorm:"price,default:1.5"`
+}
+
+func (rebuildItemV2) TableName() string { return "items" }
+
+func TestSQLiteTableRebuild(t *testing.T) {
+	Convey("SQLite table rebuild for MODIFY/DROP COLUMN", t, func() {
+		ctx := context.Background()
+		rdb, err := sql.Open("sqlite3", t.TempDir()+"/rebuild.db?_foreign_keys=1")
+		So(err, ShouldBeNil)
+		defer rdb.Close()
+
+		for _, stmt := range []string{
+			"CREATE TABLE devices (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, model TEXT, size TEXT, serial TEXT, UNIQUE (serial))",
+			"CREATE INDEX idx_devices_name ON devices(name)",
+			"CREATE INDEX idx_devices_model ON devices(model)",
+			"CREATE TABLE events (id INTEGER PRIMARY KEY, device_id INTEGER REFERENCES devices(id) ON DELETE CASCADE, msg TEXT)",
+			"CREATE TABLE audit (msg TEXT)",
+			"CREATE TRIGGER trg_devices_insert AFTER INSERT ON devices BEGIN INSERT INTO audit VALUES (NEW.name); END",
+			"INSERT INTO devices (name, model, size, serial) VALUES ('nas', 'zima', '10', 's1'), (NULL, 'cube', '20', 's2')",
+			"INSERT INTO events (device_id, msg) VALUES (1, 'boot')",
+		} {
+			_, err := rdb.Exec(stmt)
+			So(err, ShouldBeNil)
+		}
+
+		manager := zorm.NewDDLManager(rdb, &zorm.DefaultDDLLogger{})
+		columns := func() map[string]*zorm.ColumnDef {
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			return s.Tables["devices"].Columns
+		}
+		indexes := func() map[string]*zorm.IndexInfo {
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			return s.Tables["devices"].Indexes
+		}
+
+		Convey("modify type, nullability and default", func() {
+			cmd := &zorm.AlterTableCommand{
+				TableName: "devices",
+				Operation: "MODIFY COLUMN",
+				Column:    &zorm.ColumnDef{Name: "size", Type: "INTEGER", Nullable: false, DefaultValue: "0"},
+			}
+			So(cmd.Execute(ctx, rdb), ShouldBeNil)
+			col := columns()["size"]
+			So(col.Type, ShouldEqual, "INTEGER")
+			So(col.Nullable, ShouldBeFalse)
+			So(col.DefaultValue, ShouldEqual, "0")
+
+			var size interface{}
+			So(rdb.QueryRow("SELECT size FROM devices WHERE id=2").Scan(&size), ShouldBeNil)
+			So(size, ShouldEqual, int64(20))
+
+			// NULLs are replaced by the new default
+			cmd.Column = &zorm.ColumnDef{Name: "name", Type: "TEXT", Nullable: false, DefaultValue: "'unknown'"}
+			So(cmd.Execute(ctx, rdb), ShouldBeNil)
+			var name string
+			So(rdb.QueryRow("SELECT name FROM devices WHERE id=2").Scan(&name), ShouldBeNil)
+			So(name, ShouldEqual, "unknown")
+
+			// indexes, unique constraints, triggers, autoincrement and foreign keys survive
+			So(indexes(), ShouldContainKey, "idx_devices_name")
+			So(indexes(), ShouldContainKey, "idx_devices_model")
+			_, err := rdb.Exec("INSERT INTO devices (name, serial) VALUES ('dup', 's1')")
+			So(err, ShouldNotBeNil)
+			_, err = rdb.Exec("INSERT INTO devices (name, serial) VALUES ('new', 's3')")
+			So(err, ShouldBeNil)
+			var id, audits int64
+			So(rdb.QueryRow("SELECT id FROM devices WHERE serial='s3'").Scan(&id), ShouldBeNil)
+			So(id, ShouldEqual, 3)
+			So(rdb.QueryRow("SELECT count(1) FROM audit").Scan(&audits), ShouldBeNil)
+			So(audits, ShouldEqual, 3) // 2 seed rows + 1 after the rebuild
+			So(columns()["id"].AutoIncrement, ShouldBeTrue)
+
+			var events int64
+			_, err = rdb.Exec("DELETE FROM devices WHERE id=1")
+			So(err, ShouldBeNil)
+			So(rdb.QueryRow("SELECT count(1) FROM events").Scan(&events), ShouldBeNil)
+			So(events, ShouldEqual, 0)
+		})
+
+		Convey("drop an indexed column", func() {
+			cmd := &zorm.AlterTableCommand{
+				TableName: "devices",
+				Operation: "DROP COLUMN",
+				Column:    &zorm.ColumnDef{Name: "model"},
+			}
+			So(cmd.Execute(ctx, rdb), ShouldBeNil)
+			So(columns(), ShouldNotContainKey, "model")
+			So(indexes(), ShouldNotContainKey, "idx_devices_model")
+			So(indexes(), ShouldContainKey, "idx_devices_name")
+
+			var n int64
+			So(rdb.QueryRow("SELECT count(1) FROM devices").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 2)
+		})
+
+		Convey("failed rebuild leaves the table untouched", func() {
+			cmd := &zorm.AlterTableCommand{
+				TableName: "devices",
+				Operation: "MODIFY COLUMN",
+				Column:    &zorm.ColumnDef{Name: "name", Type: "TEXT", Nullable: false},
+			}
+			err := cmd.Execute(ctx, rdb)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "NOT NULL")
+			So(columns()["name"].Nullable, ShouldBeTrue)
+			So(indexes(), ShouldContainKey, "idx_devices_name")
+
+			var tables int64
+			So(rdb.QueryRow("SELECT count(1) FROM sqlite_master WHERE name LIKE '_zorm_new_%'").Scan(&tables), ShouldBeNil)
+			So(tables, ShouldEqual, 0)
+		})
+
+		Convey("schema plan applies column changes", func() {
+			type Device struct {
+				ID     int64  `zorm:"id,auto_incr"`
+				Name   string `zorm:"name,default:'x'"`
+				Model  string `zorm:"model,default:'x'"`
+				Size   int    `zorm:"size,default:1"`
+				Serial string `zorm:"serial,default:''"`
+			}
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Device{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldBeGreaterThan, 0)
+			So(manager.ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+
+			So(columns()["size"].Type, ShouldEqual, "INTEGER")
+			So(columns()["size"].Nullable, ShouldBeFalse)
+
+			plan, err = manager.GenerateSchemaPlan(ctx, []interface{}{&Device{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldEqual, 0)
+		})
+
+		Convey("rebuild inside a transaction", func() {
+			tx, err := zorm.BeginContext(ctx, rdb)
+			So(err, ShouldBeNil)
+			cmd := &zorm.AlterTableCommand{
+				TableName: "events",
+				Operation: "DROP COLUMN",
+				Column:    &zorm.ColumnDef{Name: "msg"},
+			}
+			So(cmd.Execute(ctx, tx), ShouldBeNil)
+			So(tx.Rollback(), ShouldBeNil)
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			So(s.Tables["events"].Columns, ShouldContainKey, "msg")
+		})
+
+		Convey("types are compared by affinity and data moves need opting in", func() {
+			_, err := rdb.Exec("CREATE TABLE items (name VARCHAR(64) NOT NULL, qty INTEGER NOT NULL DEFAULT (0), price REAL NOT NULL DEFAULT 1.50)")
+			So(err, ShouldBeNil)
+			type Item struct {
+				Name  string  `zorm:"name"`
+				Qty   int64   `zorm:"qty,default:0"`
+				Price float64 `zorm:"price,default:1.5"`
+			}
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Item{}})
+			So(err, ShouldBeNil)
+			So(plan.Commands, ShouldBeEmpty)
+
+			plan, err = manager.GenerateSchemaPlan(ctx, []interface{}{&rebuildItemV2{}})
+			So(err, ShouldBeNil)
+			So(plan.Commands, ShouldHaveLength, 1)
+			So(plan.Commands[0].Description(), ShouldEqual, "ALTER TABLE items MODIFY COLUMN")
+
+			// CreateTables only extends existing tables unless data moves are allowed
+			So(manager.CreateTables(ctx, &rebuildItemV2{}), ShouldBeNil)
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			So(s.Tables["items"].Columns["qty"].Type, ShouldEqual, "INTEGER")
+			So(manager.AllowDataMoving(true).CreateTables(ctx, &rebuildItemV2{}), ShouldBeNil)
+			s, err = manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			So(s.Tables["items"].Columns["qty"].Type, ShouldEqual, "TEXT")
+		})
+
+		Convey("script renders the rebuild that runs", func() {
+			plan := &zorm.SchemaPlan{Commands: []zorm.DDLCommand{&zorm.AlterTableCommand{
+				TableName: "devices",
+				Operation: "MODIFY COLUMN",
+				Column:    &zorm.ColumnDef{Name: "size", Type: "INTEGER", Nullable: true},
+			}}}
+			script, err := manager.Script(ctx, plan)
+			So(err, ShouldBeNil)
+			So(script, ShouldStartWith, "-- ALTER TABLE devices MODIFY COLUMN (data-moving)\n")
+			So(script, ShouldNotContainSubstring, "MODIFY COLUMN `size`")
+			steps := []string{
+				"CREATE TABLE IF NOT EXISTS `_zorm_new_devices`",
+				"INSERT INTO `_zorm_new_devices` (`id`, `name`, `model`, `size`, `serial`) SELECT",
+				"DROP TABLE `devices`;\n",
+				"ALTER TABLE `_zorm_new_devices` RENAME TO `devices`;\n",
+				"CREATE INDEX idx_devices_name ON devices(name);\n",
+				"CREATE TRIGGER trg_devices_insert",
+			}
+			last := -1
+			for _, step := range steps {
+				i := strings.Index(script, step)
+				So(i, ShouldBeGreaterThan, last)
+				last = i
+			}
+			So(columns()["size"].Type, ShouldEqual, "TEXT")
+
+			// the generic script shows the whole sequence too, without the database objects
+			rebuild := &zorm.RebuildTableCommand{TableName: "devices", Columns: []*zorm.ColumnDef{{Name: "id", Type: "INTEGER", Nullable: true}}}
+			So(rebuild.SQL(), ShouldEqual, "CREATE TABLE IF NOT EXISTS `_zorm_new_devices` (\n  `id` INTEGER\n);\n"+
+				"INSERT INTO `_zorm_new_devices` (`id`) SELECT `id` FROM `devices`;\n"+
+				"DROP TABLE `devices`;\n"+
+				"ALTER TABLE `_zorm_new_devices` RENAME TO `devices`")
+		})
+
+		Convey("referenced table can't be rebuilt inside a transaction", func() {
+			// foreign_keys can't be switched off in a transaction, DROP TABLE would cascade into events
+			var events int64
+			err := zorm.Tx(ctx, rdb, func(tx zorm.ZormTxIFace) error {
+				cmd := &zorm.AlterTableCommand{
+					TableName: "devices",
+					Operation: "MODIFY COLUMN",
+					Column:    &zorm.ColumnDef{Name: "size", Type: "INTEGER", Nullable: true},
+				}
+				err := cmd.Execute(ctx, tx)
+				So(tx.QueryRowContext(ctx, "SELECT count(1) FROM events").Scan(&events), ShouldBeNil)
+				return err
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "referenced by events")
+			So(events, ShouldEqual, 1)
+			So(rdb.QueryRow("SELECT count(1) FROM events").Scan(&events), ShouldBeNil)
+			So(events, ShouldEqual, 1)
+			So(columns()["size"].Type, ShouldEqual, "TEXT")
+		})
+	})
+}
+
+// ========== Index Tag Tests ==========
+func TestIndexTags(t *testing.T) {
+	Convey("Index and unique tags", t, func() {
+		ctx := context.Background()
+		idb, err := sql.Open("sqlite3", t.TempDir()+"/index.db")
+		So(err, ShouldBeNil)
+		defer idb.Close()
+		manager := zorm.NewDDLManager(idb, &zorm.DefaultDDLLogger{})
+
+		type Order struct {
+			ID        int64     `zorm:"id,auto_incr"`
+			Email     string    `zorm:"email,unique"`
+			UserID    int64     `zorm:"user_id,index,index:idx_user_time"`
+			CreatedAt time.Time `zorm:"created_at,index:idx_user_time"`
+			Code      string    `zorm:"code,unique:uniq_code_region"`
+			Region    string    `zorm:"region,unique:uniq_code_region"`
+		}
+		indexes := func() map[string]*zorm.IndexInfo {
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			return s.Tables["orders"].Indexes
+		}
+
+		plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Order{}})
+		So(err, ShouldBeNil)
+		So(len(plan.Commands), ShouldEqual, 5)
+		So(plan.Commands[1].SQL(), ShouldEqual, "CREATE UNIQUE INDEX `uniq_orders_email` ON `orders` (`email`)")
+		So(manager.ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+
+		idx := indexes()
+		So(idx["uniq_orders_email"].Unique, ShouldBeTrue)
+		So(idx["idx_orders_user_id"].Columns, ShouldResemble, []string{"user_id"})
+		So(idx["idx_user_time"].Columns, ShouldResemble, []string{"user_id", "created_at"})
+		So(idx["idx_user_time"].Unique, ShouldBeFalse)
+		So(idx["uniq_code_region"].Columns, ShouldResemble, []string{"code", "region"})
+		So(idx["uniq_code_region"].Unique, ShouldBeTrue)
+
+		// 再次生成计划时没有变化
+		plan, err = manager.GenerateSchemaPlan(ctx, []interface{}{&Order{}})
+		So(err, ShouldBeNil)
+		So(len(plan.Commands), ShouldEqual, 0)
+
+		Convey("changed and removed indexes are recreated and dropped", func() {
+			type Order struct {
+				ID        int64     `zorm:"id,auto_incr"`
+				Email     string    `zorm:"email,index"`
+				UserID    int64     `zorm:"user_id,index:idx_user_time"`
+				CreatedAt time.Time `zorm:"created_at"`
+				Code      string    `zorm:"code,unique:uniq_code_region"`
+				Region    string    `zorm:"region,unique:uniq_code_region"`
+			}
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Order{}})
+			So(err, ShouldBeNil)
+			var descs []string
+			for _, cmd := range plan.Commands {
+				descs = append(descs, cmd.Description())
+			}
+			So(descs, ShouldResemble, []string{
+				"DROP INDEX idx_orders_user_id",
+				"DROP INDEX idx_user_time",
+				"DROP INDEX uniq_orders_email",
+				"CREATE INDEX idx_orders_email ON orders",
+				"CREATE INDEX idx_user_time ON orders",
+			})
+			So(manager.ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+
+			idx := indexes()
+			So(idx, ShouldNotContainKey, "uniq_orders_email")
+			So(idx, ShouldNotContainKey, "idx_orders_user_id")
+			So(idx["idx_user_time"].Columns, ShouldResemble, []string{"user_id"})
+			So(idx["idx_orders_email"].Unique, ShouldBeFalse)
+		})
+
+		Convey("hand-made indexes are kept for models without index tags", func() {
+			type Plain struct {
+				ID   int64  `zorm:"id,auto_incr"`
+				Name string `zorm:"name"`
+			}
+			So(manager.CreateTables(ctx, &Plain{}), ShouldBeNil)
+			_, err := idb.Exec("CREATE INDEX idx_manual ON plains(name)")
+			So(err, ShouldBeNil)
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Plain{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldEqual, 0)
+		})
+
+		Convey("conflicting declarations are rejected", func() {
+			type Bad struct {
+				A string `zorm:"a,index:idx_x"`
+				B string `zorm:"b,unique:idx_x"`
+			}
+			_, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Bad{}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("CreateTable creates declared indexes", func() {
+			So(zorm.CreateTable(idb, "orders2", &Order{}, nil), ShouldBeNil)
+			So(zorm.CreateTable(idb, "orders2", &Order{}, nil), ShouldBeNil)
+			var n int
+			So(idb.QueryRow("SELECT count(1) FROM sqlite_master WHERE type='index' AND tbl_name='orders2' AND sql IS NOT NULL").Scan(&n), ShouldBeNil)
+			// 索引名在库内全局唯一，idx_user_time 和 uniq_code_region 已属于 orders
+			So(n, ShouldEqual, 2)
+		})
+	})
+}
+
+// ========== Primary and Foreign Key Tag Tests ==========
+func TestPKFKTags(t *testing.T) {
+	Convey("pk and fk tags", t, func() {
+		ctx := context.Background()
+		kdb, err := sql.Open("sqlite3", "file:"+t.TempDir()+"/keys.db?_foreign_keys=1")
+		So(err, ShouldBeNil)
+		defer kdb.Close()
+		manager := zorm.NewDDLManager(kdb, &zorm.DefaultDDLLogger{})
+
+		type User struct {
+			ID   int64  `zorm:"id,auto_incr"`
+			Name string `zorm:"name"`
+		}
+		type App struct {
+			ID   int64  `zorm:"id,auto_incr"`
+			Name string `zorm:"name"`
+		}
+		type Membership struct {
+			UserID int64 `zorm:"user_id,pk,fk:users.id,on_delete:cascade"`
+			AppID  int64 `zorm:"app_id,pk,fk:apps.id,on_delete:cascade"`
+			Role   string
+		}
+		models := []interface{}{&User{}, &App{}, &Membership{}}
+
+		plan, err := manager.GenerateSchemaPlan(ctx, models)
+		So(err, ShouldBeNil)
+		So(len(plan.Commands), ShouldEqual, 3)
+		createSQL := plan.Commands[2].SQL()
+		So(createSQL, ShouldContainSubstring, "PRIMARY KEY (`user_id`, `app_id`)")
+		So(createSQL, ShouldContainSubstring, "FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE")
+		So(createSQL, ShouldContainSubstring, "FOREIGN KEY (`app_id`) REFERENCES `apps` (`id`) ON DELETE CASCADE")
+		So(manager.ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+
+		s, err := manager.GetCurrentSchema(ctx)
+		So(err, ShouldBeNil)
+		So(s.Tables["memberships"].PrimaryKey, ShouldResemble, []string{"user_id", "app_id"})
+		So(len(s.Tables["memberships"].ForeignKeys), ShouldEqual, 2)
+
+		// 再次生成计划时没有变化
+		plan, err = manager.GenerateSchemaPlan(ctx, models)
+		So(err, ShouldBeNil)
+		So(len(plan.Commands), ShouldEqual, 0)
+
+		_, err = zorm.Table(kdb, "users").Insert(&User{Name: "alice"})
+		So(err, ShouldBeNil)
+		_, err = zorm.Table(kdb, "apps").Insert(&App{Name: "files"})
+		So(err, ShouldBeNil)
+		_, err = zorm.Table(kdb, "memberships").Insert(&Membership{UserID: 1, AppID: 1, Role: "owner"})
+		So(err, ShouldBeNil)
+
+		Convey("composite key rejects duplicates and deletes cascade", func() {
+			_, err := zorm.Table(kdb, "memberships").Insert(&Membership{UserID: 1, AppID: 1})
+			So(err, ShouldNotBeNil)
+			_, err = zorm.Table(kdb, "memberships").Insert(&Membership{UserID: 2, AppID: 1})
+			So(err, ShouldNotBeNil)
+
+			_, err = zorm.Table(kdb, "users").Delete(zorm.Where("id = ?", 1))
+			So(err, ShouldBeNil)
+			var n int
+			So(kdb.QueryRow("SELECT count(1) FROM memberships").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("missing constraints rebuild the table", func() {
+			_, err := kdb.Exec("CREATE TABLE tags (app_id BIGINT NOT NULL DEFAULT 0, name TEXT NOT NULL DEFAULT 'default', note TEXT)")
+			So(err, ShouldBeNil)
+			_, err = kdb.Exec("INSERT INTO tags VALUES (1, 'photo', 'kept')")
+			So(err, ShouldBeNil)
+
+			type Tag struct {
+				AppID int64  `zorm:"app_id,pk,fk:apps.id,on_delete:cascade"`
+				Name  string `zorm:"name,pk"`
+			}
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Tag{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldEqual, 1)
+			So(plan.Commands[0].Description(), ShouldEqual, "REBUILD TABLE tags")
+			So(manager.ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+
+			var note string
+			So(kdb.QueryRow("SELECT note FROM tags WHERE app_id = 1 AND name = 'photo'").Scan(&note), ShouldBeNil)
+			So(note, ShouldEqual, "kept")
+
+			// 约束已存在，只剩下模型中没有的列
+			plan, err = manager.GenerateSchemaPlan(ctx, []interface{}{&Tag{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldEqual, 1)
+			So(plan.Commands[0].Description(), ShouldEqual, "ALTER TABLE tags DROP COLUMN")
+
+			_, err = zorm.Table(kdb, "apps").Delete(zorm.Where("id = ?", 1))
+			So(err, ShouldBeNil)
+			var n int
+			So(kdb.QueryRow("SELECT count(1) FROM tags").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("integer primary keys without auto_incr are stable", func() {
+			type Device struct {
+				ID   int    `zorm:"id,pk"`
+				Name string `zorm:"name"`
+			}
+			So(manager.CreateTables(ctx, &Device{}), ShouldBeNil)
+			var createSQL string
+			So(kdb.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'devices'").Scan(&createSQL), ShouldBeNil)
+			So(createSQL, ShouldNotContainSubstring, "AUTOINCREMENT")
+
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			So(s.Tables["devices"].Columns["id"].AutoIncrement, ShouldBeFalse)
+			So(s.Tables["users"].Columns["id"].AutoIncrement, ShouldBeTrue)
+
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Device{}})
+			So(err, ShouldBeNil)
+			So(plan.Commands, ShouldBeEmpty)
+
+			// 已有的 INTEGER PRIMARY KEY 不因模型的 auto_incr 重建
+			_, err = kdb.Exec("CREATE TABLE sessions (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT 'default')")
+			So(err, ShouldBeNil)
+			type Session struct {
+				ID   int64  `zorm:"id,auto_incr"`
+				Name string `zorm:"name"`
+			}
+			plan, err = manager.GenerateSchemaPlan(ctx, []interface{}{&Session{}})
+			So(err, ShouldBeNil)
+			So(plan.Commands, ShouldBeEmpty)
+		})
+
+		Convey("modifying a column keeps foreign keys", func() {
+			cmd := &zorm.AlterTableCommand{
+				TableName: "memberships",
+				Operation: "MODIFY COLUMN",
+				Column:    &zorm.ColumnDef{Name: "role", Type: "TEXT", Nullable: true},
+			}
+			So(cmd.Execute(ctx, kdb), ShouldBeNil)
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			So(s.Tables["memberships"].PrimaryKey, ShouldResemble, []string{"user_id", "app_id"})
+			So(len(s.Tables["memberships"].ForeignKeys), ShouldEqual, 2)
+		})
+
+		Convey("CreateTable emits the constraints", func() {
+			So(zorm.CreateTable(kdb, "user_apps", &Membership{}, nil), ShouldBeNil)
+			var createSQL string
+			So(kdb.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'user_apps'").Scan(&createSQL), ShouldBeNil)
+			So(createSQL, ShouldContainSubstring, "PRIMARY KEY (`user_id`, `app_id`)")
+			So(createSQL, ShouldContainSubstring, "REFERENCES `apps` (`id`) ON DELETE CASCADE")
+		})
+
+		Convey("invalid declarations are rejected", func() {
+			type BadRef struct {
+				UserID int64 `zorm:"user_id,fk:users"`
+			}
+			_, err := manager.GenerateSchemaPlan(ctx, []interface{}{&BadRef{}})
+			So(err, ShouldNotBeNil)
+
+			type BadAction struct {
+				UserID int64 `zorm:"user_id,on_delete:cascade"`
+			}
+			_, err = manager.GenerateSchemaPlan(ctx, []interface{}{&BadAction{}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// ========== Schema Plan Transaction Tests ==========
+func TestSchemaPlanTransaction(t *testing.T) {
+	Convey("Schema plan runs in one transaction", t, func() {
+		ctx := context.Background()
+		pdb, err := sql.Open("sqlite3", "file:"+t.TempDir()+"/plan.db?_foreign_keys=1")
+		So(err, ShouldBeNil)
+		defer pdb.Close()
+		manager := zorm.NewDDLManager(pdb, &zorm.DefaultDDLLogger{})
+		tableExists := func(name string) bool {
+			exists, err := zorm.TableExists(pdb, name)
+			So(err, ShouldBeNil)
+			return exists
+		}
+
+		Convey("a failing command rolls back the whole plan", func() {
+			plan := &zorm.SchemaPlan{Commands: []zorm.DDLCommand{
+				&zorm.CreateTableCommand{
+					TableName: "widgets",
+					Columns:   []*zorm.ColumnDef{{Name: "id", Type: "INTEGER", AutoIncrement: true}},
+				},
+				&zorm.AlterTableCommand{
+					TableName: "missing",
+					Operation: "ADD COLUMN",
+					Column:    &zorm.ColumnDef{Name: "name", Type: "TEXT", Nullable: true},
+				},
+			}}
+			err := manager.ExecuteSchemaPlan(ctx, plan)
+			So(err, ShouldNotBeNil)
+			var planErr *zorm.SchemaPlanError
+			So(errors.As(err, &planErr), ShouldBeTrue)
+			So(planErr.Index, ShouldEqual, 1)
+			So(planErr.Command, ShouldEqual, plan.Commands[1])
+			So(tableExists("widgets"), ShouldBeFalse)
+		})
+
+		Convey("dry run returns the script without executing it", func() {
+			type Widget struct {
+				ID   int64  `zorm:"id,auto_incr"`
+				Name string `zorm:"name,unique"`
+			}
+			script, err := manager.DryRun(ctx, &Widget{})
+			So(err, ShouldBeNil)
+			So(script, ShouldContainSubstring, "-- CREATE TABLE widgets (safe)\nCREATE TABLE IF NOT EXISTS `widgets`")
+			So(script, ShouldContainSubstring, "CREATE UNIQUE INDEX `uniq_widgets_name` ON `widgets` (`name`);\n")
+			So(tableExists("widgets"), ShouldBeFalse)
+
+			So(zorm.AtomicCreateTablesWithContext(ctx, pdb, nil, &Widget{}), ShouldBeNil)
+			So(tableExists("widgets"), ShouldBeTrue)
+			script, err = manager.DryRun(ctx, &Widget{})
+			So(err, ShouldBeNil)
+			So(script, ShouldEqual, "")
+		})
+
+		Convey("rebuilding a referenced table doesn't cascade", func() {
+			_, err := pdb.Exec(`CREATE TABLE parents (id INTEGER PRIMARY KEY, name TEXT);
+				CREATE TABLE children (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES parents(id) ON DELETE CASCADE);
+				INSERT INTO parents VALUES (1, 'p');
+				INSERT INTO children VALUES (1, 1);`)
+			So(err, ShouldBeNil)
+			plan := &zorm.SchemaPlan{Commands: []zorm.DDLCommand{
+				&zorm.AlterTableCommand{
+					TableName: "parents",
+					Operation: "MODIFY COLUMN",
+					Column:    &zorm.ColumnDef{Name: "name", Type: "TEXT", DefaultValue: "''"},
+				},
+			}}
+			So(manager.ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+			var n int
+			So(pdb.QueryRow("SELECT count(1) FROM children").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 1)
+		})
+	})
+}
+
+// ========== Destructive Change Tests ==========
+func TestDestructiveChanges(t *testing.T) {
+	Convey("Destructive changes and renames", t, func() {
+		ctx := context.Background()
+		ddb, err := sql.Open("sqlite3", t.TempDir()+"/destructive.db")
+		So(err, ShouldBeNil)
+		defer ddb.Close()
+		manager := zorm.NewDDLManager(ddb, &zorm.DefaultDDLLogger{})
+
+		type Person struct {
+			ID     int64  `zorm:"id,auto_incr"`
+			Name   string `zorm:"name"`
+			Legacy string `zorm:"legacy"`
+		}
+		So(manager.CreateTables(ctx, &Person{}), ShouldBeNil)
+		_, err = ddb.Exec("INSERT INTO persons (name, legacy) VALUES ('alice', 'x')")
+		So(err, ShouldBeNil)
+		columns := func() map[string]*zorm.ColumnDef {
+			s, err := manager.GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			return s.Tables["persons"].Columns
+		}
+
+		Convey("renamed and removed fields", func() {
+			type Person struct {
+				ID       int64  `zorm:"id,auto_incr"`
+				FullName string `zorm:"full_name,was:nick,was:name"`
+			}
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Person{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldEqual, 2)
+			So(plan.Commands[0].SQL(), ShouldEqual, "ALTER TABLE `persons` RENAME COLUMN `name` TO `full_name`")
+			So(plan.Commands[1].SQL(), ShouldEqual, "ALTER TABLE `persons` DROP COLUMN `legacy`")
+			So(plan.Kinds(), ShouldResemble, []zorm.ChangeKind{zorm.ChangeSafe, zorm.ChangeDestructive})
+			So(plan.Script(), ShouldContainSubstring, "-- ALTER TABLE persons DROP COLUMN (destructive)\n")
+
+			// 没有AllowDestructive时拒绝执行，也不执行其他命令
+			err = manager.ExecuteSchemaPlan(ctx, plan)
+			So(errors.Is(err, zorm.ErrDestructiveChange), ShouldBeTrue)
+			So(columns(), ShouldContainKey, "name")
+
+			// CreateTables跳过破坏性命令
+			So(manager.CreateTables(ctx, &Person{}), ShouldBeNil)
+			cols := columns()
+			So(cols, ShouldContainKey, "full_name")
+			So(cols, ShouldContainKey, "legacy")
+			var name string
+			So(ddb.QueryRow("SELECT full_name FROM persons").Scan(&name), ShouldBeNil)
+			So(name, ShouldEqual, "alice")
+
+			plan, err = manager.GenerateSchemaPlan(ctx, []interface{}{&Person{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldEqual, 1)
+			So(manager.AllowDestructive(true).ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+			So(columns(), ShouldNotContainKey, "legacy")
+		})
+
+		Convey("rename target still declared is rejected", func() {
+			type Person struct {
+				ID       int64  `zorm:"id,auto_incr"`
+				Name     string `zorm:"name"`
+				FullName string `zorm:"full_name,was:name"`
+			}
+			_, err := manager.GenerateSchemaPlan(ctx, []interface{}{&Person{}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("command classification", func() {
+			So(zorm.ClassifyCommand(&zorm.CreateTableCommand{}), ShouldEqual, zorm.ChangeSafe)
+			So(zorm.ClassifyCommand(&zorm.AlterTableCommand{Operation: "ADD COLUMN"}), ShouldEqual, zorm.ChangeSafe)
+			So(zorm.ClassifyCommand(&zorm.AlterTableCommand{Operation: "MODIFY COLUMN"}), ShouldEqual, zorm.ChangeDataMoving)
+			So(zorm.ClassifyCommand(&zorm.RebuildTableCommand{}), ShouldEqual, zorm.ChangeDataMoving)
+			So(zorm.ClassifyCommand(&zorm.DropTableCommand{}), ShouldEqual, zorm.ChangeDestructive)
+			So(zorm.ChangeDestructive.String(), ShouldEqual, "destructive")
+		})
+	})
+}
+
+// ========== Naming Strategy Tests ==========
+type namedModel struct {
+	ID int64 `zorm:"id,auto_incr"`
+}
+
+func (*namedModel) TableName() string { return "custom_named" }
+
+func TestNamingStrategy(t *testing.T) {
+	Convey("Naming strategy", t, func() {
+		ctx := context.Background()
+		ndb, err := sql.Open("sqlite3", t.TempDir()+"/naming.db")
+		So(err, ShouldBeNil)
+		defer ndb.Close()
+		manager := zorm.NewDDLManager(ndb, &zorm.DefaultDDLLogger{})
+
+		type Category struct {
+			ID int64 `zorm:"id,auto_incr"`
+		}
+		type UserApp struct {
+			ID         int64 `zorm:"id,auto_incr"`
+			HTTPServer string
+			APIKey     string `zorm:",not_null"`
+			UserID     int64
+		}
+		type Box struct {
+			ID int64 `zorm:"id,auto_incr"`
+		}
+
+		Convey("legacy strategy by default", func() {
+			script, err := manager.DryRun(ctx, &Category{}, &UserApp{}, &Box{}, &namedModel{}, namedModel{})
+			So(err, ShouldBeNil)
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `categorys`")
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `userapps`")
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `boxs`")
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `custom_named`")
+			So(script, ShouldContainSubstring, "`httpserver` TEXT")
+			So(script, ShouldContainSubstring, "`apikey` TEXT")
+			So(script, ShouldContainSubstring, "`user_id` BIGINT")
+
+			// CreateTable与DDL管理器使用相同的列名
+			So(zorm.CreateTables(ndb, &UserApp{}), ShouldBeNil)
+			_, err = zorm.Table(ndb, "userapps").Insert(&UserApp{HTTPServer: "nginx", APIKey: "k", UserID: 7})
+			So(err, ShouldBeNil)
+			var res UserApp
+			_, err = zorm.Table(ndb, "userapps").Select(&res, zorm.Where("httpserver = ?", "nginx"))
+			So(err, ShouldBeNil)
+			So(res.APIKey, ShouldEqual, "k")
+			So(res.UserID, ShouldEqual, 7)
+
+			plan, err := manager.GenerateSchemaPlan(ctx, []interface{}{&UserApp{}})
+			So(err, ShouldBeNil)
+			So(len(plan.Commands), ShouldEqual, 0)
+		})
+
+		Convey("default and singular strategies are opt-in", func() {
+			defer zorm.SetNamingStrategy(nil)
+
+			zorm.SetNamingStrategy(zorm.DefaultNamingStrategy{})
+			script, err := manager.DryRun(ctx, &Category{}, &UserApp{}, &Box{}, &namedModel{})
+			So(err, ShouldBeNil)
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `categories`")
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `user_apps`")
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `boxes`")
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `custom_named`")
+			So(script, ShouldContainSubstring, "`http_server` TEXT")
+			So(script, ShouldContainSubstring, "`api_key` TEXT")
+
+			So(zorm.CreateTables(ndb, &UserApp{}), ShouldBeNil)
+			_, err = zorm.Table(ndb, "user_apps").Insert(&UserApp{HTTPServer: "nginx", APIKey: "k", UserID: 7})
+			So(err, ShouldBeNil)
+			var res UserApp
+			_, err = zorm.Table(ndb, "user_apps").Select(&res, zorm.Where("http_server = ?", "nginx"))
+			So(err, ShouldBeNil)
+			So(res.APIKey, ShouldEqual, "k")
+
+			zorm.SetNamingStrategy(zorm.DefaultNamingStrategy{SingularTable: true})
+			script, err = manager.DryRun(ctx, &UserApp{})
+			So(err, ShouldBeNil)
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `user_app`")
+
+			zorm.SetNamingStrategy(nil)
+			script, err = manager.DryRun(ctx, &UserApp{})
+			So(err, ShouldBeNil)
+			So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `userapps`")
+		})
+
+		Convey("switching strategies invalidates cached field mappings", func() {
+			defer zorm.SetNamingStrategy(nil)
+			type Server struct {
+				HTTPServer string
+			}
+			_, err := ndb.Exec(`CREATE TABLE servers (http_server TEXT, httpserver TEXT);
+				INSERT INTO servers VALUES ('snake', 'legacy');`)
+			So(err, ShouldBeNil)
+
+			// the same table object and call sites before and after the switch
+			tbl := zorm.Table(ndb, "servers")
+			get := func(col string) (string, []string, error) {
+				var one Server
+				if _, err := tbl.Select(&one, zorm.Fields(col)); err != nil {
+					return "", nil, err
+				}
+				keyed := map[string]Server{}
+				if _, err := tbl.Select(&keyed, zorm.KeyBy(col)); err != nil {
+					return "", nil, err
+				}
+				var keys []string
+				for k := range keyed {
+					keys = append(keys, k)
+				}
+				return one.HTTPServer, keys, nil
+			}
+			update := func(col, v string) error {
+				_, err := tbl.Update(&Server{HTTPServer: v}, zorm.Fields(col))
+				return err
+			}
+
+			one, keys, err := get("httpserver")
+			So(err, ShouldBeNil)
+			So(one, ShouldEqual, "legacy")
+			So(keys, ShouldResemble, []string{"legacy"})
+			So(update("httpserver", "legacy2"), ShouldBeNil)
+
+			zorm.SetNamingStrategy(zorm.DefaultNamingStrategy{})
+			one, keys, err = get("http_server")
+			So(err, ShouldBeNil)
+			So(one, ShouldEqual, "snake")
+			So(keys, ShouldResemble, []string{"snake"})
+			So(update("http_server", "snake2"), ShouldBeNil)
+
+			var snake, legacy string
+			So(ndb.QueryRow("SELECT http_server, httpserver FROM servers").Scan(&snake, &legacy), ShouldBeNil)
+			So(snake, ShouldEqual, "snake2")
+			So(legacy, ShouldEqual, "legacy2")
+		})
+	})
+}
+
+// ========== Struct Generator Tests ==========
+func TestGenerateStructs(t *testing.T) {
+	Convey("Generate structs from the schema", t, func() {
+		ctx := context.Background()
+		gdb, err := sql.Open("sqlite3", t.TempDir()+"/gen.db")
+		So(err, ShouldBeNil)
+		defer gdb.Close()
+		_, err = gdb.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+			CREATE TABLE user_apps (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				app_name TEXT NOT NULL DEFAULT 'x',
+				http_url VARCHAR(255),
+				score REAL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				avatar BLOB NOT NULL,
+				user_id BIGINT NOT NULL DEFAULT 0 REFERENCES users(id) ON DELETE CASCADE);
+			CREATE INDEX idx_user_apps_user_id ON user_apps(user_id);
+			CREATE UNIQUE INDEX uniq_app_user ON user_apps(user_id, app_name);
+			CREATE TABLE device_info (device_id BIGINT NOT NULL, slot INTEGER NOT NULL, note TEXT DEFAULT 'a,b', PRIMARY KEY (device_id, slot));
+			CREATE TABLE zorm_migrations (version INTEGER PRIMARY KEY);`)
+		So(err, ShouldBeNil)
+
+		schema, err := zorm.NewDDLManager(gdb, nil).GetCurrentSchema(ctx)
+		So(err, ShouldBeNil)
+		out, err := zorm.GenerateStructs(schema, zorm.GenerateOptions{Package: "store"})
+		So(err, ShouldBeNil)
+		src := string(out)
+
+		So(src, ShouldContainSubstring, "package store\n\nimport \"time\"\n")
+		So(src, ShouldNotContainSubstring, "zorm_migrations")
+		So(src, ShouldContainSubstring, `type UserApp struct {
+	ID        int64     `+"`zorm:\"id,auto_incr\"`"+`
+	AppName   string    `+"`zorm:\"app_name,default:'x',unique:uniq_app_user\"`"+`
+	HTTPURL   *string   `+"`zorm:\"http_url,type:VARCHAR(255)\"`"+`
+	Score     *float64  `+"`zorm:\"score,type:REAL\"`"+`
+	CreatedAt time.Time `+"`zorm:\"created_at,default:CURRENT_TIMESTAMP\"`"+`
+	Avatar    []byte    `+"`zorm:\"avatar,not_null\"`"+`
+	UserID    int64     `+"`zorm:\"user_id,default:0,fk:users.id,on_delete:cascade,index,unique:uniq_app_user\"`"+`
+}`)
+		So(src, ShouldContainSubstring, "// NOTE: index uniq_app_user is on (user_id, app_name)")
+		So(src, ShouldContainSubstring, "DeviceID int64   `zorm:\"device_id,pk\"`")
+		So(src, ShouldContainSubstring, "Note     *string `zorm:\"note\"` // default 'a,b'")
+		// 命名规则得不到的表名生成TableName方法
+		So(src, ShouldContainSubstring, "func (*DeviceInfo) TableName() string { return \"device_info\" }")
+		So(src, ShouldContainSubstring, "func (*UserApp) TableName() string { return \"user_apps\" }")
+		So(src, ShouldNotContainSubstring, "func (*User) TableName()")
+
+		So(src, ShouldContainSubstring, "Slot     int64   `zorm:\"slot,pk,type:INTEGER\"`")
+
+		Convey("generated structs diff to an empty plan", func() {
+			_, err := gdb.Exec(`CREATE TABLE gadgets (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name VARCHAR(64) NOT NULL,
+				slot INTEGER NOT NULL DEFAULT 0,
+				price NUMERIC,
+				weight REAL NOT NULL DEFAULT 0.5,
+				payload BLOB,
+				seen_at TIMESTAMP,
+				enabled BOOLEAN NOT NULL DEFAULT 0);
+				CREATE INDEX idx_gadgets_slot ON gadgets(slot);`)
+			So(err, ShouldBeNil)
+			schema, err := zorm.NewDDLManager(gdb, nil).GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			out, err := zorm.GenerateStructs(schema, zorm.GenerateOptions{Tables: []string{"gadgets"}})
+			So(err, ShouldBeNil)
+			So(string(out), ShouldContainSubstring, `type Gadget struct {
+	ID      int64      `+"`zorm:\"id,auto_incr\"`"+`
+	Name    string     `+"`zorm:\"name,type:VARCHAR(64)\"`"+`
+	Slot    int64      `+"`zorm:\"slot,type:INTEGER,default:0,index\"`"+`
+	Price   *float64   `+"`zorm:\"price,type:NUMERIC\"`"+`
+	Weight  float64    `+"`zorm:\"weight,type:REAL,default:0.5\"`"+`
+	Payload []byte     `+"`zorm:\"payload\"`"+`
+	SeenAt  *time.Time `+"`zorm:\"seen_at,type:TIMESTAMP\"`"+`
+	Enabled bool       `+"`zorm:\"enabled,default:0\"`"+`
+}`)
+
+			// 与生成代码相同的结构体
+			type Gadget struct {
+				ID      int64      `zorm:"id,auto_incr"`
+				Name    string     `zorm:"name,type:VARCHAR(64)"`
+				Slot    int64      `zorm:"slot,type:INTEGER,default:0,index"`
+				Price   *float64   `zorm:"price,type:NUMERIC"`
+				Weight  float64    `zorm:"weight,type:REAL,default:0.5"`
+				Payload []byte     `zorm:"payload"`
+				SeenAt  *time.Time `zorm:"seen_at,type:TIMESTAMP"`
+				Enabled bool       `zorm:"enabled,default:0"`
+			}
+			plan, err := zorm.NewDDLManager(gdb, nil).GenerateSchemaPlan(ctx, []interface{}{&Gadget{}})
+			So(err, ShouldBeNil)
+			So(plan.Commands, ShouldBeEmpty)
+
+			// 新建的表使用声明的类型
+			So(zorm.CreateTable(gdb, "gadgets_copy", &Gadget{}, nil), ShouldBeNil)
+			copySchema, err := zorm.NewDDLManager(gdb, nil).GetCurrentSchema(ctx)
+			So(err, ShouldBeNil)
+			So(copySchema.Tables["gadgets_copy"].Columns["name"].Type, ShouldEqual, "VARCHAR(64)")
+			So(copySchema.Tables["gadgets_copy"].Columns["seen_at"].Type, ShouldEqual, "TIMESTAMP")
+		})
+
+		Convey("selected tables", func() {
+			out, err := zorm.GenerateStructs(schema, zorm.GenerateOptions{Tables: []string{"users"}})
+			So(err, ShouldBeNil)
+			So(string(out), ShouldContainSubstring, "package models\n\n// User maps table users")
+			So(string(out), ShouldNotContainSubstring, "UserApp")
+
+			_, err = zorm.GenerateStructs(schema, zorm.GenerateOptions{Tables: []string{"missing"}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// ========== Schema Diff Tests ==========
+func TestDiffSchemas(t *testing.T) {
+	Convey("DiffSchemas against a JSON reference schema", t, func() {
+		ctx := context.Background()
+		dir := t.TempDir()
+		open := func(name, script string) *sql.DB {
+			d, err := sql.Open("sqlite3", "file:"+dir+"/"+name+"?_foreign_keys=1")
+			So(err, ShouldBeNil)
+			_, err = d.Exec(script)
+			So(err, ShouldBeNil)
+			return d
+		}
+
+		golden := open("golden.db", `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL DEFAULT 'guest');
+			CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER REFERENCES users(id) ON DELETE CASCADE, total REAL);
+			CREATE INDEX idx_orders_user_id ON orders(user_id);
+			CREATE TABLE tags (name TEXT NOT NULL);`)
+		defer golden.Close()
+		device := open("device.db", `CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL DEFAULT '');
+			CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, total REAL);
+			CREATE INDEX idx_orders_total ON orders(total);
+			INSERT INTO users (name) VALUES ('a');
+			INSERT INTO orders (user_id, total) VALUES (1, 9.5);`)
+		defer device.Close()
+
+		reference, err := zorm.NewDDLManager(golden, nil).GetCurrentSchema(ctx)
+		So(err, ShouldBeNil)
+
+		// Ship the reference as JSON and read it back
+		var buf bytes.Buffer
+		So(reference.WriteJSON(&buf), ShouldBeNil)
+		So(buf.String(), ShouldContainSubstring, `"default": "'guest'"`)
+		loaded, err := zorm.ReadSchemaJSON(bytes.NewReader(buf.Bytes()))
+		So(err, ShouldBeNil)
+		So(loaded, ShouldResemble, reference)
+
+		_, err = zorm.ReadSchemaJSON(bytes.NewReader([]byte(`{"tables": {"t": {"colums": {}}}}`)))
+		So(err, ShouldNotBeNil)
+
+		dm := zorm.NewDDLManager(device, &zorm.DefaultDDLLogger{})
+		current, err := dm.GetCurrentSchema(ctx)
+		So(err, ShouldBeNil)
+		plan, err := dm.DiffSchemas(ctx, current, loaded)
+		So(err, ShouldBeNil)
+
+		script := plan.Script()
+		So(script, ShouldContainSubstring, "DROP INDEX idx_orders_total")
+		So(script, ShouldContainSubstring, "REBUILD TABLE orders")
+		So(script, ShouldContainSubstring, "CREATE INDEX `idx_orders_user_id` ON `orders` (`user_id`)")
+		So(script, ShouldContainSubstring, "MODIFY COLUMN `name` TEXT NOT NULL DEFAULT 'guest'")
+		So(script, ShouldContainSubstring, "CREATE TABLE IF NOT EXISTS `tags`")
+		So(plan.Destructive(), ShouldBeEmpty)
+
+		So(dm.ExecuteSchemaPlan(ctx, plan), ShouldBeNil)
+
+		current, err = dm.GetCurrentSchema(ctx)
+		So(err, ShouldBeNil)
+		plan, err = dm.DiffSchemas(ctx, current, loaded)
+		So(err, ShouldBeNil)
+		So(plan.Commands, ShouldBeEmpty)
+		So(current.Tables["orders"].ForeignKeys, ShouldHaveLength, 1)
+
+		var total float64
+		So(device.QueryRow("SELECT total FROM orders WHERE user_id = 1").Scan(&total), ShouldBeNil)
+		So(total, ShouldEqual, 9.5)
+	})
+}
+
+// ========== Schema Docs Tests ==========
+type docUser struct {
+	ID    int64  `zorm:"id,auto_incr"`
+	Email string `zorm:"email,comment:Login email, unique per user"`
+}
+
+func (*docUser) TableName() string { return "users" }
+
+func TestGenerateDocs(t *testing.T) {
+	Convey("Markdown and Mermaid docs from a schema", t, func() {
+		ctx := context.Background()
+		ddb, err := sql.Open("sqlite3", t.TempDir()+"/docs.db")
+		So(err, ShouldBeNil)
+		defer ddb.Close()
+		_, err = ddb.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email VARCHAR(255) NOT NULL);
+			CREATE UNIQUE INDEX uniq_users_email ON users(email);
+			CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE, note TEXT DEFAULT 'a|b');
+			CREATE INDEX idx_orders_user_id ON orders(user_id);`)
+		So(err, ShouldBeNil)
+		schema, err := zorm.NewDDLManager(ddb, nil).GetCurrentSchema(ctx)
+		So(err, ShouldBeNil)
+
+		Convey("comment tags fill column comments", func() {
+			out, err := zorm.GenerateDocs(schema, zorm.DocOptions{Title: "Device DB", Models: []interface{}{&docUser{}}})
+			So(err, ShouldBeNil)
+			doc := string(out)
+			So(doc, ShouldStartWith, "# Device DB\n\n```mermaid\nerDiagram\n")
+			So(doc, ShouldContainSubstring, "        VARCHAR email UK \"Login email, unique per user\"\n")
+			So(doc, ShouldContainSubstring, "        INTEGER user_id FK\n")
+			So(doc, ShouldContainSubstring, "    users ||--o{ orders : \"user_id\"\n")
+			So(doc, ShouldContainSubstring, "- [orders](#orders)\n- [users](#users)\n")
+			So(doc, ShouldContainSubstring, "| id | INTEGER | NO |  | PK, auto increment |  |\n")
+			So(doc, ShouldContainSubstring, "| email | VARCHAR(255) | NO |  | UK | Login email, unique per user |\n")
+			So(doc, ShouldContainSubstring, `| note | TEXT | YES | 'a\|b' |  |  |`)
+			So(doc, ShouldContainSubstring, "| idx_orders_user_id | user_id | NO |\n")
+			So(doc, ShouldContainSubstring, "- `user_id` references [users](#users) (`id`) on delete cascade\n")
+
+			// The schema itself is left untouched
+			So(schema.Tables["users"].Columns["email"].Comment, ShouldBeEmpty)
+		})
+
+		Convey("Mermaid diagram only", func() {
+			out, err := zorm.GenerateERDiagram(schema, zorm.DocOptions{Tables: []string{"users"}})
+			So(err, ShouldBeNil)
+			So(string(out), ShouldEqual, "erDiagram\n    users {\n        INTEGER id PK\n        VARCHAR email UK\n    }\n")
+
+			_, err = zorm.GenerateERDiagram(schema, zorm.DocOptions{Tables: []string{"missing"}})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("options end at the comment", func() {
+			type Contact struct {
+				ID    int64   `zorm:"id,auto_incr"`
+				Email *string `zorm:"email,comment:Primary email, unique, not_null, default:'x'"`
+			}
+			script, err := zorm.NewDDLManager(ddb, nil).DryRun(ctx, &Contact{})
+			So(err, ShouldBeNil)
+			So(script, ShouldContainSubstring, "`email` TEXT\n")
+			So(script, ShouldNotContainSubstring, "UNIQUE")
+			So(script, ShouldNotContainSubstring, "NOT NULL")
+			So(script, ShouldNotContainSubstring, "DEFAULT")
+		})
+
+		Convey("generated structs keep comments", func() {
+			schema.Tables["users"].Columns["email"].Comment = "Login email, unique per user"
+			src, err := zorm.GenerateStructs(schema, zorm.GenerateOptions{Tables: []string{"users"}})
+			So(err, ShouldBeNil)
+			So(string(src), ShouldContainSubstring, `zorm:"email,type:VARCHAR(255),unique,comment:Login email, unique per user"`)
+		})
+	})
+}
+
+// ========== Preload Tests ==========
+type plUser struct {
+	ID      int64      `zorm:"id,auto_incr"`
+	Name    string     `zorm:"name"`
+	Orders  []plOrder  `zorm:"-,has_many:user_id"`
+	Profile *plProfile `zorm:"-,has_one:user_id"`
+}
+
+func (plUser) TableName() string { return "users" }
+
+type plOrder struct {
+	ID     int64     `zorm:"id,auto_incr"`
+	UserID int       `zorm:"user_id"`
+	Status int       `zorm:"status"`
+	Items  []*plItem `zorm:"-,has_many:order_id"`
+}
+
+func (plOrder) TableName() string { return "orders" }
+
+type plItem struct {
+	ID      int64  `zorm:"id,auto_incr"`
+	OrderID *int64 `zorm:"order_id"`
+	Name    string `zorm:"name"`
+}
+
+func (plItem) TableName() string { return "items" }
+
+type plProfile struct {
+	UserID int64  `zorm:"user_id"`
+	Bio    string `zorm:"bio"`
+}
+
+func (plProfile) TableName() string { return "profiles" }
+
+// queryCounter 统计查询次数
+type queryCounter struct {
+	*sql.DB
+	queries []string
+}
+
+func (c *queryCounter) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	c.queries = append(c.queries, query)
+	return c.DB.QueryContext(ctx, query, args...)
+}
+
+func TestPreload(t *testing.T) {
+	Convey("Preload has_many and has_one relations", t, func() {
+		pdb, err := sql.Open("sqlite3", t.TempDir()+"/preload.db")
+		So(err, ShouldBeNil)
+		defer pdb.Close()
+		_, err = pdb.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+			CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, status INTEGER);
+			CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, order_id INTEGER, name TEXT);
+			CREATE TABLE profiles (user_id INTEGER, bio TEXT);
+			INSERT INTO users (name) VALUES ('a'), ('b'), ('c');
+			INSERT INTO orders (user_id, status) VALUES (1, 1), (1, 0), (1, 1), (3, 1);
+			INSERT INTO items (order_id, name) VALUES (1, 'x'), (3, 'y'), (3, 'z'), (NULL, 'orphan');
+			INSERT INTO profiles (user_id, bio) VALUES (2, 'hi');`)
+		So(err, ShouldBeNil)
+		counter := &queryCounter{DB: pdb}
+		tbl := zorm.Table(counter, "users")
+
+		Convey("slice of parents", func() {
+			var users []plUser
+			n, err := tbl.Select(&users, zorm.OrderBy("id"),
+				zorm.Preload("Orders", zorm.Where("status = ?", 1), zorm.OrderBy("id desc")),
+				zorm.Preload("Orders.Items", zorm.OrderBy("name")),
+				zorm.Preload("Profile"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			// 主查询加每个关联一次
+			So(counter.queries, ShouldHaveLength, 4)
+			So(counter.queries[1], ShouldContainSubstring, "where `user_id` in (?,?,?) and status = ?")
+
+			So(users[0].Orders, ShouldHaveLength, 2)
+			So(users[0].Orders[0].ID, ShouldEqual, 3)
+			So(users[0].Orders[1].ID, ShouldEqual, 1)
+			So(users[0].Orders[0].Items, ShouldHaveLength, 2)
+			So(users[0].Orders[0].Items[0].Name, ShouldEqual, "y")
+			So(users[0].Orders[1].Items[0].Name, ShouldEqual, "x")
+			So(users[0].Profile, ShouldBeNil)
+
+			So(users[1].Orders, ShouldNotBeNil)
+			So(users[1].Orders, ShouldBeEmpty)
+			So(users[1].Profile.Bio, ShouldEqual, "hi")
+
+			So(users[2].Orders, ShouldHaveLength, 1)
+			So(users[2].Orders[0].Items, ShouldBeEmpty)
+		})
+
+		Convey("single parent and pointer slices", func() {
+			var user plUser
+			n, err := tbl.Select(&user, zorm.Where("id = ?", 1), zorm.Preload("Orders", zorm.Fields("id")))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(user.Orders, ShouldHaveLength, 3)
+			So(user.Orders[0].UserID, ShouldEqual, 1)
+
+			var users []*plUser
+			_, err = tbl.Select(&users, zorm.Where("id > ?", 1), zorm.Preload("Orders"))
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 2)
+			So(users[1].Orders[0].UserID, ShouldEqual, 3)
+		})
+
+		Convey("invalid relations", func() {
+			var users []plUser
+			_, err := tbl.Select(&users, zorm.Preload("Name"))
+			So(err, ShouldNotBeNil)
+			_, err = tbl.Select(&users, zorm.Preload("Missing"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("parent keys are queried in batches", func() {
+			// 1200个父键分3批查询，每个用户2个订单
+			_, err := pdb.Exec(`WITH RECURSIVE n(i) AS (SELECT 4 UNION ALL SELECT i+1 FROM n WHERE i < 1200)
+				INSERT INTO users (id, name) SELECT i, 'u' FROM n;
+				WITH RECURSIVE n(i) AS (SELECT 4 UNION ALL SELECT i+1 FROM n WHERE i < 1200)
+				INSERT INTO orders (user_id, status) SELECT i, 1 FROM n UNION ALL SELECT i, 0 FROM n;`)
+			So(err, ShouldBeNil)
+
+			var users []plUser
+			n, err := tbl.Select(&users, zorm.OrderBy("id"), zorm.Preload("Orders", zorm.OrderBy("status desc")))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1200)
+			So(counter.queries, ShouldHaveLength, 4)
+			So(strings.Count(counter.queries[1], "?"), ShouldEqual, 500)
+			So(strings.Count(counter.queries[3], "?"), ShouldEqual, 200)
+			So(users[0].Orders, ShouldHaveLength, 3)
+			for _, u := range users[3:] {
+				So(u.Orders, ShouldHaveLength, 2)
+				So(int64(u.Orders[0].UserID), ShouldEqual, u.ID)
+				So(u.Orders[0].Status, ShouldEqual, 1)
+			}
+
+			// Limit 作用于每一批子查询
+			users = nil
+			_, err = tbl.Select(&users, zorm.OrderBy("id"), zorm.Preload("Orders", zorm.OrderBy("user_id", "id"), zorm.Limit(10)))
+			So(err, ShouldBeNil)
+			total := 0
+			for _, u := range users {
+				total += len(u.Orders)
+			}
+			So(total, ShouldEqual, 30)
+			So(users[500].Orders, ShouldHaveLength, 2)
+			So(users[1000].Orders, ShouldHaveLength, 2)
+			So(users[1199].Orders, ShouldBeEmpty)
+		})
+
+		Convey("no parents, no queries", func() {
+			var users []plUser
+			n, err := tbl.Select(&users, zorm.Where("id > ?", 10), zorm.Preload("Orders"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+			So(counter.queries, ShouldHaveLength, 1)
+		})
+	})
+}
+
+// ========== Nested Join Scan Tests ==========
+type jnUser struct {
+	ID   int64  `zorm:"id,auto_incr"`
+	Name string `zorm:"name"`
+}
+
+type jnOrder struct {
+	ID     int64   `zorm:"id,auto_incr"`
+	UserID int64   `zorm:"user_id"`
+	Total  float64 `zorm:"total"`
+}
+
+type jnUserOrder struct {
+	jnUser `zorm:"users.*"`
+	Order  *jnOrder `zorm:"orders.*"`
+	Count  int      `zorm:"-"`
+}
+
+func TestSelectNestedJoin(t *testing.T) {
+	Convey("Select joined rows into nested structs", t, func() {
+		jdb, err := sql.Open("sqlite3", t.TempDir()+"/join.db")
+		So(err, ShouldBeNil)
+		defer jdb.Close()
+		_, err = jdb.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+			CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, total REAL);
+			INSERT INTO users (name) VALUES ('a'), ('b');
+			INSERT INTO orders (user_id, total) VALUES (1, 9.5), (1, 0);`)
+		So(err, ShouldBeNil)
+		tbl := zorm.Table(jdb, "users")
+
+		Convey("LEFT JOIN leaves unmatched pointer structs nil", func() {
+			var rows []jnUserOrder
+			n, err := tbl.Select(&rows, zorm.LeftJoin("orders", "users.id = orders.user_id"), zorm.OrderBy("users.id", "orders.id"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(rows[0].Name, ShouldEqual, "a")
+			So(rows[0].Order.ID, ShouldEqual, 1)
+			So(rows[0].Order.Total, ShouldEqual, 9.5)
+			So(rows[1].Order.ID, ShouldEqual, 2)
+			// 各行的嵌套结构体互不共享
+			So(rows[1].Order, ShouldNotPointTo, rows[0].Order)
+			So(rows[2].ID, ShouldEqual, 2)
+			So(rows[2].Order, ShouldBeNil)
+		})
+
+		Convey("single row and pointer slices", func() {
+			var row jnUserOrder
+			n, err := tbl.Select(&row, zorm.InnerJoin("orders", "users.id = orders.user_id"), zorm.Where("orders.total = ?", 0))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(row.ID, ShouldEqual, 1)
+			So(row.Order.ID, ShouldEqual, 2)
+
+			var rows []*jnUserOrder
+			_, err = tbl.Select(&rows, zorm.LeftJoin("orders", "users.id = orders.user_id"), zorm.Where("users.id = ?", 2))
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 1)
+			So(rows[0].Name, ShouldEqual, "b")
+			So(rows[0].Order, ShouldBeNil)
+		})
+
+		Convey("value structs of tables or aliases", func() {
+			type row struct {
+				User  jnUser  `zorm:"u.*"`
+				Order jnOrder `zorm:"o.*"`
+			}
+			var rows []row
+			_, err := zorm.Table(jdb, "users u").Select(&rows, zorm.InnerJoin("orders o", "u.id = o.user_id"), zorm.OrderBy("o.id"))
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 2)
+			So(rows[1].User.Name, ShouldEqual, "a")
+			So(rows[1].Order.ID, ShouldEqual, 2)
+		})
+
+		Convey("partly NULL LEFT JOIN rows still allocate pointer structs", func() {
+			_, err := jdb.Exec("INSERT INTO orders (user_id, total) VALUES (2, NULL)")
+			So(err, ShouldBeNil)
+			var rows []jnUserOrder
+			_, err = tbl.Select(&rows, zorm.LeftJoin("orders", "users.id = orders.user_id"), zorm.Where("users.id = ?", 2))
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 1)
+			So(rows[0].Order, ShouldNotBeNil)
+			So(rows[0].Order.ID, ShouldEqual, 3)
+			So(rows[0].Order.UserID, ShouldEqual, 2)
+			So(rows[0].Order.Total, ShouldEqual, 0)
+		})
+
+		Convey("prefix: maps prefixed columns", func() {
+			_, err := jdb.Exec(`CREATE VIEW user_orders AS SELECT users.id, users.name,
+				orders.id AS order_id, orders.user_id AS order_user_id, orders.total AS order_total
+				FROM users LEFT JOIN orders ON users.id = orders.user_id`)
+			So(err, ShouldBeNil)
+			type row struct {
+				jnUser
+				Order *jnOrder `zorm:",prefix:order_"`
+			}
+			var rows []row
+			_, err = zorm.Table(jdb, "user_orders").Select(&rows, zorm.OrderBy("id", "order_id"))
+			So(err, ShouldBeNil)
+			So(rows, ShouldHaveLength, 3)
+			So(rows[1].Name, ShouldEqual, "a")
+			So(rows[1].Order.ID, ShouldEqual, 2)
+			So(rows[2].Order, ShouldBeNil)
+
+			// 与 table.* 一起使用时列名写成 v.order_id
+			type aliased struct {
+				User  jnUser   `zorm:"v.*"`
+				Order *jnOrder `zorm:"v.*,prefix:order_"`
+			}
+			var one aliased
+			_, err = zorm.Table(jdb, "user_orders v").Select(&one, zorm.Where("v.order_total = ?", 9.5))
+			So(err, ShouldBeNil)
+			So(one.User.ID, ShouldEqual, 1)
+			So(one.Order.ID, ShouldEqual, 1)
+			So(one.Order.Total, ShouldEqual, 9.5)
+		})
+	})
+}
+
+// ========== Keyed Map Tests ==========
+type kmUser struct {
+	ID     int64     `zorm:"id"`
+	Name   string    `zorm:"name"`
+	Orders []kmOrder `zorm:"-,has_many:user_id"`
+}
+
+type kmOrder struct {
+	ID     int64   `zorm:"id"`
+	UserID *int64  `zorm:"user_id"`
+	Total  float64 `zorm:"total"`
+}
+
+func (kmOrder) TableName() string { return "orders" }
+
+func TestSelectKeyedMap(t *testing.T) {
+	Convey("Select into maps keyed by a column", t, func() {
+		kdb, err := sql.Open("sqlite3", t.TempDir()+"/keyed.db")
+		So(err, ShouldBeNil)
+		defer kdb.Close()
+		_, err = kdb.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+			CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, user_id INTEGER, total REAL);
+			INSERT INTO users (name) VALUES ('a'), ('b'), (NULL);
+			INSERT INTO orders (user_id, total) VALUES (1, 9.5), (2, 3), (1, 0), (NULL, 1);`)
+		So(err, ShouldBeNil)
+		users := zorm.Table(kdb, "users")
+		orders := zorm.Table(kdb, "orders")
+
+		Convey("struct values keyed by KeyBy", func() {
+			var m map[int64]kmUser
+			n, err := users.Select(&m, zorm.KeyBy("id"), zorm.Where("id < ?", 3))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			So(m, ShouldHaveLength, 2)
+			So(m[1].Name, ShouldEqual, "a")
+			So(m[2].Name, ShouldEqual, "b")
+
+			// 指针值，Fields 中没有键列时自动补上，并支持预加载
+			var pm map[int]*kmUser
+			_, err = users.Select(&pm, zorm.Fields("name"), zorm.KeyBy("id"), zorm.Preload("Orders", zorm.OrderBy("id")))
+			So(err, ShouldBeNil)
+			So(pm, ShouldHaveLength, 3)
+			So(pm[1].ID, ShouldEqual, 1)
+			So(pm[1].Orders, ShouldHaveLength, 2)
+			So(pm[1].Orders[1].Total, ShouldEqual, 0)
+			So(pm[3].Orders, ShouldBeEmpty)
+		})
+
+		Convey("two columns into key and value", func() {
+			var names map[int64]string
+			n, err := users.Select(&names, zorm.Fields("id", "name"), zorm.OrderBy("id"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(names, ShouldResemble, map[int64]string{1: "a", 2: "b", 3: ""})
+
+			var totals map[string]float64
+			_, err = orders.Select(&totals, zorm.KeyBy("id"), zorm.Fields("total"), zorm.Where("user_id = ?", 1))
+			So(err, ShouldBeNil)
+			So(totals, ShouldResemble, map[string]float64{"1": 9.5, "3": 0})
+
+			// 已有的 map 保留原有的键
+			ids := map[string]int64{"x": 0}
+			_, err = users.Select(ids, zorm.Fields("name", "id"), zorm.Where("name IS NOT NULL"))
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, map[string]int64{"x": 0, "a": 1, "b": 2})
+		})
+
+		Convey("grouped values skip NULL keys", func() {
+			var groups map[int64][]kmOrder
+			n, err := orders.Select(&groups, zorm.KeyBy("user_id"), zorm.OrderBy("id"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 4)
+			So(groups, ShouldHaveLength, 2)
+			So(groups[1], ShouldHaveLength, 2)
+			So(groups[1][0].ID, ShouldEqual, 1)
+			So(groups[1][1].ID, ShouldEqual, 3)
+			So(groups[2][0].Total, ShouldEqual, 3)
+
+			var pgroups map[int64][]*kmOrder
+			_, err = orders.Select(&pgroups, zorm.KeyBy("user_id"), zorm.Where("total > ?", 0))
+			So(err, ShouldBeNil)
+			So(pgroups[1], ShouldHaveLength, 1)
+
+			var ids map[int64][]int64
+			_, err = orders.Select(&ids, zorm.Fields("user_id", "id"), zorm.OrderBy("id"))
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, map[int64][]int64{1: {1, 3}, 2: {2}})
+		})
+
+		Convey("row maps and errors", func() {
+			var row map[string]interface{}
+			n, err := users.Select(&row, zorm.Fields("id", "name"), zorm.Where("id = ?", 1))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(row["name"], ShouldEqual, "a")
+
+			var m map[int64]kmUser
+			_, err = users.Select(&m)
+			So(err, ShouldNotBeNil)
+			_, err = users.Select(&m, zorm.KeyBy("missing"))
+			So(err, ShouldNotBeNil)
+
+			var names map[int64]string
+			_, err = users.Select(&names, zorm.Fields("id"))
+			So(err, ShouldNotBeNil)
+
+			// KeyBy only works with map results
+			var list []kmUser
+			_, err = users.Select(&list, zorm.KeyBy("id"))
+			So(err, ShouldNotBeNil)
+			var one kmUser
+			_, err = users.Select(&one, zorm.KeyBy("id"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("slices of maps are row lists", func() {
+			var rows []map[string]string
+			n, err := users.Select(&rows, zorm.Fields("id", "name"), zorm.Where("id < ?", 3), zorm.OrderBy("id"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			So(rows, ShouldResemble, []map[string]string{{"id": "1", "name": "a"}, {"id": "2", "name": "b"}})
+
+			var anyRows []map[string]interface{}
+			n, err = users.Select(&anyRows, zorm.Fields("id", "name"), zorm.Where("id < ?", 3))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+
+			var intKeyed []map[int64]string
+			_, err = users.Select(&intKeyed, zorm.Fields("id", "name"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// ========== Named Parameter Tests ==========
+type npUser struct {
+	ID   int64  `zorm:"id,auto_incr"`
+	Name string `zorm:"name"`
+	Age  int    `zorm:"age"`
+	City string
+}
+
+func TestNamedParams(t *testing.T) {
+	Convey("Bind :name and @name parameters from maps and structs", t, func() {
+		ndb, err := sql.Open("sqlite3", t.TempDir()+"/named.db")
+		So(err, ShouldBeNil)
+		defer ndb.Close()
+		_, err = ndb.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT, age INTEGER, city TEXT);
+			INSERT INTO users (name, age, city) VALUES ('a', 17, 'sz'), ('b', 30, 'sz'), ('c', 40, 'bj');`)
+		So(err, ShouldBeNil)
+		tbl := zorm.Table(ndb, "users")
+
+		Convey("Where rewrites to positional placeholders", func() {
+			w := zorm.Where("age > :min and city = :city or name = :city", zorm.V{"min": 18, "city": "sz"})
+			var sb strings.Builder
+			w.BuildSQL(&sb)
+			So(sb.String(), ShouldEqual, " where age > ? and city = ? or name = ?")
+			var args []interface{}
+			w.BuildArgs(&args)
+			So(args, ShouldResemble, []interface{}{18, "sz", "sz"})
+
+			var users []npUser
+			n, err := tbl.Select(&users, zorm.Fields("id", "name"), zorm.Where("age > :min and city = :city", zorm.V{"min": 18, "city": "sz"}))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(users[0].Name, ShouldEqual, "b")
+
+			// 复用缓存时每次调用的参数值不同
+			for _, min := range []int{10, 35} {
+				var names []string
+				_, err = tbl.Select(&names, zorm.Fields("name"), zorm.Where("age > @min", map[string]int{"min": min}), zorm.OrderBy("id"))
+				So(err, ShouldBeNil)
+				if min == 10 {
+					So(names, ShouldResemble, []string{"a", "b", "c"})
+				} else {
+					So(names, ShouldResemble, []string{"c"})
+				}
+			}
+		})
+
+		Convey("Cond and Expr inside And/Or with struct sources", func() {
+			filter := struct {
+				MinAge int    `zorm:"min_age"`
+				City   string `zorm:"city"`
+			}{MinAge: 35, City: "sz"}
+			var names []string
+			_, err := tbl.Select(&names, zorm.Fields("name"),
+				zorm.Where(zorm.Or(zorm.Cond("age > :min_age", filter), zorm.Expr("city = :city and age < :MinAge", &filter))),
+				zorm.OrderBy("id"))
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"a", "b", "c"})
+		})
+
+		Convey("Exec binds struct fields by column and field name", func() {
+			u := npUser{ID: 1, Name: "z", City: "gz"}
+			n, err := tbl.Exec("update users set name = :name, city = :City where id = :id", &u)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+
+			var got npUser
+			_, err = tbl.Select(&got, zorm.Fields("id", "name"), zorm.Where("id = :id", u))
+			So(err, ShouldBeNil)
+			So(got.Name, ShouldEqual, "z")
+
+			_, err = tbl.Exec("update users set name = :missing", zorm.V{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("missing names are returned by the query", func() {
+			var names []string
+			_, err := tbl.Select(&names, zorm.Fields("name"), zorm.Where("age > :missing", zorm.V{}))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "named parameter missing not found")
+			_, err = tbl.Select(&names, zorm.Fields("name"),
+				zorm.Where(zorm.And(zorm.Eq("city", "sz"), zorm.Or(zorm.Expr("age > :missing", zorm.V{})))))
+			So(err, ShouldNotBeNil)
+			_, err = tbl.Select(&names, zorm.Fields("city"), zorm.GroupBy("city"), zorm.Having("count(1) > :missing", zorm.V{}))
+			So(err, ShouldNotBeNil)
+			_, err = tbl.Update(zorm.V{"name": "x"}, zorm.Where(zorm.Cond("id = :missing", zorm.V{})))
+			So(err, ShouldNotBeNil)
+			_, err = tbl.Delete(zorm.Where("id = :missing", zorm.V{}))
+			So(err, ShouldNotBeNil)
+
+			var n int
+			So(ndb.QueryRow("SELECT count(1) FROM users WHERE name = 'x'").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 0)
+			So(ndb.QueryRow("SELECT count(1) FROM users").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 3)
+		})
+
+		Convey("quotes, casts and positional queries are left alone", func() {
+			w := zorm.Where("name <> ':x' and note = \"@y\" and age::text = :age and @@autocommit = 1", zorm.V{"age": 1})
+			var sb strings.Builder
+			w.BuildSQL(&sb)
+			So(sb.String(), ShouldEqual, " where name <> ':x' and note = \"@y\" and age::text = ? and @@autocommit = 1")
+
+			// 已有 ? 占位符时 map 作为普通参数
+			c := zorm.Cond("data = ? and a = :b", zorm.V{"b": 1})
+			So(c.Op, ShouldEqual, "data = ? and a = :b")
+			So(c.Args, ShouldHaveLength, 1)
+
+			n, err := tbl.Exec("update users set age = age + 1 where city = :city", map[string]string{"city": "bj"})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+		})
+
+		Convey("backslashes and comments are left alone", func() {
+			w := zorm.Where(`path = 'C:\' and name <> 'it''s :x' and age = :age -- :comment ?
+				and city = :city /* :block ? */ and id > :id`, zorm.V{"age": 1, "city": "sz", "id": 0})
+			var sb strings.Builder
+			w.BuildSQL(&sb)
+			So(sb.String(), ShouldEqual, ` where path = 'C:\' and name <> 'it''s :x' and age = ? -- :comment ?
+				and city = ? /* :block ? */ and id > ?`)
+			var args []interface{}
+			w.BuildArgs(&args)
+			So(args, ShouldResemble, []interface{}{1, "sz", 0})
+
+			c := zorm.Cond(`path = 'C:\' and id = :id`, zorm.V{"id": 1})
+			So(c.Op, ShouldEqual, `path = 'C:\' and id = ?`)
+			So(c.Args, ShouldResemble, []interface{}{1})
+
+			// 未闭合的引号和注释保留到末尾
+			c = zorm.Cond("a = :a /* :b", zorm.V{"a": 1})
+			So(c.Op, ShouldEqual, "a = ? /* :b")
+			c = zorm.Cond("a = :a and b = 'x\\", zorm.V{"a": 1})
+			So(c.Op, ShouldEqual, "a = ? and b = 'x\\")
+
+			n, err := tbl.Exec("update users set age = :age -- keep :comment\nwhere id = :id", zorm.V{"age": 50, "id": 1})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+		})
+	})
+}